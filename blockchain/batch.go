@@ -0,0 +1,262 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	t "gold/types"
+	"math/bits"
+	"runtime"
+
+	"github.com/decred/dcrd/crypto/blake256"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/schnorr"
+)
+
+// Below this many signatures checking them one by one is quicker than the batch setup
+const minBatchSize = 8
+
+// Signatures collected to be verified together. A valid signature has
+//
+//	s*G + e*Q = R
+//
+// where R is the point with x coordinate r and an even y. The batch checks the sum of every signature's
+// equation, each scaled by its own random weight so invalid signatures can't cancel each other out. The
+// sum is one multi-scalar multiplication, which costs much less than a scalar multiplication per
+// signature once there are a few hundred of them.
+type SigBatch struct {
+	entries []sigEntry
+}
+
+type sigEntry struct {
+	hash [32]byte
+	sig  *schnorr.Signature
+	key  *secp256k1.PublicKey
+	// Position of the op the signature is for, and the error it gets if the signature is bad
+	op  int
+	err error
+}
+
+func (batch *SigBatch) Add(hash [32]byte, sig *schnorr.Signature, key *secp256k1.PublicKey) {
+	batch.add(hash, sig, key, -1, nil)
+}
+
+func (batch *SigBatch) add(hash [32]byte, sig *schnorr.Signature, key *secp256k1.PublicKey, op int, err error) {
+	batch.entries = append(batch.entries, sigEntry{hash: hash, sig: sig, key: key, op: op, err: err})
+}
+
+// Where an op's signatures go while its block is validated. A nil one verifies them on the spot.
+// Signatures already in the cache are never batched.
+type batchedOp struct {
+	batch *SigBatch
+	op    int
+}
+
+// Ops that can leave their signatures to be verified with the rest of the block's
+type batchValidator interface {
+	validate(state *t.State, batched *batchedOp) error
+}
+
+func (batched *batchedOp) verify(hash [32]byte, sig *schnorr.Signature, key *secp256k1.PublicKey, err error) error {
+	if batched == nil {
+		if !verifySig(hash, sig, key) {
+			return err
+		}
+
+		return nil
+	}
+
+	if SigCache.contains(hash, sig, key) {
+		return nil
+	}
+
+	batched.batch.add(hash, sig, key, batched.op, err)
+	return nil
+}
+
+func (batch *SigBatch) Len() int {
+	return len(batch.entries)
+}
+
+// Whether every signature in the batch is valid
+func (batch *SigBatch) Verify() bool {
+	if len(batch.entries) < minBatchSize {
+		return batch.FirstInvalid() == -1
+	}
+
+	// Weights are derived from everything in the batch, so they can't be known before the signatures are
+	// picked. The first weight can be 1 without losing anything.
+	seed := sha256.New()
+
+	for _, entry := range batch.entries {
+		seed.Write(entry.hash[:])
+		seed.Write(entry.sig.Serialize())
+		seed.Write(entry.key.SerializeCompressed())
+	}
+
+	seedHash := seed.Sum(nil)
+	scalars := make([]secp256k1.ModNScalar, 0, 2*len(batch.entries))
+	points := make([]secp256k1.JacobianPoint, 0, 2*len(batch.entries))
+	var sSum secp256k1.ModNScalar
+
+	for i, entry := range batch.entries {
+		var weight secp256k1.ModNScalar
+		weight.SetInt(1)
+
+		if i > 0 {
+			weightHash := sha256.Sum256(binary.LittleEndian.AppendUint32(seedHash, uint32(i)))
+			weight.SetBytes(&weightHash)
+		}
+
+		r := entry.sig.R()
+		s := entry.sig.S()
+
+		// e = BLAKE-256(r || m), as schnorr.Verify computes it
+		var input [64]byte
+		r.PutBytesUnchecked(input[:32])
+		copy(input[32:], entry.hash[:])
+		commitment := blake256.Sum256(input[:])
+
+		var e secp256k1.ModNScalar
+
+		if overflow := e.SetBytes(&commitment); overflow != 0 || !entry.key.IsOnCurve() {
+			return false
+		}
+
+		// -R, which is the point at r with an odd y
+		var negR secp256k1.JacobianPoint
+		negR.X.Set(&r)
+		negR.Z.SetInt(1)
+
+		if !secp256k1.DecompressY(&r, true, &negR.Y) {
+			return false
+		}
+
+		var key secp256k1.JacobianPoint
+		entry.key.AsJacobian(&key)
+
+		// sum(w*s)*G + sum(w*e*Q) + sum(w*-R) has to be the point at infinity
+		sSum.Add(new(secp256k1.ModNScalar).Mul2(&weight, &s))
+		scalars = append(scalars, *new(secp256k1.ModNScalar).Mul2(&weight, &e), weight)
+		points = append(points, key, negR)
+	}
+
+	var sG, result secp256k1.JacobianPoint
+	secp256k1.ScalarBaseMultNonConst(&sSum, &sG)
+	multiScalarMult(scalars, points, &result)
+	addPoint(&result, &sG)
+
+	return isInfinity(&result)
+}
+
+// Index of the first invalid signature in the order they were added, or -1. This is how a batch that
+// fails to verify finds its bad signature.
+func (batch *SigBatch) FirstInvalid() int {
+	for i, entry := range batch.entries {
+		if !entry.sig.Verify(entry.hash[:], entry.key) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Verifies the batch in chunks across the workers, and if any fails finds the op with the first bad
+// signature and its error
+func (batch *SigBatch) firstFailure() (int, error) {
+	chunks := max(1, min(runtime.GOMAXPROCS(0), len(batch.entries)/minSigChunk))
+	size := (len(batch.entries) + chunks - 1) / chunks
+	valid := make([]bool, chunks)
+
+	chunk := func(i int) *SigBatch {
+		return &SigBatch{entries: batch.entries[min(i*size, len(batch.entries)):min((i+1)*size, len(batch.entries))]}
+	}
+
+	parallel(chunks, func(i int) {
+		valid[i] = chunk(i).Verify()
+	})
+
+	for i := range chunks {
+		if valid[i] {
+			continue
+		}
+
+		failed := chunk(i)
+
+		if bad := failed.FirstInvalid(); bad != -1 {
+			return failed.entries[bad].op, failed.entries[bad].err
+		}
+
+		return 0, errors.New("signatures fail to verify together")
+	}
+
+	return 0, nil
+}
+
+// sum(scalars[i] * points[i]) with Pippenger's bucket method. Each window of c bits of every scalar puts
+// its point into one of 2^c buckets, and the buckets are summed so bucket j counts j times.
+func multiScalarMult(scalars []secp256k1.ModNScalar, points []secp256k1.JacobianPoint, result *secp256k1.JacobianPoint) {
+	c := min(max(bits.Len(uint(len(points)))-3, 2), 16)
+	scalarBytes := make([][32]byte, len(scalars))
+
+	for i := range scalars {
+		scalarBytes[i] = scalars[i].Bytes()
+	}
+
+	buckets := make([]secp256k1.JacobianPoint, 1<<c)
+	*result = secp256k1.JacobianPoint{}
+
+	for window := (256+c-1)/c - 1; window >= 0; window-- {
+		for range c {
+			doublePoint(result)
+		}
+
+		clear(buckets)
+
+		for i := range points {
+			if digit := windowBits(&scalarBytes[i], window*c, c); digit != 0 {
+				addPoint(&buckets[digit], &points[i])
+			}
+		}
+
+		var running, sum secp256k1.JacobianPoint
+
+		for j := len(buckets) - 1; j > 0; j-- {
+			addPoint(&running, &buckets[j])
+			addPoint(&sum, &running)
+		}
+
+		addPoint(result, &sum)
+	}
+}
+
+// c bits of a big endian scalar starting offset bits up from the least significant
+func windowBits(scalar *[32]byte, offset int, c int) int {
+	digit := 0
+
+	for bit := offset + c - 1; bit >= offset; bit-- {
+		digit <<= 1
+
+		if bit < 256 {
+			digit |= int(scalar[31-bit/8]>>(bit%8)) & 1
+		}
+	}
+
+	return digit
+}
+
+func addPoint(p *secp256k1.JacobianPoint, q *secp256k1.JacobianPoint) {
+	var sum secp256k1.JacobianPoint
+	secp256k1.AddNonConst(p, q, &sum)
+	*p = sum
+}
+
+func doublePoint(p *secp256k1.JacobianPoint) {
+	var double secp256k1.JacobianPoint
+	secp256k1.DoubleNonConst(p, &double)
+	*p = double
+}
+
+func isInfinity(p *secp256k1.JacobianPoint) bool {
+	return (p.X.IsZero() && p.Y.IsZero()) || p.Z.IsZero()
+}
@@ -0,0 +1,575 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	t "gold/types"
+	"math/big"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	ErrDuplicateBlock = errors.New("block is already known")
+	ErrOrphanBlock    = errors.New("block's parent is not known")
+	ErrInvalidBlock   = errors.New("block or one of its ancestors is invalid")
+	// The header can never be valid, no matter when or from whom it arrives
+	ErrInvalidHeader  = errors.New("header is invalid")
+	ErrMerkleMismatch = errors.New("merkle root does not match the block's ops")
+)
+
+// Told about every block that joins or leaves the main chain, with state as the new tip and the block's
+// undo data, which has what each op overwrote. Listeners are called with the chain locked, so they mustn't
+// call back into the chain.
+type ChainListener interface {
+	BlockConnected(block *t.Block, undo *BlockUndo, state *t.State)
+	BlockDisconnected(block *t.Block, undo *BlockUndo, state *t.State)
+}
+
+type blockNode struct {
+	hash   [32]byte
+	header t.Header
+	// Nil for the genesis, and for blocks only known by their header so far
+	block    *t.Block
+	parent   *blockNode
+	children []*blockNode
+	height   int
+	// Total work of the chain ending in this block
+	work *big.Int
+	// Only set while the block is connected
+	undo    *BlockUndo
+	invalid bool
+}
+
+// Tracks every known block and keeps the state at the tip of the chain with the most work.
+// Heights count from the genesis at 0, so the tip's height is always State.Height.
+// Headers can be added ahead of their blocks, so a syncing node can find the best chain before downloading it.
+type Chain struct {
+	mu     sync.RWMutex
+	params *Params
+	state  t.State
+	nodes  map[[32]byte]*blockNode
+	main   []*blockNode
+	// The valid header with the most work, whether its block has arrived or not
+	bestHeader *blockNode
+	listeners  []ChainListener
+	// Nil for chains that only live in memory
+	store *chainStore
+	// Where the chain gets the time from when checking timestamps, replaceable for tests
+	Now func() time.Time
+	// Set while the store is replayed into the chain
+	replaying bool
+}
+
+func NewChain(params *Params) *Chain {
+	genesis := &blockNode{
+		hash:   params.GenesisHash(),
+		header: params.Genesis,
+		height: 0,
+		work:   big.NewInt(0),
+	}
+
+	return &Chain{
+		params:     params,
+		state:      genesisState(params),
+		nodes:      map[[32]byte]*blockNode{genesis.hash: genesis},
+		main:       []*blockNode{genesis},
+		bestHeader: genesis,
+		Now:        time.Now,
+	}
+}
+
+// The state before any block has been connected
+func genesisState(params *Params) t.State {
+	return t.State{
+		AccountSet: make(t.AccountSet),
+		KeyNameSet: make(t.KeyNameSet),
+		PolicySet:  make(t.PolicySet),
+		ChainID:    params.ChainID,
+	}
+}
+
+func (c *Chain) Params() *Params {
+	return c.params
+}
+
+func (c *Chain) AddListener(listener ChainListener) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.listeners = append(c.listeners, listener)
+}
+
+// Adds a listener after telling it about every block already on the main chain, as if it had been listening
+// since the genesis. For indexes that are kept in memory and have to be rebuilt on startup. The blocks are
+// connected again to a fresh state to get each one's state and undo data, with the chain locked throughout.
+func (c *Chain) AddListenerFromGenesis(listener ChainListener) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state := genesisState(c.params)
+
+	for _, node := range c.main[1:] {
+		undo, err := ConnectBlock(node.block, &state)
+
+		if err != nil {
+			return err
+		}
+
+		listener.BlockConnected(node.block, undo, &state)
+	}
+
+	c.listeners = append(c.listeners, listener)
+	return nil
+}
+
+func (c *Chain) Tip() ([32]byte, int) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	tip := c.main[len(c.main)-1]
+	return tip.hash, tip.height
+}
+
+// Runs fn with the tip state and hash. The state mustn't be modified or kept after fn returns.
+func (c *Chain) ReadState(fn func(state *t.State, tip [32]byte)) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	fn(&c.state, c.main[len(c.main)-1].hash)
+}
+
+// A copy of the tip state and the tip's hash, for work that can't hold the chain up, like mining
+func (c *Chain) Snapshot() (t.State, [32]byte) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return CopyState(&c.state), c.main[len(c.main)-1].hash
+}
+
+// True if the whole block is known, on the main chain or not
+func (c *Chain) HasBlock(hash [32]byte) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	node, exists := c.nodes[hash]
+	return exists && (node.block != nil || node.height == 0)
+}
+
+// True if the header is known, whether its block has arrived or not
+func (c *Chain) HasHeader(hash [32]byte) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	_, exists := c.nodes[hash]
+	return exists
+}
+
+// The header with the most work, which the main chain catches up to as blocks arrive
+func (c *Chain) BestHeader() ([32]byte, int) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.bestHeader.hash, c.bestHeader.height
+}
+
+// Height of any known header, on the main chain or not
+func (c *Chain) HeaderHeight(hash [32]byte) (int, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	node, exists := c.nodes[hash]
+
+	if !exists {
+		return 0, false
+	}
+
+	return node.height, true
+}
+
+// Hashes of the blocks still missing between the main chain and the best header, lowest first, up to max
+func (c *Chain) MissingBlocks(max int) [][32]byte {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	missing := make([][32]byte, 0)
+
+	for node := c.bestHeader; !c.onMain(node); node = node.parent {
+		if node.block == nil {
+			missing = append(missing, node.hash)
+		}
+	}
+
+	slices.Reverse(missing)
+	return missing[:min(len(missing), max)]
+}
+
+func (c *Chain) GetBlock(hash [32]byte) (*t.Block, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	node, exists := c.nodes[hash]
+
+	if !exists || node.block == nil {
+		return nil, false
+	}
+
+	return node.block, true
+}
+
+func (c *Chain) GetHeader(hash [32]byte) (t.Header, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	node, exists := c.nodes[hash]
+
+	if !exists {
+		return t.Header{}, false
+	}
+
+	return node.header, true
+}
+
+// Hash of the main chain block at height
+func (c *Chain) BlockHashAt(height int) ([32]byte, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if height < 0 || height >= len(c.main) {
+		return [32]byte{}, false
+	}
+
+	return c.main[height].hash, true
+}
+
+// Height of the block if it's on the main chain
+func (c *Chain) HeightOf(hash [32]byte) (int, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	node, exists := c.nodes[hash]
+
+	if !exists || !c.onMain(node) {
+		return 0, false
+	}
+
+	return node.height, true
+}
+
+func (c *Chain) onMain(node *blockNode) bool {
+	return node.height < len(c.main) && c.main[node.height] == node
+}
+
+// Hashes of main chain blocks, dense near the tip and sparse further back, ending in the genesis.
+// A peer finds the last one it shares and sends headers from there.
+func (c *Chain) Locator() [][32]byte {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return locatorFrom(c.main[len(c.main)-1])
+}
+
+// Like Locator, but from the best header, so headers are asked for from where the last ones left off
+func (c *Chain) HeaderLocator() [][32]byte {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return locatorFrom(c.bestHeader)
+}
+
+func locatorFrom(node *blockNode) [][32]byte {
+	locator := make([][32]byte, 0, 32)
+	step := 1
+
+	for node.height > 0 {
+		locator = append(locator, node.hash)
+
+		if len(locator) >= 10 {
+			step *= 2
+		}
+
+		for i := 0; i < step && node.height > 0; i++ {
+			node = node.parent
+		}
+	}
+
+	return append(locator, node.hash)
+}
+
+// Up to max main chain headers following the first locator hash on the main chain, stopping after stop
+func (c *Chain) HeadersAfter(locator [][32]byte, stop [32]byte, max int) []t.Header {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	start := 1
+
+	for _, hash := range locator {
+		if node, exists := c.nodes[hash]; exists && c.onMain(node) {
+			start = node.height + 1
+			break
+		}
+	}
+
+	headers := make([]t.Header, 0)
+
+	for height := start; height < len(c.main) && len(headers) < max; height++ {
+		headers = append(headers, c.main[height].header)
+
+		if c.main[height].hash == stop {
+			break
+		}
+	}
+
+	return headers
+}
+
+// Adds a header without its block. It's checked for proof of work and timestamps, and if it ends the chain
+// with the most work it becomes the best header. Returns ErrDuplicateBlock if the header is already known.
+func (c *Chain) ProcessHeader(header t.Header) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, err := c.addHeader(header)
+
+	if err == nil && c.store != nil {
+		err = c.store.appendHeader(header)
+	}
+
+	return err
+}
+
+func (c *Chain) addHeader(header t.Header) (*blockNode, error) {
+	hash := HashBlockHeader(header)
+
+	if node, exists := c.nodes[hash]; exists {
+		if node.invalid {
+			return node, ErrInvalidBlock
+		}
+
+		return node, ErrDuplicateBlock
+	}
+
+	parent, exists := c.nodes[header.PrevBlockHash]
+
+	if !exists {
+		return nil, ErrOrphanBlock
+	}
+
+	if parent.invalid {
+		return nil, ErrInvalidBlock
+	}
+
+	if err := c.checkHeader(header, parent); err != nil {
+		return nil, err
+	}
+
+	node := &blockNode{
+		hash:   hash,
+		header: header,
+		parent: parent,
+		height: parent.height + 1,
+		work:   new(big.Int).Add(parent.work, BlockWork(c.params.PowTarget)),
+	}
+
+	c.nodes[hash] = node
+	parent.children = append(parent.children, node)
+
+	if node.work.Cmp(c.bestHeader.work) > 0 {
+		c.bestHeader = node
+	}
+
+	return node, nil
+}
+
+// Adds a block to the chain, or fills in the block for a header that's already known. If that leaves a chain
+// of whole blocks with more work than the current one, the state is moved over to it. Blocks off the main
+// chain only have their header and merkle root checked until then.
+func (c *Chain) ProcessBlock(block *t.Block) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	node, err := c.addBlock(block)
+
+	if err != nil {
+		return err
+	}
+
+	if c.store != nil {
+		if err := c.store.appendBlock(block); err != nil {
+			return err
+		}
+	}
+
+	return c.connectBest(node)
+}
+
+func (c *Chain) addBlock(block *t.Block) (*blockNode, error) {
+	node, err := c.addHeader(block.Header)
+
+	if err == ErrDuplicateBlock && node.block != nil {
+		return nil, err
+	}
+
+	if err != nil && err != ErrDuplicateBlock {
+		return nil, err
+	}
+
+	if CalculateMerkleRoot(block.Operations) != block.Header.MerkleRoot {
+		// Keep the header if it was new, the right block for it may still turn up
+		return nil, ErrMerkleMismatch
+	}
+
+	node.block = block
+	return node, nil
+}
+
+// Once node and every block back to the main chain are whole, moves the main chain to the end of the whole
+// chain with the most work after node, if it has more work than the current tip
+func (c *Chain) connectBest(node *blockNode) error {
+	for n := node; !c.onMain(n); n = n.parent {
+		if n.block == nil {
+			return nil
+		}
+	}
+
+	best := bestWholeDescendant(node)
+
+	if best.work.Cmp(c.main[len(c.main)-1].work) <= 0 {
+		return nil
+	}
+
+	return c.reorganize(best)
+}
+
+func bestWholeDescendant(node *blockNode) *blockNode {
+	best := node
+
+	for _, child := range node.children {
+		if child.block == nil || child.invalid {
+			continue
+		}
+
+		if candidate := bestWholeDescendant(child); candidate.work.Cmp(best.work) > 0 {
+			best = candidate
+		}
+	}
+
+	return best
+}
+
+func (c *Chain) checkHeader(header t.Header, parent *blockNode) error {
+	if !CheckProofOfWork(header, c.params.PowTarget) {
+		return fmt.Errorf("%w: block hash does not meet the target", ErrInvalidHeader)
+	}
+
+	if err := checkMedianTimePast(header, medianTimePastOf(parent)); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidHeader, err)
+	}
+
+	// Headers in the log passed when they were stored, and the clock may have gone back since
+	if c.replaying {
+		return nil
+	}
+
+	// Not ErrInvalidHeader, since the header becomes valid once enough time passes
+	return checkFutureTime(header, c.Now())
+}
+
+// The same as MedianTimePast of the state at node, worked out from the headers instead
+func medianTimePastOf(node *blockNode) uint64 {
+	timestamps := make([]uint64, 0, MedianTimeSpan)
+
+	for ; node.height > 0 && len(timestamps) < MedianTimeSpan; node = node.parent {
+		timestamps = append(timestamps, uint64(node.header.Timestamp))
+	}
+
+	return medianTimestamp(timestamps)
+}
+
+// Moves the main chain over to end at node. If a block on the way turns out to be invalid, it and every
+// block after it are marked invalid and the old main chain is put back.
+func (c *Chain) reorganize(node *blockNode) error {
+	path := make([]*blockNode, 0)
+	fork := node
+
+	for ; !c.onMain(fork); fork = fork.parent {
+		path = append([]*blockNode{fork}, path...)
+	}
+
+	disconnected := make([]*blockNode, 0)
+
+	for tip := c.main[len(c.main)-1]; tip != fork; tip = tip.parent {
+		c.disconnect(tip)
+		disconnected = append(disconnected, tip)
+	}
+
+	for i, next := range path {
+		if err := c.connect(next); err != nil {
+			c.markInvalid(next)
+
+			for j := i - 1; j >= 0; j-- {
+				c.disconnect(path[j])
+			}
+
+			for j := len(disconnected) - 1; j >= 0; j-- {
+				c.connect(disconnected[j])
+			}
+
+			return fmt.Errorf("%w: %w", ErrInvalidBlock, err)
+		}
+	}
+
+	return nil
+}
+
+// Marks the block and everything built on it invalid. The best header moves back if it was one of them.
+func (c *Chain) markInvalid(node *blockNode) {
+	markInvalid(node)
+
+	if c.bestHeader.invalid {
+		c.bestHeader = c.main[0]
+
+		for _, candidate := range c.nodes {
+			if !candidate.invalid && candidate.work.Cmp(c.bestHeader.work) > 0 {
+				c.bestHeader = candidate
+			}
+		}
+	}
+}
+
+func markInvalid(node *blockNode) {
+	node.invalid = true
+
+	for _, child := range node.children {
+		markInvalid(child)
+	}
+}
+
+func (c *Chain) connect(node *blockNode) error {
+	undo, err := ConnectBlock(node.block, &c.state)
+
+	if err != nil {
+		return err
+	}
+
+	node.undo = undo
+	c.main = append(c.main, node)
+
+	for _, listener := range c.listeners {
+		listener.BlockConnected(node.block, undo, &c.state)
+	}
+
+	return nil
+}
+
+func (c *Chain) disconnect(node *blockNode) {
+	undo := node.undo
+	DisconnectBlock(node.block, undo, &c.state)
+	node.undo = nil
+	c.main = c.main[:len(c.main)-1]
+
+	for _, listener := range c.listeners {
+		listener.BlockDisconnected(node.block, undo, &c.state)
+	}
+}
@@ -0,0 +1,122 @@
+package blockchain
+
+import (
+	"errors"
+	t "gold/types"
+)
+
+// Undoes the payments of a coinbase. There's no sender to refund.
+type CoinbaseUndo struct {
+	Payments []Payment
+	Created  []bool
+}
+
+// A coinbase is a Txn from the minimal key with the minimal signature. It's always the first op of a block.
+func IsCoinbase(op t.Op) bool {
+	txn, isTxn := op.(*Txn)
+
+	if !isTxn || txn.Sender.UsesName || txn.Sender.Key == nil {
+		return false
+	}
+
+	return *txn.Sender.Key == *MinimalPk()
+}
+
+// Builds the coinbase for a block whose first op is a coinbase of the same size, such as TemplateCoinbase.
+// It pays the block reward for the block's size plus the fees of every other op in the block.
+func Coinbase(addr *t.Address, block *t.Block, state *t.State) t.Op {
+	coinbase := TemplateCoinbase(addr).(*Txn)
+	reward, _ := BlockReward(BlockSize(block), MedianBlockSize(state))
+	fees, _ := BlockFees(block)
+
+	coinbase.Payments[0].Amount = reward + fees
+	return coinbase
+}
+
+// A coinbase's Nonce doesn't belong to any account, so miners are free to use it as an extra nonce.
+// Changing it changes the merkle root, which gives a whole new range of header nonces to search.
+func SetExtraNonce(block *t.Block, extraNonce uint32) {
+	block.Operations[0].(*Txn).Nonce = extraNonce
+	block.Header.MerkleRoot = CalculateMerkleRoot(block.Operations)
+}
+
+// Sum of the fees of every op except the coinbase
+func BlockFees(block *t.Block) (uint64, error) {
+	var fees uint64 = 0
+
+	for i, op := range block.Operations {
+		if i == 0 {
+			continue
+		}
+
+		if fees+op.GetFee() < fees {
+			return 0, errors.New("block fees overflow")
+		}
+
+		fees += op.GetFee()
+	}
+
+	return fees, nil
+}
+
+func validateCoinbase(coinbase t.Op, amount uint64, state *t.State) error {
+	if !IsCoinbase(coinbase) {
+		return errors.New("first op is not a coinbase")
+	}
+
+	txn := coinbase.(*Txn)
+
+	if len(txn.Payments) != 1 || txn.Fee != 0 {
+		return errors.New("coinbase must have one payment and no fee")
+	}
+
+	if txn.Signature == nil || *txn.Signature != *MinimalSignature() || len(txn.Cosignatures) != 0 {
+		return errors.New("coinbase must use the minimal signature")
+	}
+
+	if AddressToPk(&txn.Payments[0].Reciever, &state.KeyNameSet) == nil {
+		return errors.New("coinbase reciever does not exist")
+	}
+
+	if txn.Payments[0].Amount != amount {
+		return errors.New("coinbase does not pay the block reward plus fees")
+	}
+
+	return nil
+}
+
+func performCoinbase(txn *Txn, state *t.State) t.UndoOp {
+	accountSet := state.AccountSet
+	created := make([]bool, len(txn.Payments))
+
+	for i, payment := range txn.Payments {
+		recieverKey := AddressToPk(&payment.Reciever, &state.KeyNameSet)
+
+		if account, exists := accountSet[*recieverKey]; exists {
+			account.Balance += payment.Amount
+		} else {
+			accountSet[*recieverKey] = &t.Account{Balance: payment.Amount, Nonce: 0}
+			created[i] = true
+		}
+	}
+
+	return &CoinbaseUndo{
+		Payments: txn.Payments,
+		Created:  created,
+	}
+}
+
+func (c *CoinbaseUndo) PerformUndo(state *t.State) {
+	accountSet := state.AccountSet
+
+	for i := len(c.Payments) - 1; i >= 0; i-- {
+		payment := c.Payments[i]
+		recieverKey := *AddressToPk(&payment.Reciever, &state.KeyNameSet)
+
+		if c.Created[i] {
+			delete(accountSet, recieverKey)
+		} else {
+			accountSet[recieverKey].Balance -= payment.Amount
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	t "gold/types"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/schnorr"
+)
+
+const HeaderSize = 32 + 32 + 4 + 8
+
+var errTruncated = errors.New("data ends before the encoding does")
+
+// Reads back what the Encode functions write. Every read fails once the data runs out.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+// Reads the fields the Encode functions write, for encodings built around ops outside this package.
+// Once a read fails every later one does too, and Err says why.
+type Reader struct {
+	d decoder
+}
+
+func NewReader(data []byte) *Reader {
+	return &Reader{d: decoder{data: data}}
+}
+
+func (r *Reader) Next(n int) []byte {
+	return r.d.next(n)
+}
+
+func (r *Reader) Byte() byte {
+	return r.d.byte()
+}
+
+func (r *Reader) Uint32() uint32 {
+	return r.d.uint32()
+}
+
+func (r *Reader) Uint64() uint64 {
+	return r.d.uint64()
+}
+
+// A string prefixed with its length in a byte, like names
+func (r *Reader) String() string {
+	return r.d.string()
+}
+
+func (r *Reader) PubKey() *secp256k1.PublicKey {
+	return r.d.pubKey()
+}
+
+func (r *Reader) Err() error {
+	return r.d.err
+}
+
+// How many bytes are left unread
+func (r *Reader) Len() int {
+	return len(r.d.data)
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+
+	if len(d.data) < n {
+		d.err = errTruncated
+		return nil
+	}
+
+	chunk := d.data[:n]
+	d.data = d.data[n:]
+	return chunk
+}
+
+func (d *decoder) byte() byte {
+	if chunk := d.next(1); chunk != nil {
+		return chunk[0]
+	}
+
+	return 0
+}
+
+func (d *decoder) uint32() uint32 {
+	if chunk := d.next(4); chunk != nil {
+		return binary.LittleEndian.Uint32(chunk)
+	}
+
+	return 0
+}
+
+func (d *decoder) uint64() uint64 {
+	if chunk := d.next(8); chunk != nil {
+		return binary.LittleEndian.Uint64(chunk)
+	}
+
+	return 0
+}
+
+func (d *decoder) hash() [32]byte {
+	var hash [32]byte
+	copy(hash[:], d.next(32))
+	return hash
+}
+
+func (d *decoder) string() string {
+	return string(d.next(int(d.byte())))
+}
+
+func (d *decoder) pubKey() *secp256k1.PublicKey {
+	data := d.next(33)
+
+	if d.err != nil {
+		return nil
+	}
+
+	// The minimal key isn't on the curve, but coinbases are sent from it
+	if bytes.Equal(data, MinimalPk().SerializeCompressed()) {
+		return MinimalPk()
+	}
+
+	key, err := secp256k1.ParsePubKey(data)
+
+	if err != nil {
+		d.err = err
+		return nil
+	}
+
+	return key
+}
+
+func (d *decoder) signature() *schnorr.Signature {
+	data := d.next(schnorr.SignatureSize)
+
+	if d.err != nil {
+		return nil
+	}
+
+	sig, err := schnorr.ParseSignature(data)
+
+	if err != nil {
+		d.err = err
+		return nil
+	}
+
+	return sig
+}
+
+func (d *decoder) address() t.Address {
+	switch d.byte() {
+	case 0:
+		return AddrFromKey(d.pubKey())
+	case 1:
+		return AddrFromName(d.string())
+	default:
+		if d.err == nil {
+			d.err = errors.New("unknown address flag")
+		}
+
+		return t.Address{}
+	}
+}
+
+func (d *decoder) header() t.Header {
+	return t.Header{
+		PrevBlockHash: d.hash(),
+		MerkleRoot:    d.hash(),
+		Timestamp:     d.uint32(),
+		Nonce:         d.uint64(),
+	}
+}
+
+func (d *decoder) op() t.Op {
+	flag := d.byte()
+	multisig := flag&multisigFlag != 0
+
+	switch flag &^ multisigFlag {
+	case 0:
+		txn := &Txn{Sender: d.address()}
+		count := int(d.byte())
+
+		for i := 0; i < count && d.err == nil; i++ {
+			txn.Payments = append(txn.Payments, Payment{Reciever: d.address(), Amount: d.uint64()})
+		}
+
+		txn.Fee = d.uint64()
+		txn.Nonce = d.uint32()
+		txn.Signature, txn.Cosignatures = d.signatures(multisig)
+
+		return txn
+	case 1:
+		rename := &Rename{
+			Name:   d.string(),
+			NewKey: d.pubKey(),
+			Fee:    d.uint64(),
+			Nonce:  d.uint32(),
+		}
+
+		rename.Signature, rename.Cosignatures = d.signatures(multisig)
+		return rename
+	case 2:
+		register := &RegisterPolicy{Policy: d.policy(), Funder: d.pubKey(), Fee: d.uint64(), Nonce: d.uint32()}
+		register.Signature, register.Cosignatures = d.signatures(multisig)
+		return register
+	default:
+		if d.err == nil {
+			d.err = fmt.Errorf("unknown op flag %d", flag)
+		}
+
+		return nil
+	}
+}
+
+func (d *decoder) policy() t.Policy {
+	policy := t.Policy{Threshold: d.byte()}
+	count := int(d.byte())
+
+	for i := 0; i < count && d.err == nil; i++ {
+		policy.Keys = append(policy.Keys, d.pubKey())
+	}
+
+	return policy
+}
+
+// A single signature, or for a multisig op its cosignatures with the minimal signature in Signature's place
+func (d *decoder) signatures(multisig bool) (*schnorr.Signature, []Cosignature) {
+	if !multisig {
+		return d.signature(), nil
+	}
+
+	count := int(d.byte())
+
+	// Without any it would have been encoded as a single signature
+	if count == 0 && d.err == nil {
+		d.err = errors.New("multisig op has no cosignatures")
+	}
+
+	cosigs := make([]Cosignature, 0, count)
+
+	for i := 0; i < count && d.err == nil; i++ {
+		cosigs = append(cosigs, Cosignature{Index: d.byte(), Signature: d.signature()})
+	}
+
+	return MinimalSignature(), cosigs
+}
+
+// Errors unless the whole of the data was used
+func (d *decoder) finish() error {
+	if d.err == nil && len(d.data) != 0 {
+		d.err = errors.New("data continues past the end of the encoding")
+	}
+
+	return d.err
+}
+
+// Decodes the output of an op's Encode
+func DecodeOp(data []byte) (t.Op, error) {
+	d := decoder{data: data}
+	op := d.op()
+
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+
+	return op, nil
+}
+
+func DecodeHeader(data []byte) (t.Header, error) {
+	d := decoder{data: data}
+	header := d.header()
+
+	return header, d.finish()
+}
+
+// The header, the number of ops, then every op's encoding back to back
+func EncodeBlock(block *t.Block) []byte {
+	data := EncodeHeader(block.Header)
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(block.Operations)))
+
+	for _, op := range block.Operations {
+		data = append(data, op.Encode()...)
+	}
+
+	return data
+}
+
+func DecodeBlock(data []byte) (*t.Block, error) {
+	d := decoder{data: data}
+	block := &t.Block{Header: d.header()}
+	count := d.uint32()
+
+	// Every op is well over a byte, so this stops a bogus count from allocating a huge slice
+	if int(count) > len(d.data) {
+		return nil, errors.New("block claims more ops than it has room for")
+	}
+
+	block.Operations = make([]t.Op, 0, count)
+
+	for i := uint32(0); i < count && d.err == nil; i++ {
+		block.Operations = append(block.Operations, d.op())
+	}
+
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
@@ -69,7 +69,7 @@ func MinimalPk() *secp256k1.PublicKey {
 	return secp256k1.NewPublicKey(&secp256k1.FieldVal{}, &secp256k1.FieldVal{})
 }
 
-func NewRename(name string, ownerPrivKey *secp256k1.PrivateKey, newKey *secp256k1.PublicKey) t.Op {
+func NewRename(name string, ownerPrivKey *secp256k1.PrivateKey, newKey *secp256k1.PublicKey, chainID uint32) t.Op {
 	op := &Rename{
 		Name:   name,
 		NewKey: newKey,
@@ -77,12 +77,12 @@ func NewRename(name string, ownerPrivKey *secp256k1.PrivateKey, newKey *secp256k
 		Nonce:  0,
 	}
 
-	op.Signature = op.Sign(ownerPrivKey)
+	op.Signature = op.Sign(ownerPrivKey, chainID)
 
 	return op
 }
 
-func NewTxn(senderAddr t.Address, senderPrivKey *secp256k1.PrivateKey, recieverAddr *t.Address, amount uint64, fee uint64) *Txn {
+func NewTxn(senderAddr t.Address, senderPrivKey *secp256k1.PrivateKey, recieverAddr *t.Address, amount uint64, fee uint64, chainID uint32) *Txn {
 	op := Txn{
 		Sender:   senderAddr,
 		Payments: []Payment{{Reciever: *recieverAddr, Amount: amount}},
@@ -90,12 +90,7 @@ func NewTxn(senderAddr t.Address, senderPrivKey *secp256k1.PrivateKey, recieverA
 		Nonce:    0,
 	}
 
-	op.Signature = op.Sign(senderPrivKey)
+	op.Signature = op.Sign(senderPrivKey, chainID)
 
 	return &op
 }
-
-// func NewTxn(account *t.Account, accountPrivateKey *secp256k1.PrivateKey, reciever *t.Account, amount uint64) t.Op {
-// }
-
-// func Coinbase(account *t.Account, block *t.Block) t.Op {}
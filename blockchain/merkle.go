@@ -0,0 +1,38 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	t "gold/types"
+)
+
+// Leaves are the op hashes. An odd node at the end of a level is carried up unchanged rather than
+// paired with itself, so two different op lists can't share a root. An empty list has a zero root.
+func CalculateMerkleRoot(ops []t.Op) [32]byte {
+	if len(ops) == 0 {
+		return [32]byte{}
+	}
+
+	level := make([][32]byte, len(ops))
+
+	for i, op := range ops {
+		level[i] = OpHash(op)
+	}
+
+	for len(level) > 1 {
+		next := make([][32]byte, 0, (len(level)+1)/2)
+
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+
+			pair := append(level[i][:], level[i+1][:]...)
+			next = append(next, sha256.Sum256(pair))
+		}
+
+		level = next
+	}
+
+	return level[0]
+}
@@ -0,0 +1,200 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	t "gold/types"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+const (
+	// Groups smaller than this are validated on the calling goroutine, handing them out would cost more
+	// than it saves
+	minParallelGroup = 16
+	// Signatures are split across workers in chunks at least this big, so each chunk still batches well
+	minSigChunk = 128
+)
+
+// Something an op reads or writes: a name's owner, or everything about a key's account
+type resource struct {
+	name string
+	key  secp256k1.PublicKey
+}
+
+// How an op uses a resource. Shared uses are looking up a name or crediting an account, which any number
+// of ops can do without changing what the others see. Exclusive ones are renaming a name or reading an
+// account's balance, nonce or policy, which is everything else an op does to an account.
+type access struct {
+	resource
+	exclusive bool
+}
+
+// Validates and applies ops[1:] in order, and leaves the state as it was if any is invalid. Ops are split
+// into groups that touch disjoint accounts and names, and since none of a group's ops can change what
+// another reads, the whole group is validated against the state before it concurrently and then applied
+// in order. Signatures that aren't cached are left to the end and verified in batches, also
+// concurrently. Whichever op sequential validation would have failed on first is the one reported.
+func applyOps(ops []t.Op, state *t.State) ([]t.UndoOp, error) {
+	batch := &SigBatch{}
+	undos := make([]t.UndoOp, 0, len(ops)-1)
+
+	fail := func(position int, err error) ([]t.UndoOp, error) {
+		undoOps(undos, state)
+
+		// A bad signature before this op is the first thing wrong with the block
+		if sigPosition, sigErr := batch.firstFailure(); sigErr != nil {
+			position, err = sigPosition, sigErr
+		}
+
+		return nil, fmt.Errorf("op %d is invalid: %w", position, err)
+	}
+
+	for start := 1; start < len(ops); {
+		group := ops[start:groupEnd(ops, start, state)]
+		sigs := make([]SigBatch, len(group))
+		errs := make([]error, len(group))
+
+		validate := func(i int) {
+			errs[i] = validateOp(group[i], state, &batchedOp{batch: &sigs[i], op: start + i})
+		}
+
+		if len(group) >= minParallelGroup {
+			parallel(len(group), validate)
+		} else {
+			for i := range group {
+				validate(i)
+			}
+		}
+
+		for i, op := range group {
+			if errs[i] != nil {
+				return fail(start+i, errs[i])
+			}
+
+			batch.entries = append(batch.entries, sigs[i].entries...)
+			undos = append(undos, op.PerformOp(state))
+		}
+
+		start += len(group)
+	}
+
+	if position, err := batch.firstFailure(); err != nil {
+		undoOps(undos, state)
+		return nil, fmt.Errorf("op %d is invalid: %w", position, err)
+	}
+
+	SigCache.addBatch(batch)
+	return undos, nil
+}
+
+func validateOp(op t.Op, state *t.State, batched *batchedOp) error {
+	if IsCoinbase(op) {
+		return errors.New("block has more than one coinbase")
+	}
+
+	if validator, ok := op.(batchValidator); ok {
+		return validator.validate(state, batched)
+	}
+
+	return op.Validate(state)
+}
+
+// End of the group starting at ops[start]: up to the first op that uses something an earlier op in the
+// group does, unless both uses are shared. Ops of unknown types get a group of their own.
+func groupEnd(ops []t.Op, start int, state *t.State) int {
+	// Whether each resource used so far is used exclusively
+	used := make(map[resource]bool)
+
+	for end := start; end < len(ops); end++ {
+		footprint := opFootprint(ops[end], state)
+
+		if footprint == nil {
+			return max(end, start+1)
+		}
+
+		for _, a := range footprint {
+			if exclusive, exists := used[a.resource]; exists && (exclusive || a.exclusive) {
+				return end
+			}
+		}
+
+		for _, a := range footprint {
+			used[a.resource] = used[a.resource] || a.exclusive
+		}
+	}
+
+	return len(ops)
+}
+
+// Everything op's validation reads or its application writes, with names resolved against state. Nil
+// for op types it doesn't know.
+func opFootprint(op t.Op, state *t.State) []access {
+	var footprint []access
+
+	addKey := func(key *secp256k1.PublicKey, exclusive bool) {
+		if key != nil {
+			footprint = append(footprint, access{resource{key: *key}, exclusive})
+		}
+	}
+
+	addAddress := func(addr *t.Address, exclusive bool) {
+		if addr.UsesName {
+			footprint = append(footprint, access{resource{name: *addr.Name}, false})
+		}
+
+		addKey(AddressToPk(addr, &state.KeyNameSet), exclusive)
+	}
+
+	switch op := op.(type) {
+	case *Txn:
+		addAddress(&op.Sender, true)
+
+		for i := range op.Payments {
+			addAddress(&op.Payments[i].Reciever, false)
+		}
+	case *Rename:
+		footprint = append(footprint, access{resource{name: op.Name}, true})
+		addKey(op.LiableKey(state), true)
+	case *RegisterPolicy:
+		addKey(op.Funder, true)
+		addKey(PolicyKey(&op.Policy), true)
+	default:
+		return nil
+	}
+
+	return footprint
+}
+
+// Runs fn for every index below n across a goroutine per CPU
+func parallel(n int, fn func(i int)) {
+	workers := min(runtime.GOMAXPROCS(0), n)
+
+	if workers <= 1 {
+		for i := range n {
+			fn(i)
+		}
+
+		return
+	}
+
+	var next atomic.Int64
+	var wg sync.WaitGroup
+
+	for range workers {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := int(next.Add(1) - 1); i < n; i = int(next.Add(1) - 1) {
+				fn(i)
+			}
+		}()
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,78 @@
+package blockchain
+
+import (
+	"bytes"
+	t "gold/types"
+	"math/big"
+)
+
+// Everything that differs between networks
+type Params struct {
+	Name string
+	// Peers on a different chain ID are refused during the handshake
+	ChainID uint32
+	// The header every chain on this network builds on. It has no ops.
+	Genesis t.Header
+	// A header's hash, read as a big-endian number, has to be at or below this
+	PowTarget [32]byte
+	// What key addresses start with, so an address for one network can't be paid on another
+	AddressPrefix string
+}
+
+var MainNetParams = Params{
+	Name:          "mainnet",
+	ChainID:       1,
+	Genesis:       GenesisHeader(),
+	AddressPrefix: t.DefaultAddressPrefix,
+	PowTarget:     [32]byte{0x00, 0x00, 0x0f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+}
+
+// Used for local chains and tests. About half of all hashes meet the target.
+var RegTestParams = Params{
+	Name:          "regtest",
+	ChainID:       0x7e57,
+	Genesis:       GenesisHeader(),
+	AddressPrefix: "goldrt",
+	PowTarget:     [32]byte{0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+}
+
+// The networks this build knows about
+var Networks = []*Params{&MainNetParams, &RegTestParams}
+
+// The known network with the chain ID, or nil
+func NetworkByChainID(chainID uint32) *Params {
+	for _, params := range Networks {
+		if params.ChainID == chainID {
+			return params
+		}
+	}
+
+	return nil
+}
+
+// The address with this network's prefix on key addresses
+func (p *Params) FormatAddress(addr t.Address) string {
+	return addr.Format(p.AddressPrefix)
+}
+
+// Reads a name address or a key address for this network
+func (p *Params) ParseAddress(encoded string) (t.Address, error) {
+	return t.ParseAddress(encoded, p.AddressPrefix)
+}
+
+func CheckProofOfWork(header t.Header, target [32]byte) bool {
+	hash := HashBlockHeader(header)
+	return bytes.Compare(hash[:], target[:]) <= 0
+}
+
+func (p *Params) GenesisHash() [32]byte {
+	return HashBlockHeader(p.Genesis)
+}
+
+// The expected number of hashes needed to meet the target, 2^256 / (target + 1)
+func BlockWork(target [32]byte) *big.Int {
+	denominator := new(big.Int).SetBytes(target[:])
+	denominator.Add(denominator, big.NewInt(1))
+
+	return new(big.Int).Div(new(big.Int).Lsh(big.NewInt(1), 256), denominator)
+}
@@ -0,0 +1,279 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	t "gold/types"
+	"slices"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/schnorr"
+)
+
+const (
+	// Most keys a policy can have, which keeps the worst case op small and quick to verify
+	MaxPolicyKeys = 16
+	// Set on an op's flag when it carries cosignatures for a policy instead of a single signature
+	multisigFlag = 0x80
+)
+
+var (
+	ErrRegisterPolicySig  = errors.New("policy registration sig is incorrect")
+	ErrInvalidPolicy      = errors.New("policy is invalid")
+	ErrPolicyExists       = errors.New("policy is already registered")
+	ErrInvalidCosignature = errors.New("cosignature is invalid")
+	ErrThresholdNotMet    = errors.New("not enough cosignatures for the policy")
+)
+
+// A signature from the key at Index in the liable policy's keys
+type Cosignature struct {
+	Index     uint8
+	Signature *schnorr.Signature
+}
+
+// Registers an m-of-n policy, creating the account at its key if nothing has paid to it yet. Funder pays
+// the fee and signs.
+type RegisterPolicy struct {
+	Policy       t.Policy
+	Funder       *secp256k1.PublicKey
+	Fee          uint64
+	Nonce        uint32
+	Signature    *schnorr.Signature
+	Cosignatures []Cosignature
+}
+
+type RegisterPolicyUndo struct {
+	Key     *secp256k1.PublicKey
+	Funder  *secp256k1.PublicKey
+	Fee     uint64
+	Created bool
+}
+
+// A policy with its keys in canonical order, so the same keys and threshold always give the same policy key
+func NewPolicy(threshold int, keys []*secp256k1.PublicKey) (*t.Policy, error) {
+	sorted := slices.Clone(keys)
+	slices.SortFunc(sorted, compareKeys)
+	policy := &t.Policy{Threshold: uint8(threshold), Keys: sorted}
+
+	if threshold < 0 || threshold > 255 {
+		return nil, fmt.Errorf("%w: threshold must fit in a byte", ErrInvalidPolicy)
+	}
+
+	return policy, checkPolicy(policy)
+}
+
+func compareKeys(a, b *secp256k1.PublicKey) int {
+	return bytes.Compare(a.SerializeCompressed(), b.SerializeCompressed())
+}
+
+func checkPolicy(policy *t.Policy) error {
+	if len(policy.Keys) == 0 || len(policy.Keys) > MaxPolicyKeys {
+		return fmt.Errorf("%w: must have 1 to %d keys", ErrInvalidPolicy, MaxPolicyKeys)
+	}
+
+	if policy.Threshold == 0 || int(policy.Threshold) > len(policy.Keys) {
+		return fmt.Errorf("%w: threshold must be 1 to the number of keys", ErrInvalidPolicy)
+	}
+
+	for i := 1; i < len(policy.Keys); i++ {
+		if compareKeys(policy.Keys[i-1], policy.Keys[i]) >= 0 {
+			return fmt.Errorf("%w: keys must be sorted and distinct", ErrInvalidPolicy)
+		}
+	}
+
+	return nil
+}
+
+// Where the policy's account lives. The key is hashed from the policy until the hash is a valid x
+// coordinate, so nobody knows its private key and single signatures from it can't exist.
+func PolicyKey(policy *t.Policy) *secp256k1.PublicKey {
+	encoded := sha256.Sum256(encodePolicy(policy, []byte("gold/policy")))
+
+	for counter := uint32(0); ; counter++ {
+		candidate := sha256.Sum256(binary.LittleEndian.AppendUint32(encoded[:], counter))
+
+		if key, err := secp256k1.ParsePubKey(append([]byte{2}, candidate[:]...)); err == nil {
+			return key
+		}
+	}
+}
+
+// Index of key in the policy's keys, or -1
+func PolicyIndex(policy *t.Policy, key *secp256k1.PublicKey) int {
+	return slices.IndexFunc(policy.Keys, key.IsEqual)
+}
+
+func encodePolicy(policy *t.Policy, data []byte) []byte {
+	data = append(data, policy.Threshold, byte(len(policy.Keys)))
+
+	for _, key := range policy.Keys {
+		data = append(data, key.SerializeCompressed()...)
+	}
+
+	return data
+}
+
+// Appends the signature, or the cosignatures and sets the multisig flag on the op's flag byte at data[0]
+func encodeSignatures(data []byte, sig *schnorr.Signature, cosigs []Cosignature) []byte {
+	if len(cosigs) == 0 {
+		return append(data, sig.Serialize()...)
+	}
+
+	data[0] |= multisigFlag
+	data = append(data, byte(len(cosigs)))
+
+	for _, cosig := range cosigs {
+		data = append(data, cosig.Index)
+		data = append(data, cosig.Signature.Serialize()...)
+	}
+
+	return data
+}
+
+// Checks an op's signatures over hash against the key liable for it. A policy's key needs Threshold
+// distinct valid cosignatures, any other key needs sig. errSig is what a bad single signature returns.
+func authorize(state *t.State, key *secp256k1.PublicKey, hash [32]byte, sig *schnorr.Signature, cosigs []Cosignature, errSig error, batched *batchedOp) error {
+	policy, isPolicy := state.PolicySet[*key]
+
+	if !isPolicy {
+		if len(cosigs) != 0 {
+			return errSig
+		}
+
+		return batched.verify(hash, sig, key, errSig)
+	}
+
+	signed := make([]bool, len(policy.Keys))
+
+	for _, cosig := range cosigs {
+		index := int(cosig.Index)
+
+		if index >= len(policy.Keys) || signed[index] {
+			return fmt.Errorf("%w: key %d is out of range or signed twice", ErrInvalidCosignature, index)
+		}
+
+		if err := batched.verify(hash, cosig.Signature, policy.Keys[index], fmt.Errorf("%w: key %d", ErrInvalidCosignature, index)); err != nil {
+			return err
+		}
+
+		signed[index] = true
+	}
+
+	if len(cosigs) < int(policy.Threshold) {
+		return fmt.Errorf("%w: has %d of %d", ErrThresholdNotMet, len(cosigs), policy.Threshold)
+	}
+
+	return nil
+}
+
+func (r *RegisterPolicy) Encode() []byte {
+	data := []byte{2}
+
+	data = encodePolicy(&r.Policy, data)
+	data = append(data, r.Funder.SerializeCompressed()...)
+	data = binary.LittleEndian.AppendUint64(data, r.Fee)
+	data = binary.LittleEndian.AppendUint32(data, r.Nonce)
+
+	return encodeSignatures(data, r.Signature, r.Cosignatures)
+}
+
+func (r *RegisterPolicy) PerformOp(state *t.State) t.UndoOp {
+	key := PolicyKey(&r.Policy)
+	policy := r.Policy
+
+	if state.PolicySet == nil {
+		state.PolicySet = make(t.PolicySet)
+	}
+
+	state.PolicySet[*key] = &policy
+	state.AccountSet[*r.Funder].Balance -= r.Fee
+	state.AccountSet[*r.Funder].Nonce += 1
+
+	_, exists := state.AccountSet[*key]
+
+	if !exists {
+		state.AccountSet[*key] = &t.Account{}
+	}
+
+	return &RegisterPolicyUndo{Key: key, Funder: r.Funder, Fee: r.Fee, Created: !exists}
+}
+
+func (r *RegisterPolicy) Validate(state *t.State) error {
+	return r.validate(state, nil)
+}
+
+func (r *RegisterPolicy) validate(state *t.State, batched *batchedOp) error {
+	if err := checkPolicy(&r.Policy); err != nil {
+		return err
+	}
+
+	if _, exists := state.PolicySet[*PolicyKey(&r.Policy)]; exists {
+		return ErrPolicyExists
+	}
+
+	account, exists := state.AccountSet[*r.Funder]
+
+	if !exists {
+		return errors.New("funder is not in the account set")
+	}
+
+	if account.Balance < r.Fee {
+		return errors.New("funder cannot pay the fee")
+	}
+
+	if account.Nonce != r.Nonce {
+		return errors.New("policy registration uses the wrong nonce")
+	}
+
+	return authorize(state, r.Funder, r.SigningHash(state.ChainID), r.Signature, r.Cosignatures, ErrRegisterPolicySig, batched)
+}
+
+func (r RegisterPolicy) sigHashType() string {
+	return "registerPolicy"
+}
+
+func (r RegisterPolicy) unsignedEncoding() []byte {
+	r.Signature = MinimalSignature()
+	r.Cosignatures = nil
+	return r.Encode()
+}
+
+func (r RegisterPolicy) SigningHash(chainID uint32) [32]byte {
+	return signingHash(r, chainID)
+}
+
+func (r RegisterPolicy) Sign(privKey *secp256k1.PrivateKey, chainID uint32) *schnorr.Signature {
+	hash := r.SigningHash(chainID)
+	sig, _ := schnorr.Sign(privKey, hash[:])
+	return sig
+}
+
+func (r RegisterPolicy) CheckSig(sig *schnorr.Signature, pubKey *secp256k1.PublicKey, chainID uint32) bool {
+	return verifySig(r.SigningHash(chainID), sig, pubKey)
+}
+
+func (r *RegisterPolicy) GetFee() uint64 {
+	return r.Fee
+}
+
+func (r *RegisterPolicy) GetNonce() uint32 {
+	return r.Nonce
+}
+
+func (r *RegisterPolicy) LiableKey(state *t.State) *secp256k1.PublicKey {
+	return r.Funder
+}
+
+func (r *RegisterPolicyUndo) PerformUndo(state *t.State) {
+	delete(state.PolicySet, *r.Key)
+
+	if r.Created {
+		delete(state.AccountSet, *r.Key)
+	}
+
+	state.AccountSet[*r.Funder].Balance += r.Fee
+	state.AccountSet[*r.Funder].Nonce -= 1
+}
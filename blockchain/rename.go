@@ -1,7 +1,6 @@
 package blockchain
 
 import (
-	"crypto/sha256"
 	"encoding/binary"
 	"errors"
 	t "gold/types"
@@ -10,6 +9,8 @@ import (
 	"github.com/decred/dcrd/dcrec/secp256k1/v4/schnorr"
 )
 
+var ErrRenameSig = errors.New("sig is invalid")
+
 // Rename operation definition
 type Rename struct {
 	Name      string
@@ -17,6 +18,8 @@ type Rename struct {
 	Fee       uint64
 	Nonce     uint32
 	Signature *schnorr.Signature
+	// Set instead of Signature when the liable key is a policy's
+	Cosignatures []Cosignature
 }
 
 type RenameUndo struct {
@@ -36,9 +39,7 @@ func (r *Rename) Encode() []byte {
 	data = binary.LittleEndian.AppendUint64(data, r.Fee)
 	data = binary.LittleEndian.AppendUint32(data, r.Nonce)
 
-	data = append(data, r.Signature.Serialize()...)
-
-	return data
+	return encodeSignatures(data, r.Signature, r.Cosignatures)
 }
 
 func (r *Rename) PerformOp(state *t.State) t.UndoOp {
@@ -66,22 +67,19 @@ func (r *Rename) PerformOp(state *t.State) t.UndoOp {
 	}
 }
 
-// Todo
 func (r *Rename) Validate(state *t.State) error {
-	// Check the nonce matches whoever is signing
-	accountSet := state.AccountSet
-	keyNameSet := state.KeyNameSet
-
-	currOwner, nameExists := keyNameSet[r.Name]
-	var payingKey *secp256k1.PublicKey
+	return r.validate(state, nil)
+}
 
-	// The key used to index the accountSet can be factored out
-	if !nameExists {
-		payingKey = r.NewKey
-	} else {
-		payingKey = currOwner
+func (r *Rename) validate(state *t.State, batched *batchedOp) error {
+	if err := checkName(r.Name); err != nil {
+		return err
 	}
 
+	// Check the nonce matches whoever is signing
+	accountSet := state.AccountSet
+	payingKey := r.LiableKey(state)
+
 	account, newKeyExists := accountSet[*payingKey]
 
 	if !newKeyExists {
@@ -92,24 +90,53 @@ func (r *Rename) Validate(state *t.State) error {
 		return errors.New("The liable key-holder cannot pay the fee")
 	}
 
-	if !r.CheckSig(r.Signature, payingKey) {
-		return errors.New("sig is invalid")
+	if account.Nonce != r.Nonce {
+		return errors.New("rename uses the wrong nonce")
 	}
 
-	return nil
+	return authorize(state, payingKey, r.SigningHash(state.ChainID), r.Signature, r.Cosignatures, ErrRenameSig, batched)
+}
+
+func (r Rename) sigHashType() string {
+	return "rename"
 }
 
-func (r Rename) Sign(privKey *secp256k1.PrivateKey) *schnorr.Signature {
+func (r Rename) unsignedEncoding() []byte {
 	r.Signature = MinimalSignature()
-	hash := sha256.Sum256(r.Encode())
+	r.Cosignatures = nil
+	return r.Encode()
+}
+
+// The hash signatures are made over on the chain with chainID, see VersionedSigningHash
+func (r Rename) SigningHash(chainID uint32) [32]byte {
+	return signingHash(r, chainID)
+}
+
+func (r Rename) Sign(privKey *secp256k1.PrivateKey, chainID uint32) *schnorr.Signature {
+	hash := r.SigningHash(chainID)
 	sig, _ := schnorr.Sign(privKey, hash[:])
 	return sig
 }
 
-func (r Rename) CheckSig(sig *schnorr.Signature, pubKey *secp256k1.PublicKey) bool {
-	r.Signature = MinimalSignature()
-	hash := sha256.Sum256(r.Encode())
-	return sig.Verify(hash[:], pubKey)
+func (r Rename) CheckSig(sig *schnorr.Signature, pubKey *secp256k1.PublicKey, chainID uint32) bool {
+	return verifySig(r.SigningHash(chainID), sig, pubKey)
+}
+
+func (r *Rename) GetFee() uint64 {
+	return r.Fee
+}
+
+func (r *Rename) GetNonce() uint32 {
+	return r.Nonce
+}
+
+// The old owner pays for a rename, or the new key if the name is unclaimed
+func (r *Rename) LiableKey(state *t.State) *secp256k1.PublicKey {
+	if owner, exists := state.KeyNameSet[r.Name]; exists {
+		return owner
+	}
+
+	return r.NewKey
 }
 
 func (r *RenameUndo) PerformUndo(state *t.State) {
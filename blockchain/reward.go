@@ -0,0 +1,74 @@
+package blockchain
+
+import (
+	"errors"
+	t "gold/types"
+	"math/big"
+	"sort"
+)
+
+const (
+	BaseReward uint64 = 200_000_000_000
+	// The median block size never drops below this, so small blocks can always be mined without a penalty
+	FullRewardZone = 60_000
+)
+
+// Size of the header plus every op's encoding
+func BlockSize(block *t.Block) int {
+	size := len(EncodeHeader(block.Header))
+
+	for _, op := range block.Operations {
+		size += len(op.Encode())
+	}
+
+	return size
+}
+
+// Median size of the last len(State.BlockSizes) blocks, or of every block if there are fewer
+func MedianBlockSize(state *t.State) int {
+	count := min(state.Height, len(state.BlockSizes))
+	sizes := make([]int, count)
+	copy(sizes, state.BlockSizes[:count])
+	sort.Ints(sizes)
+
+	median := 0
+
+	if count > 0 && count%2 == 1 {
+		median = sizes[count/2]
+	} else if count > 0 {
+		median = (sizes[count/2-1] + sizes[count/2]) / 2
+	}
+
+	return max(median, FullRewardZone)
+}
+
+// Largest block that can follow state, past which the reward is gone
+func MaxBlockSize(state *t.State) int {
+	return 2 * MedianBlockSize(state)
+}
+
+// Largest encoding of a block to take in while state's tip may be behind the block's chain. That's twice
+// maxBlockSize, since the median block size can grow on the way, plus the op count EncodeBlock adds.
+func MaxBlockEncodingSize(maxBlockSize int) int {
+	return 2*maxBlockSize + 4
+}
+
+// Blocks up to the median get the full reward. Past it the reward shrinks by ((size - median) / median)^2,
+// down to nothing at twice the median, and anything bigger is invalid.
+func BlockReward(size int, median int) (uint64, error) {
+	if size <= median {
+		return BaseReward, nil
+	}
+
+	if size > 2*median {
+		return 0, errors.New("block is more than twice the median size")
+	}
+
+	// BaseReward * (1 - ((size - median) / median)^2) == BaseReward * size * (2 * median - size) / median^2
+	reward := new(big.Int).SetUint64(BaseReward)
+	reward.Mul(reward, big.NewInt(int64(size)))
+	reward.Mul(reward, big.NewInt(int64(2*median-size)))
+	reward.Div(reward, big.NewInt(int64(median)*int64(median)))
+
+	return reward.Uint64(), nil
+}
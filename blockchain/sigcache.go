@@ -0,0 +1,161 @@
+package blockchain
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/schnorr"
+)
+
+// Enough for a full mempool's worth of signatures with room to spare
+const DefaultSigCacheSize = 100_000
+
+// Signatures that have already verified, so an op checked when it entered the mempool isn't checked
+// again when its block arrives. Only valid signatures go in, and whether a signature is valid for a hash
+// and key never changes, so nothing has to be invalidated when the chain reorgs or the mempool drops an
+// op. Entries just get evicted to keep the cache bounded. Every chain and mempool in the process shares
+// it.
+var SigCache = newSigCache(DefaultSigCacheSize)
+
+type sigCacheKey struct {
+	hash [32]byte
+	key  [33]byte
+}
+
+type SignatureCache struct {
+	mu sync.Mutex
+	// The signature that verified for each signing hash and key. A different signature for the same
+	// pair is a miss, so a bad one can't borrow the valid one's entry.
+	entries map[sigCacheKey][64]byte
+	size    int
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// How the cache has done since it was last reset
+type SigCacheStats struct {
+	Hits    int
+	Misses  int
+	Entries int
+	Size    int
+}
+
+// Share of lookups that found their signature already verified
+func (stats SigCacheStats) HitRate() float64 {
+	if stats.Hits+stats.Misses == 0 {
+		return 0
+	}
+
+	return float64(stats.Hits) / float64(stats.Hits+stats.Misses)
+}
+
+func newSigCache(size int) *SignatureCache {
+	return &SignatureCache{entries: make(map[sigCacheKey][64]byte), size: size}
+}
+
+func (cache *SignatureCache) Stats() SigCacheStats {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	return SigCacheStats{
+		Hits:    int(cache.hits.Load()),
+		Misses:  int(cache.misses.Load()),
+		Entries: len(cache.entries),
+		Size:    cache.size,
+	}
+}
+
+// Empties the cache, zeroes its stats and bounds it to size entries. A size of 0 turns it off.
+func (cache *SignatureCache) Reset(size int) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.entries = make(map[sigCacheKey][64]byte)
+	cache.size = size
+	cache.hits.Store(0)
+	cache.misses.Store(0)
+}
+
+func cacheKey(hash [32]byte, key *secp256k1.PublicKey) sigCacheKey {
+	var k sigCacheKey
+	k.hash = hash
+	copy(k.key[:], key.SerializeCompressed())
+	return k
+}
+
+func sigBytes(sig *schnorr.Signature) [64]byte {
+	var serialized [64]byte
+	copy(serialized[:], sig.Serialize())
+	return serialized
+}
+
+// Whether sig is known to be valid for hash and key, counting the lookup as a hit or a miss
+func (cache *SignatureCache) contains(hash [32]byte, sig *schnorr.Signature, key *secp256k1.PublicKey) bool {
+	cache.mu.Lock()
+	cached, exists := cache.entries[cacheKey(hash, key)]
+	cache.mu.Unlock()
+
+	if exists && cached == sigBytes(sig) {
+		cache.hits.Add(1)
+		return true
+	}
+
+	cache.misses.Add(1)
+	return false
+}
+
+// Records a signature that verified. Once the cache is full a random entry makes room, which map
+// iteration order gives for free.
+func (cache *SignatureCache) add(hash [32]byte, sig *schnorr.Signature, key *secp256k1.PublicKey) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.size <= 0 {
+		return
+	}
+
+	cache.insert(hash, sig, key)
+}
+
+// Records every signature in a batch that verified
+func (cache *SignatureCache) addBatch(batch *SigBatch) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.size <= 0 {
+		return
+	}
+
+	for _, entry := range batch.entries {
+		cache.insert(entry.hash, entry.sig, entry.key)
+	}
+}
+
+func (cache *SignatureCache) insert(hash [32]byte, sig *schnorr.Signature, key *secp256k1.PublicKey) {
+	k := cacheKey(hash, key)
+
+	if _, exists := cache.entries[k]; !exists && len(cache.entries) >= cache.size {
+		for evicted := range cache.entries {
+			delete(cache.entries, evicted)
+			break
+		}
+	}
+
+	cache.entries[k] = sigBytes(sig)
+}
+
+// Verifies sig over hash against key, skipping the work if it has verified before
+func verifySig(hash [32]byte, sig *schnorr.Signature, key *secp256k1.PublicKey) bool {
+	if SigCache.contains(hash, sig, key) {
+		return true
+	}
+
+	if !sig.Verify(hash[:], key) {
+		return false
+	}
+
+	SigCache.add(hash, sig, key)
+	return true
+}
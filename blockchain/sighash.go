@@ -0,0 +1,68 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	t "gold/types"
+)
+
+// Version of the signing hash ops are signed and validated with. Each version has its own tags, so a
+// signature made under one is never valid under another.
+const SigHashVersion = 1
+
+var ErrSigHashVersion = errors.New("unknown signing hash version")
+
+// Ops whose signatures are made over a signing hash
+type signable interface {
+	// What the op is called in its tag, the same as its type in RPC
+	sigHashType() string
+	// The op encoded with the minimal signature in place of its own and no cosignatures
+	unsignedEncoding() []byte
+}
+
+// sha256(sha256(tag) || sha256(tag) || data...), as in BIP 340. Hashes with different tags can't collide
+// however their data is chosen.
+func TaggedHash(tag string, data ...[]byte) [32]byte {
+	tagHash := sha256.Sum256([]byte(tag))
+	hash := sha256.New()
+	hash.Write(tagHash[:])
+	hash.Write(tagHash[:])
+
+	for _, d := range data {
+		hash.Write(d)
+	}
+
+	return [32]byte(hash.Sum(nil))
+}
+
+// Tag of an op type's signing hash on a chain, like gold/sighash/v1/txn/32343
+func SigHashTag(version int, opType string, chainID uint32) string {
+	return fmt.Sprintf("gold/sighash/v%d/%s/%d", version, opType, chainID)
+}
+
+// The hash an op's signatures are made over under the given version. For version 1 it's
+//
+//	TaggedHash(SigHashTag(1, type, chainID), unsigned encoding)
+//
+// where the unsigned encoding is the op's encoding with the minimal signature in place of its own and
+// no cosignatures, which is the op as an UnsignedOp carries it. Signers that are handed those bytes
+// don't need to know how ops are encoded.
+func VersionedSigningHash(version int, op t.Op, chainID uint32) ([32]byte, error) {
+	if version != 1 {
+		return [32]byte{}, fmt.Errorf("%w: %d", ErrSigHashVersion, version)
+	}
+
+	s, ok := op.(signable)
+
+	if !ok {
+		return [32]byte{}, fmt.Errorf("%T ops aren't signed", op)
+	}
+
+	return TaggedHash(SigHashTag(version, s.sigHashType(), chainID), s.unsignedEncoding()), nil
+}
+
+// The signing hash under the current version
+func signingHash(op signable, chainID uint32) [32]byte {
+	return TaggedHash(SigHashTag(SigHashVersion, op.sigHashType(), chainID), op.unsignedEncoding())
+}
@@ -0,0 +1,190 @@
+package blockchain
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	t "gold/types"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	recordHeader byte = 0
+	recordBlock  byte = 1
+)
+
+// Append-only log of every header and block the chain accepts. Each record is a kind byte, a length and
+// the encoding. Opening the chain replays the log, so the state is rebuilt by connecting everything again.
+type chainStore struct {
+	file *os.File
+}
+
+// Opens the chain stored in dir, creating it if it doesn't exist. Headers and blocks that were added before
+// the last shutdown are all still there, including headers whose blocks hadn't been downloaded yet.
+func OpenChain(params *Params, dir string) (*Chain, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, "chain.dat"), os.O_RDWR|os.O_CREATE, 0o600)
+
+	if err != nil {
+		return nil, err
+	}
+
+	chain := NewChain(params)
+	valid, err := chain.replay(file)
+
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	// Anything after the last whole record was cut off mid write
+	if err := file.Truncate(valid); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if _, err := file.Seek(valid, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	chain.store = &chainStore{file: file}
+	return chain, nil
+}
+
+var ErrCorruptStore = errors.New("chain store is corrupt")
+
+// Re-adds every record in the log. Returns the offset just past the last whole record. Only a record
+// running past the end of the file was torn by a crash mid write. One that fits but is bigger than its
+// kind can be means the log is corrupt, and the records after it are not given up on silently.
+func (c *Chain) replay(file *os.File) (int64, error) {
+	info, err := file.Stat()
+
+	if err != nil {
+		return 0, err
+	}
+
+	c.replaying = true
+	defer func() { c.replaying = false }()
+
+	reader := bufio.NewReader(file)
+	var offset int64 = 0
+
+	for {
+		var prefix [5]byte
+
+		if _, err := io.ReadFull(reader, prefix[:]); err != nil {
+			return offset, nil
+		}
+
+		size := binary.LittleEndian.Uint32(prefix[1:])
+
+		if offset+int64(len(prefix))+int64(size) > info.Size() {
+			return offset, nil
+		}
+
+		if limit := c.maxRecordSize(prefix[0]); size > uint32(limit) {
+			return 0, fmt.Errorf("%w: record at %d is %d bytes, more than the %d it can be", ErrCorruptStore, offset, size, limit)
+		}
+
+		data := make([]byte, size)
+
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return 0, err
+		}
+
+		if err := c.replayRecord(prefix[0], data); err != nil {
+			return 0, err
+		}
+
+		offset += int64(len(prefix) + len(data))
+	}
+}
+
+// Blocks get the same allowance as blocks from peers, since a stored block can be on a fork or further
+// along the log than the state replayed so far
+func (c *Chain) maxRecordSize(kind byte) int {
+	if kind == recordHeader {
+		return HeaderSize
+	}
+
+	return MaxBlockEncodingSize(MaxBlockSize(&c.state))
+}
+
+func (c *Chain) replayRecord(kind byte, data []byte) error {
+	var err error
+
+	switch kind {
+	case recordHeader:
+		header, decodeErr := DecodeHeader(data)
+
+		if decodeErr != nil {
+			return decodeErr
+		}
+
+		_, err = c.addHeader(header)
+	case recordBlock:
+		block, decodeErr := DecodeBlock(data)
+
+		if decodeErr != nil {
+			return decodeErr
+		}
+
+		var node *blockNode
+
+		if node, err = c.addBlock(block); err == nil {
+			err = c.connectBest(node)
+		}
+	default:
+		return errors.New("unknown record in the chain store")
+	}
+
+	// Invalid blocks are stored too, so they're known to be invalid after a restart
+	if err != nil && !errors.Is(err, ErrInvalidBlock) && err != ErrDuplicateBlock {
+		return err
+	}
+
+	return nil
+}
+
+func (s *chainStore) appendHeader(header t.Header) error {
+	return s.append(recordHeader, EncodeHeader(header))
+}
+
+func (s *chainStore) appendBlock(block *t.Block) error {
+	return s.append(recordBlock, EncodeBlock(block))
+}
+
+func (s *chainStore) append(kind byte, data []byte) error {
+	record := []byte{kind}
+	record = binary.LittleEndian.AppendUint32(record, uint32(len(data)))
+	record = append(record, data...)
+
+	_, err := s.file.Write(record)
+	return err
+}
+
+// Flushes the store to disk and closes it. The chain keeps working in memory afterwards.
+func (c *Chain) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.store == nil {
+		return nil
+	}
+
+	err := c.store.file.Sync()
+
+	if closeErr := c.store.file.Close(); err == nil {
+		err = closeErr
+	}
+
+	c.store = nil
+	return err
+}
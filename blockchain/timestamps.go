@@ -0,0 +1,67 @@
+package blockchain
+
+import (
+	"errors"
+	t "gold/types"
+	"sort"
+	"time"
+)
+
+const (
+	// How many of the most recent blocks the median time past is taken over
+	MedianTimeSpan = 60
+	// How far a block's timestamp can be ahead of the node's clock
+	MaxFutureBlockTime = 2 * time.Hour
+)
+
+// Median timestamp of the last MedianTimeSpan blocks, or of every block if there are fewer.
+// State.Timestamps is a ring buffer with the timestamp of the block at height h in slot h % len(Timestamps).
+func MedianTimePast(state *t.State) uint64 {
+	count := min(state.Height, MedianTimeSpan)
+	timestamps := make([]uint64, count)
+
+	for i := 0; i < count; i++ {
+		height := state.Height - 1 - i
+		timestamps[i] = state.Timestamps[height%len(state.Timestamps)]
+	}
+
+	return medianTimestamp(timestamps)
+}
+
+// With an even count the lower of the two middle timestamps is used. Sorts the slice in place.
+func medianTimestamp(timestamps []uint64) uint64 {
+	if len(timestamps) == 0 {
+		return 0
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+	return timestamps[(len(timestamps)-1)/2]
+}
+
+// A header's timestamp has to be after the median time past of the state it builds on, and no more than
+// MaxFutureBlockTime ahead of now. The time is passed in so the node's clock can be swapped out in tests.
+// Only the median time past is part of ConnectBlock, since the future limit depends on when the block is seen.
+func CheckHeaderTime(header t.Header, state *t.State, now time.Time) error {
+	if err := checkMedianTimePast(header, MedianTimePast(state)); err != nil {
+		return err
+	}
+
+	return checkFutureTime(header, now)
+}
+
+func checkMedianTimePast(header t.Header, medianTimePast uint64) error {
+	if uint64(header.Timestamp) <= medianTimePast {
+		return errors.New("block timestamp is not after the median time past")
+	}
+
+	return nil
+}
+
+func checkFutureTime(header t.Header, now time.Time) error {
+	if int64(header.Timestamp) > now.Add(MaxFutureBlockTime).Unix() {
+		return errors.New("block timestamp is too far in the future")
+	}
+
+	return nil
+}
@@ -1,7 +1,6 @@
 package blockchain
 
 import (
-	"crypto/sha256"
 	"encoding/binary"
 	"errors"
 	t "gold/types"
@@ -10,6 +9,8 @@ import (
 	"github.com/decred/dcrd/dcrec/secp256k1/v4/schnorr"
 )
 
+var ErrTxnSig = errors.New("txn sig is incorrect")
+
 // Txn operation definition
 type Txn struct {
 	Sender    t.Address
@@ -17,6 +18,8 @@ type Txn struct {
 	Fee       uint64
 	Nonce     uint32
 	Signature *schnorr.Signature
+	// Set instead of Signature when the liable key is a policy's
+	Cosignatures []Cosignature
 }
 
 type Payment struct {
@@ -27,6 +30,9 @@ type Payment struct {
 type TxnUndo struct {
 	Sender   t.Address
 	Payments []Payment
+	Fee      uint64
+	// Created[i] is true if Payments[i] created the reciever's account
+	Created []bool
 }
 
 func (t *Txn) Encode() []byte {
@@ -43,9 +49,7 @@ func (t *Txn) Encode() []byte {
 	data = binary.LittleEndian.AppendUint64(data, t.Fee)
 	data = binary.LittleEndian.AppendUint32(data, t.Nonce)
 
-	data = append(data, t.Signature.Serialize()...)
-
-	return data
+	return encodeSignatures(data, t.Signature, t.Cosignatures)
 }
 
 func (txn *Txn) PerformOp(state *t.State) t.UndoOp {
@@ -53,27 +57,51 @@ func (txn *Txn) PerformOp(state *t.State) t.UndoOp {
 	keyNameSet := state.KeyNameSet
 
 	senderKey := AddressToPk(&txn.Sender, &keyNameSet)
+	created := make([]bool, len(txn.Payments))
 
-	for _, payment := range txn.Payments {
+	for i, payment := range txn.Payments {
 		recieverKey := AddressToPk(&payment.Reciever, &keyNameSet)
 
 		if account, exists := accountSet[*recieverKey]; exists {
 			account.Balance += payment.Amount
 		} else {
 			accountSet[*recieverKey] = &t.Account{Balance: payment.Amount, Nonce: 0}
+			created[i] = true
 		}
 
 		accountSet[*senderKey].Balance -= payment.Amount
-		accountSet[*senderKey].Nonce += 1
 	}
 
+	accountSet[*senderKey].Balance -= txn.Fee
+	accountSet[*senderKey].Nonce += 1
+
 	return &TxnUndo{
 		Sender:   txn.Sender,
 		Payments: txn.Payments,
+		Fee:      txn.Fee,
+		Created:  created,
 	}
 }
 
 func (txn Txn) Validate(state *t.State) error {
+	return txn.validate(state, nil)
+}
+
+func (txn Txn) validate(state *t.State, batched *batchedOp) error {
+	if len(txn.Payments) > MaxPayments {
+		return ErrTooManyPayments
+	}
+
+	for _, addr := range txn.Addresses() {
+		if !addr.UsesName {
+			continue
+		}
+
+		if err := checkName(*addr.Name); err != nil {
+			return err
+		}
+	}
+
 	accountSet := state.AccountSet
 	keyNameSet := state.KeyNameSet
 
@@ -86,13 +114,25 @@ func (txn Txn) Validate(state *t.State) error {
 	senderPk := *senderPkPtr
 
 	var totalSent uint64 = 0
-	account := accountSet[senderPk]
+	account, exists := accountSet[senderPk]
+
+	if !exists {
+		return errors.New("sender is not in the account set")
+	}
 
 	for _, payment := range txn.Payments {
+		if AddressToPk(&payment.Reciever, &keyNameSet) == nil {
+			return errors.New("payment reciever does not exist")
+		}
+
+		if totalSent+payment.Amount < totalSent {
+			return errors.New("txn payments overflow")
+		}
+
 		totalSent += payment.Amount
 	}
 
-	if totalSent+txn.Fee > account.Balance {
+	if totalSent+txn.Fee < totalSent || totalSent+txn.Fee > account.Balance {
 		return errors.New("txn sends more than senders balance")
 	}
 
@@ -100,24 +140,32 @@ func (txn Txn) Validate(state *t.State) error {
 		return errors.New("txn uses the wrong nonce")
 	}
 
-	if !txn.CheckSig(txn.Signature, &senderPk) {
-		return errors.New("txn sig is incorrect")
-	}
+	return authorize(state, &senderPk, txn.SigningHash(state.ChainID), txn.Signature, txn.Cosignatures, ErrTxnSig, batched)
+}
 
-	return nil
+func (txn Txn) sigHashType() string {
+	return "txn"
 }
 
-func (txn Txn) Sign(privKey *secp256k1.PrivateKey) *schnorr.Signature {
+func (txn Txn) unsignedEncoding() []byte {
 	txn.Signature = MinimalSignature()
-	hash := sha256.Sum256(txn.Encode())
+	txn.Cosignatures = nil
+	return txn.Encode()
+}
+
+// The hash signatures are made over on the chain with chainID, see VersionedSigningHash
+func (txn Txn) SigningHash(chainID uint32) [32]byte {
+	return signingHash(txn, chainID)
+}
+
+func (txn Txn) Sign(privKey *secp256k1.PrivateKey, chainID uint32) *schnorr.Signature {
+	hash := txn.SigningHash(chainID)
 	sig, _ := schnorr.Sign(privKey, hash[:])
 	return sig
 }
 
-func (txn Txn) CheckSig(sig *schnorr.Signature, pubKey *secp256k1.PublicKey) bool {
-	txn.Signature = MinimalSignature()
-	hash := sha256.Sum256(txn.Encode())
-	return sig.Verify(hash[:], pubKey)
+func (txn Txn) CheckSig(sig *schnorr.Signature, pubKey *secp256k1.PublicKey, chainID uint32) bool {
+	return verifySig(txn.SigningHash(chainID), sig, pubKey)
 }
 
 func (txn *TxnUndo) PerformUndo(state *t.State) {
@@ -126,21 +174,47 @@ func (txn *TxnUndo) PerformUndo(state *t.State) {
 
 	senderKey := AddressToPk(&txn.Sender, &keyNameSet)
 
-	for _, payment := range txn.Payments {
+	accountSet[*senderKey].Balance += txn.Fee
+	accountSet[*senderKey].Nonce -= 1
+
+	// Walk backwards so a reciever paid twice is only removed by the payment that created it
+	for i := len(txn.Payments) - 1; i >= 0; i-- {
+		payment := txn.Payments[i]
 		recieverKey := *AddressToPk(&payment.Reciever, &keyNameSet)
-		account := accountSet[recieverKey]
 
-		if account.Balance == payment.Amount {
+		if txn.Created[i] {
 			delete(accountSet, recieverKey)
 		} else {
 			accountSet[recieverKey].Balance -= payment.Amount
 		}
 
 		accountSet[*senderKey].Balance += payment.Amount
-		accountSet[*senderKey].Nonce -= 1
 	}
 }
 
+func (txn *Txn) GetFee() uint64 {
+	return txn.Fee
+}
+
+func (txn *Txn) GetNonce() uint32 {
+	return txn.Nonce
+}
+
+func (txn *Txn) LiableKey(state *t.State) *secp256k1.PublicKey {
+	return AddressToPk(&txn.Sender, &state.KeyNameSet)
+}
+
+// The sender followed by every payment's reciever
+func (txn *Txn) Addresses() []t.Address {
+	addrs := []t.Address{txn.Sender}
+
+	for _, payment := range txn.Payments {
+		addrs = append(addrs, payment.Reciever)
+	}
+
+	return addrs
+}
+
 func encodePayment(payment Payment, data []byte) []byte {
 	data = encodeAddress(&payment.Reciever, data)
 	data = binary.LittleEndian.AppendUint64(data, payment.Amount)
@@ -1,11 +1,29 @@
 package blockchain
 
 import (
+	"crypto/sha256"
+	"errors"
 	t "gold/types"
 
 	"github.com/decred/dcrd/dcrec/secp256k1/v4"
 )
 
+// Names and payment lists are prefixed with a single byte count, so anything longer couldn't be encoded
+const MaxPayments = 255
+
+var (
+	ErrNameLength      = errors.New("name is empty or too long")
+	ErrTooManyPayments = errors.New("txn has more than 255 payments")
+)
+
+func checkName(name string) error {
+	if len(name) == 0 || len(name) > t.MaxNameLength {
+		return ErrNameLength
+	}
+
+	return nil
+}
+
 // If the address uses a name not in the set, it will return a nil pointer
 func AddressToPk(ad *t.Address, keyNameSet *t.KeyNameSet) *secp256k1.PublicKey {
 	if ad.UsesName {
@@ -28,3 +46,40 @@ func encodeAddress(addr *t.Address, data []byte) []byte {
 
 	return data
 }
+
+// Ops are identified by the hash of their full encoding, signature included
+func OpHash(op t.Op) [32]byte {
+	return sha256.Sum256(op.Encode())
+}
+
+// Deep copies the state so ops can be applied to the copy without touching the original
+func CopyState(state *t.State) t.State {
+	accountSet := make(t.AccountSet, len(state.AccountSet))
+	keyNameSet := make(t.KeyNameSet, len(state.KeyNameSet))
+
+	for key, account := range state.AccountSet {
+		accountCopy := *account
+		accountSet[key] = &accountCopy
+	}
+
+	for name, key := range state.KeyNameSet {
+		keyNameSet[name] = key
+	}
+
+	// Policies never change once registered, so they can be shared
+	policySet := make(t.PolicySet, len(state.PolicySet))
+
+	for key, policy := range state.PolicySet {
+		policySet[key] = policy
+	}
+
+	return t.State{
+		AccountSet: accountSet,
+		KeyNameSet: keyNameSet,
+		PolicySet:  policySet,
+		BlockSizes: state.BlockSizes,
+		Timestamps: state.Timestamps,
+		Height:     state.Height,
+		ChainID:    state.ChainID,
+	}
+}
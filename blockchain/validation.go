@@ -1,9 +1,175 @@
 package blockchain
 
 import (
+	"errors"
 	t "gold/types"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
 )
 
+// Everything needed to take a connected block back off the state
+type BlockUndo struct {
+	// One per op, in block order
+	Undos []t.UndoOp
+	// The BlockSizes entry the block overwrote
+	OldBlockSize int
+	// The Timestamps entry the block overwrote
+	OldTimestamp uint64
+}
+
+// The owner of each name the block's txns use, as it was just before the txn, so listeners can tell who
+// an op really touched. state can be from before or after the block: a name the block renames is worked
+// out from the nearest rename to the txn, and every other name is the same either way.
+func NameOwnersBeforeOps(block *t.Block, undo *BlockUndo, state *t.State) []map[string]*secp256k1.PublicKey {
+	owners := make([]map[string]*secp256k1.PublicKey, len(block.Operations))
+	renames := make(map[string][]int)
+
+	for i, op := range block.Operations {
+		if rename, ok := op.(*Rename); ok {
+			renames[rename.Name] = append(renames[rename.Name], i)
+		}
+	}
+
+	ownerBefore := func(name string, i int) *secp256k1.PublicKey {
+		for j, at := range renames[name] {
+			if at < i {
+				continue
+			}
+
+			// The latest rename before the txn gave the name the owner it saw
+			if j > 0 {
+				return block.Operations[renames[name][j-1]].(*Rename).NewKey
+			}
+
+			// Or the first rename after it replaced that owner
+			if undo != nil {
+				if renameUndo, ok := undo.Undos[at].(*RenameUndo); ok {
+					return renameUndo.OldOwner
+				}
+			}
+
+			break
+		}
+
+		if count := len(renames[name]); count > 0 && renames[name][count-1] < i {
+			return block.Operations[renames[name][count-1]].(*Rename).NewKey
+		}
+
+		return state.KeyNameSet[name]
+	}
+
+	for i, op := range block.Operations {
+		txn, ok := op.(*Txn)
+
+		if !ok {
+			continue
+		}
+
+		owners[i] = make(map[string]*secp256k1.PublicKey)
+
+		for _, addr := range txn.Addresses() {
+			if addr.UsesName {
+				owners[i][*addr.Name] = ownerBefore(*addr.Name, i)
+			}
+		}
+	}
+
+	return owners
+}
+
 func ValidateBlock(block *t.Block, state *t.State) bool {
-	return false
+	return CheckBlock(block, state) == nil
+}
+
+// Checks the block would connect on top of state, leaving state as it was.
+// Linking to the previous block, proof of work and the future time limit are checked by whoever tracks the chain.
+func CheckBlock(block *t.Block, state *t.State) error {
+	undo, err := ConnectBlock(block, state)
+
+	if err != nil {
+		return err
+	}
+
+	DisconnectBlock(block, undo, state)
+	return nil
+}
+
+// Validates and applies every op in the block, then records its size and timestamp. If anything is invalid
+// the state is rolled back and the error returned.
+func ConnectBlock(block *t.Block, state *t.State) (*BlockUndo, error) {
+	ops := block.Operations
+
+	if len(ops) == 0 {
+		return nil, errors.New("block has no coinbase")
+	}
+
+	if CalculateMerkleRoot(ops) != block.Header.MerkleRoot {
+		return nil, ErrMerkleMismatch
+	}
+
+	if err := checkMedianTimePast(block.Header, MedianTimePast(state)); err != nil {
+		return nil, err
+	}
+
+	size := BlockSize(block)
+	reward, err := BlockReward(size, MedianBlockSize(state))
+
+	if err != nil {
+		return nil, err
+	}
+
+	fees, err := BlockFees(block)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if reward+fees < reward {
+		return nil, errors.New("coinbase amount overflows")
+	}
+
+	if err := validateCoinbase(ops[0], reward+fees, state); err != nil {
+		return nil, err
+	}
+
+	undos := make([]t.UndoOp, 0, len(ops))
+	undos = append(undos, performCoinbase(ops[0].(*Txn), state))
+
+	opUndos, err := applyOps(ops, state)
+
+	if err != nil {
+		undoOps(undos, state)
+		return nil, err
+	}
+
+	undos = append(undos, opUndos...)
+
+	sizeSlot := state.Height % len(state.BlockSizes)
+	timeSlot := state.Height % len(state.Timestamps)
+	undo := &BlockUndo{
+		Undos:        undos,
+		OldBlockSize: state.BlockSizes[sizeSlot],
+		OldTimestamp: state.Timestamps[timeSlot],
+	}
+
+	state.BlockSizes[sizeSlot] = size
+	state.Timestamps[timeSlot] = uint64(block.Header.Timestamp)
+	state.Height += 1
+
+	return undo, nil
+}
+
+// Takes the block off the state. The block has to be the last one connected.
+func DisconnectBlock(block *t.Block, undo *BlockUndo, state *t.State) {
+	state.Height -= 1
+	state.BlockSizes[state.Height%len(state.BlockSizes)] = undo.OldBlockSize
+	state.Timestamps[state.Height%len(state.Timestamps)] = undo.OldTimestamp
+
+	undoOps(undo.Undos, state)
+}
+
+func undoOps(undos []t.UndoOp, state *t.State) {
+	for i := len(undos) - 1; i >= 0; i-- {
+		undos[i].PerformUndo(state)
+	}
 }
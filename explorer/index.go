@@ -0,0 +1,206 @@
+package explorer
+
+import (
+	b "gold/blockchain"
+	t "gold/types"
+	"slices"
+	"sync"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+// What an address was to an op
+type Role string
+
+const (
+	RoleSender   Role = "sender"
+	RoleReciever Role = "reciever"
+	// The name a rename moved
+	RoleName     Role = "name"
+	RoleOldOwner Role = "oldOwner"
+	RoleNewOwner Role = "newOwner"
+	// The account a policy registration set up
+	RolePolicy Role = "policy"
+)
+
+// One op touching an address
+type Entry struct {
+	Height int
+	// Index of the op in its block
+	Position  int
+	BlockHash [32]byte
+	OpHash    [32]byte
+	Role      Role
+}
+
+type opLocation struct {
+	blockHash [32]byte
+	height    int
+	position  int
+}
+
+// Records for every key and name the main chain ops that touched it. Addresses are indexed the way they're
+// written, so a txn sent from a name is listed under the name, and under the key the name resolved to
+// just before the txn. Renames are listed under the name and both its old and new owner.
+// The coinbase's sender is left out, since every coinbase has the same one.
+//
+// The index lives in memory, it's rebuilt from the chain by NewIndex and kept up to date as a ChainListener.
+type Index struct {
+	mu     sync.RWMutex
+	params *b.Params
+	// Entries by address as the network writes them, oldest first
+	history map[string][]Entry
+	// Where each main chain op is, oldest first. Coinbases paying the same amount to the same address
+	// have the same hash, so one hash can be in several blocks.
+	ops map[[32]byte][]opLocation
+	// The addresses each connected block added entries for, so disconnecting it can take them back off
+	blocks map[[32]byte][]string
+}
+
+func NewIndex(chain *b.Chain) (*Index, error) {
+	index := &Index{
+		params:  chain.Params(),
+		history: make(map[string][]Entry),
+		ops:     make(map[[32]byte][]opLocation),
+		blocks:  make(map[[32]byte][]string),
+	}
+
+	if err := chain.AddListenerFromGenesis(index); err != nil {
+		return nil, err
+	}
+
+	return index, nil
+}
+
+// The address's entries newest first, skipping the newest offset of them and returning at most limit.
+// Also returns how many entries the address has in total.
+func (x *Index) History(addr t.Address, offset int, limit int) ([]Entry, int) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	history := x.history[x.params.FormatAddress(addr)]
+	entries := make([]Entry, 0)
+
+	for i := len(history) - 1 - offset; i >= 0 && len(entries) < limit; i-- {
+		entries = append(entries, history[i])
+	}
+
+	return entries, len(history)
+}
+
+// Where an op is on the main chain. An op that's in more than one block is found in the newest.
+func (x *Index) FindOp(hash [32]byte) (blockHash [32]byte, height int, position int, found bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	locations := x.ops[hash]
+
+	if len(locations) == 0 {
+		return [32]byte{}, 0, 0, false
+	}
+
+	location := locations[len(locations)-1]
+	return location.blockHash, location.height, location.position, true
+}
+
+func (x *Index) BlockConnected(block *t.Block, undo *b.BlockUndo, state *t.State) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	blockHash := b.HashBlockHeader(block.Header)
+	touched := make([]string, 0)
+	owners := b.NameOwnersBeforeOps(block, undo, state)
+
+	for i, op := range block.Operations {
+		opHash := b.OpHash(op)
+		x.ops[opHash] = append(x.ops[opHash], opLocation{blockHash: blockHash, height: state.Height, position: i})
+		added := make(map[string]bool)
+
+		add := func(addr string, role Role) {
+			key := addr + "/" + string(role)
+
+			if added[key] {
+				return
+			}
+
+			added[key] = true
+			x.history[addr] = append(x.history[addr], Entry{Height: state.Height, Position: i, BlockHash: blockHash, OpHash: opHash, Role: role})
+			touched = append(touched, addr)
+		}
+
+		addAddress := func(addr *t.Address, role Role) {
+			add(x.params.FormatAddress(*addr), role)
+
+			if addr.UsesName {
+				if key := owners[i][*addr.Name]; key != nil {
+					add(x.keyAddress(key), role)
+				}
+			}
+		}
+
+		switch op := op.(type) {
+		case *b.Txn:
+			if i != 0 {
+				addAddress(&op.Sender, RoleSender)
+			}
+
+			for j := range op.Payments {
+				addAddress(&op.Payments[j].Reciever, RoleReciever)
+			}
+		case *b.Rename:
+			add(x.params.FormatAddress(b.AddrFromName(op.Name)), RoleName)
+
+			if renameUndo, ok := undo.Undos[i].(*b.RenameUndo); ok && renameUndo.OldOwner != nil {
+				add(x.keyAddress(renameUndo.OldOwner), RoleOldOwner)
+			}
+
+			add(x.keyAddress(op.NewKey), RoleNewOwner)
+		case *b.RegisterPolicy:
+			add(x.keyAddress(op.Funder), RoleSender)
+			add(x.keyAddress(b.PolicyKey(&op.Policy)), RolePolicy)
+		}
+	}
+
+	x.blocks[blockHash] = touched
+}
+
+func (x *Index) keyAddress(key *secp256k1.PublicKey) string {
+	return x.params.FormatAddress(b.AddrFromKey(key))
+}
+
+// Takes the block's entries back off, which are always the newest for each address they were added to
+func (x *Index) BlockDisconnected(block *t.Block, undo *b.BlockUndo, state *t.State) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	blockHash := b.HashBlockHeader(block.Header)
+
+	for _, addr := range slices.Backward(x.blocks[blockHash]) {
+		history := x.history[addr]
+		history = history[:len(history)-1]
+
+		if len(history) == 0 {
+			delete(x.history, addr)
+		} else {
+			x.history[addr] = history
+		}
+	}
+
+	for i, op := range slices.Backward(block.Operations) {
+		opHash := b.OpHash(op)
+		locations := x.ops[opHash]
+		last := len(locations) - 1
+
+		if last < 0 || locations[last].blockHash != blockHash || locations[last].position != i {
+			continue
+		}
+
+		if last == 0 {
+			delete(x.ops, opHash)
+		} else {
+			x.ops[opHash] = locations[:last]
+		}
+	}
+
+	delete(x.blocks, blockHash)
+}
@@ -0,0 +1,257 @@
+package explorer
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	b "gold/blockchain"
+	"gold/rpc"
+	t "gold/types"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+type Config struct {
+	ListenAddr string
+}
+
+func DefaultConfig() Config {
+	return Config{ListenAddr: "127.0.0.1:8334"}
+}
+
+// Serves the chain and the address index as a read only REST API:
+//
+//	GET /api/tip
+//	GET /api/block/{block}             block is a hash or a main chain height
+//	GET /api/op/{hash}
+//	GET /api/address/{address}         ?offset=&limit= pages through the history, newest first
+//
+// Everything it serves is public, so unlike the RPC server there's no auth.
+type Server struct {
+	config Config
+	chain  *b.Chain
+	index  *Index
+	mux    *http.ServeMux
+
+	listener net.Listener
+	http     *http.Server
+}
+
+type Tip struct {
+	Hash   string `json:"hash"`
+	Height int    `json:"height"`
+}
+
+type IndexedOp struct {
+	rpc.Op
+	BlockHash string `json:"blockHash"`
+	Height    int    `json:"height"`
+	Position  int    `json:"position"`
+}
+
+type HistoryEntry struct {
+	IndexedOp
+	Role Role `json:"role"`
+}
+
+type AddressHistory struct {
+	Address string         `json:"address"`
+	Total   int            `json:"total"`
+	Offset  int            `json:"offset"`
+	Entries []HistoryEntry `json:"entries"`
+}
+
+func NewServer(config Config, chain *b.Chain, index *Index) *Server {
+	s := &Server{config: config, chain: chain, index: index, mux: http.NewServeMux()}
+
+	s.mux.HandleFunc("GET /api/tip", s.getTip)
+	s.mux.HandleFunc("GET /api/block/{block}", s.getBlock)
+	s.mux.HandleFunc("GET /api/op/{hash}", s.getOp)
+	s.mux.HandleFunc("GET /api/address/{address}", s.getAddress)
+
+	return s
+}
+
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.config.ListenAddr)
+
+	if err != nil {
+		return err
+	}
+
+	s.listener = listener
+	s.http = &http.Server{Handler: s, ReadHeaderTimeout: 10 * time.Second}
+	go s.http.Serve(listener)
+
+	return nil
+}
+
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+func (s *Server) Stop() error {
+	return s.http.Close()
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) getTip(w http.ResponseWriter, r *http.Request) {
+	hash, height := s.chain.Tip()
+	writeJSON(w, &Tip{Hash: hex.EncodeToString(hash[:]), Height: height})
+}
+
+func (s *Server) getBlock(w http.ResponseWriter, r *http.Request) {
+	param := r.PathValue("block")
+	var hash [32]byte
+	var exists bool
+
+	if height, err := strconv.Atoi(param); err == nil {
+		hash, exists = s.chain.BlockHashAt(height)
+	} else if hash, err = parseHash(param); err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("block must be a hash or a height"))
+		return
+	} else {
+		exists = true
+	}
+
+	var block *t.Block
+
+	if exists {
+		block, exists = s.chain.GetBlock(hash)
+	}
+
+	if !exists {
+		writeError(w, http.StatusNotFound, errors.New("block not found"))
+		return
+	}
+
+	height, onMain := s.chain.HeightOf(hash)
+
+	if !onMain {
+		height = -1
+	}
+
+	writeJSON(w, rpc.BlockJSON(s.chain.Params(), hash, height, block))
+}
+
+func (s *Server) getOp(w http.ResponseWriter, r *http.Request) {
+	hash, err := parseHash(r.PathValue("hash"))
+
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	blockHash, height, position, found := s.index.FindOp(hash)
+	var op *IndexedOp
+
+	if found {
+		op = s.indexedOp(blockHash, height, position)
+	}
+
+	if op == nil {
+		writeError(w, http.StatusNotFound, errors.New("op is not on the main chain"))
+		return
+	}
+
+	writeJSON(w, op)
+}
+
+func (s *Server) getAddress(w http.ResponseWriter, r *http.Request) {
+	addr, err := s.chain.Params().ParseAddress(r.PathValue("address"))
+
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	offset, err := queryInt(r, "offset", 0)
+
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	limit, err := queryInt(r, "limit", defaultPageSize)
+
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	entries, total := s.index.History(addr, offset, min(limit, maxPageSize))
+	result := &AddressHistory{Address: s.chain.Params().FormatAddress(addr), Total: total, Offset: offset, Entries: make([]HistoryEntry, 0, len(entries))}
+
+	for _, entry := range entries {
+		// A block disconnected since History returned is just left out
+		if op := s.indexedOp(entry.BlockHash, entry.Height, entry.Position); op != nil {
+			result.Entries = append(result.Entries, HistoryEntry{IndexedOp: *op, Role: entry.Role})
+		}
+	}
+
+	writeJSON(w, result)
+}
+
+func (s *Server) indexedOp(blockHash [32]byte, height int, position int) *IndexedOp {
+	block, exists := s.chain.GetBlock(blockHash)
+
+	if !exists || position >= len(block.Operations) {
+		return nil
+	}
+
+	return &IndexedOp{
+		Op:        rpc.OpJSON(s.chain.Params(), block.Operations[position]),
+		BlockHash: hex.EncodeToString(blockHash[:]),
+		Height:    height,
+		Position:  position,
+	}
+}
+
+func queryInt(r *http.Request, name string, fallback int) (int, error) {
+	param := r.URL.Query().Get(name)
+
+	if param == "" {
+		return fallback, nil
+	}
+
+	value, err := strconv.Atoi(param)
+
+	if err != nil || value < 0 {
+		return 0, errors.New(name + " must be a number that isn't negative")
+	}
+
+	return value, nil
+}
+
+func parseHash(encoded string) ([32]byte, error) {
+	var hash [32]byte
+	data, err := hex.DecodeString(encoded)
+
+	if err != nil || len(data) != len(hash) {
+		return hash, errors.New("hash must be 32 bytes of hex")
+	}
+
+	copy(hash[:], data)
+	return hash, nil
+}
+
+func writeJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,161 @@
+package fees
+
+import (
+	b "gold/blockchain"
+	"gold/mempool"
+	t "gold/types"
+	"math"
+	"slices"
+	"sync"
+)
+
+const (
+	// Fee per byte suggested when nothing says more is needed, like on a fresh chain
+	FallbackFeeRate = 1
+	// Targets are clamped to this, and it's how many recent blocks are remembered
+	MaxTargetBlocks = 100
+	// For when there's no hurry
+	DefaultTargetBlocks = 6
+)
+
+// The fee rates paid in a connected block
+type blockFees struct {
+	// Fee rates of every op but the coinbase, lowest first
+	rates []float64
+	// Whether the block used at least half of the size it could have without a reward penalty, which
+	// is when miners start leaving low fee ops out
+	full bool
+}
+
+// Suggests fee rates from what recent blocks paid and how much is waiting in the mempool. Ops are
+// ranked by fee per byte, so fees are suggested per byte too.
+type Estimator struct {
+	chain *b.Chain
+	pool  *mempool.Pool
+
+	mu     sync.Mutex
+	blocks map[int]*blockFees
+	tip    int
+}
+
+// Starts listening to chain and fills in the recent blocks it already has
+func NewEstimator(chain *b.Chain, pool *mempool.Pool) *Estimator {
+	e := &Estimator{chain: chain, pool: pool, blocks: make(map[int]*blockFees)}
+	chain.AddListener(e)
+
+	// Blocks connected while this runs are already in blocks by now, and win
+	_, height := chain.Tip()
+
+	for h := max(1, height-MaxTargetBlocks+1); h <= height; h++ {
+		hash, exists := chain.BlockHashAt(h)
+
+		if !exists {
+			break
+		}
+
+		block, exists := chain.GetBlock(hash)
+
+		if !exists {
+			continue
+		}
+
+		e.mu.Lock()
+
+		// The median size at the time isn't known anymore, the smallest it can be is close enough
+		if _, known := e.blocks[h]; !known {
+			e.blocks[h] = measure(block, b.FullRewardZone)
+			e.tip = max(e.tip, h)
+		}
+
+		e.mu.Unlock()
+	}
+
+	return e
+}
+
+func measure(block *t.Block, medianSize int) *blockFees {
+	fees := &blockFees{rates: make([]float64, 0, len(block.Operations)), full: b.BlockSize(block) >= medianSize/2}
+
+	for _, op := range block.Operations[1:] {
+		fees.rates = append(fees.rates, mempool.FeeRate(op))
+	}
+
+	slices.Sort(fees.rates)
+	return fees
+}
+
+func (e *Estimator) BlockConnected(block *t.Block, undo *b.BlockUndo, state *t.State) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.blocks[state.Height] = measure(block, b.MedianBlockSize(state))
+	e.tip = state.Height
+
+	for height := range e.blocks {
+		if height <= e.tip-MaxTargetBlocks {
+			delete(e.blocks, height)
+		}
+	}
+}
+
+func (e *Estimator) BlockDisconnected(block *t.Block, undo *b.BlockUndo, state *t.State) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	delete(e.blocks, state.Height+1)
+	e.tip = state.Height
+}
+
+// Fee per byte an op should pay to be mined within targetBlocks blocks. It's the highest of
+//   - what it takes to get ahead of the mempool ops that would fill those blocks first
+//   - the lowest rate recent full blocks took, at a percentile that drops from 90% for the next block
+//     to the median for 5 blocks or more
+//   - FallbackFeeRate
+func (e *Estimator) EstimateFee(targetBlocks int) uint64 {
+	targetBlocks = min(max(targetBlocks, 1), MaxTargetBlocks)
+	return max(e.backlogRate(targetBlocks), e.recentRate(targetBlocks), FallbackFeeRate)
+}
+
+func (e *Estimator) recentRate(targetBlocks int) uint64 {
+	e.mu.Lock()
+	window := min(max(4*targetBlocks, 6), MaxTargetBlocks)
+	lowest := make([]float64, 0, window)
+
+	for height := e.tip - window + 1; height <= e.tip; height++ {
+		if fees, exists := e.blocks[height]; exists && fees.full && len(fees.rates) > 0 {
+			lowest = append(lowest, fees.rates[0])
+		}
+	}
+
+	e.mu.Unlock()
+
+	if len(lowest) == 0 {
+		return 0
+	}
+
+	slices.Sort(lowest)
+	percentile := max(0.5, 1-0.1*float64(targetBlocks))
+	return uint64(math.Ceil(lowest[int(percentile*float64(len(lowest)-1))]))
+}
+
+// One more than the fee rate of the op that would be left out once targetBlocks blocks have been filled
+// from the mempool, or 0 if they'd take all of it
+func (e *Estimator) backlogRate(targetBlocks int) uint64 {
+	var capacity int
+
+	e.chain.ReadState(func(state *t.State, tip [32]byte) {
+		capacity = targetBlocks * b.MedianBlockSize(state)
+	})
+
+	used := 0
+
+	for _, op := range e.pool.Ranked() {
+		used += len(op.Encode())
+
+		if used > capacity {
+			return uint64(math.Floor(mempool.FeeRate(op))) + 1
+		}
+	}
+
+	return 0
+}
@@ -2,6 +2,10 @@ module gold
 
 go 1.24.0
 
-require github.com/decred/dcrd/dcrec/secp256k1/v4 v4.4.0
+require (
+	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.4.0
+	github.com/gorilla/websocket v1.5.3
+	golang.org/x/crypto v0.36.0
+)
 
-require github.com/decred/dcrd/crypto/blake256 v1.1.0 // indirect
+require github.com/decred/dcrd/crypto/blake256 v1.1.0
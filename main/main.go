@@ -3,18 +3,36 @@ package main
 import (
 	"fmt"
 	b "gold/blockchain"
+	"os"
 )
 
 func main() {
-	minimalPk := b.MinimalPk()
+	params := &b.MainNetParams
 	minimalSig := b.MinimalSignature()
 
+	from, to := b.AddrFromName("GitMonke"), b.AddrFromKey(b.MinimalPk())
+
+	// The sender and receiver can be given as addresses, @name or bech32m
+	if len(os.Args) == 3 {
+		var err error
+
+		if from, err = params.ParseAddress(os.Args[1]); err == nil {
+			to, err = params.ParseAddress(os.Args[2])
+		}
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
 	txn := b.Txn{
-		Sender:    b.AddrFromName("GitMonke"),
-		Payments:  []b.Payment{{Reciever: b.AddrFromKey(minimalPk), Amount: 100_000_000_000}},
+		Sender:    from,
+		Payments:  []b.Payment{{Reciever: to, Amount: 100_000_000_000}},
 		Nonce:     0,
 		Signature: minimalSig,
 	}
 
+	fmt.Printf("%s -> %s\n", params.FormatAddress(from), params.FormatAddress(to))
 	fmt.Println(txn.Encode())
 }
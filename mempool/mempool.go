@@ -0,0 +1,589 @@
+package mempool
+
+import (
+	"container/heap"
+	"errors"
+	b "gold/blockchain"
+	t "gold/types"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+var (
+	ErrAlreadyPending    = errors.New("op is already in the mempool")
+	ErrMempoolFull       = errors.New("mempool is full and the op's fee rate is too low")
+	ErrReplacementFee    = errors.New("replacement does not pay enough fee over the op it replaces")
+	ErrReplacementLimit  = errors.New("too many replacements for this key and nonce")
+	ErrReplacementBreaks = errors.New("replacement would invalidate later pending ops")
+	ErrNamePending       = errors.New("a pending rename moves a name the op uses")
+)
+
+type Config struct {
+	// Total encoded size of all pending ops before the lowest fee rates get evicted
+	MaxBytes int
+	// A replacement needs a fee rate at least this many percent higher than the op it replaces
+	ReplaceFeeRateBump uint64
+	// On top of that, the absolute fee has to go up by this much per byte of the replacement,
+	// so every replacement pays for its own relay and churning them is never free
+	ReplaceIncrementalFeeRate uint64
+	// How many times the op at one key and nonce can be replaced before replacements are refused
+	MaxReplacements int
+}
+
+func DefaultConfig() Config {
+	return Config{
+		MaxBytes:                  32_000_000,
+		ReplaceFeeRateBump:        10,
+		ReplaceIncrementalFeeRate: 1,
+		MaxReplacements:           10,
+	}
+}
+
+type EventKind int
+
+const (
+	// Op was admitted to the pool
+	EventAdded EventKind = iota
+	// Op took the place of Replaced, which had the same liable key and nonce
+	EventReplaced
+)
+
+type Event struct {
+	Kind     EventKind
+	Op       t.Op
+	Replaced t.Op
+}
+
+// A pending nonce of a key, which replacements are counted against
+type slot struct {
+	key   secp256k1.PublicKey
+	nonce uint32
+}
+
+type entry struct {
+	op      t.Op
+	hash    [32]byte
+	key     secp256k1.PublicKey
+	size    int
+	feeRate float64
+}
+
+// Holds unconfirmed ops. Each liable key gets a queue of ops ordered by nonce, starting at
+// the account's nonce in the tip state with no gaps, so every queue can be mined front to back.
+type Pool struct {
+	mu     sync.Mutex
+	config Config
+	// Private copy of the tip state. Pending ops get applied to it and undone while validating.
+	state  t.State
+	queues map[secp256k1.PublicKey][]*entry
+	byHash map[[32]byte]*entry
+	bytes  int
+	// The pending rename of each name. Ops are only checked against their own key's queue, so an op
+	// using a name another pending op moves could resolve differently once mined, and is refused.
+	renames map[string]*entry
+
+	replacements map[slot]int
+	subscribers  []chan Event
+}
+
+func New(state *t.State, config Config) *Pool {
+	return &Pool{
+		config: config,
+		state:  b.CopyState(state),
+		queues: make(map[secp256k1.PublicKey][]*entry),
+		byHash: make(map[[32]byte]*entry),
+
+		renames:      make(map[string]*entry),
+		replacements: make(map[slot]int),
+	}
+}
+
+// Fee paid per byte of the op's encoding
+func FeeRate(op t.Op) float64 {
+	return feeRate(op.GetFee(), len(op.Encode()))
+}
+
+func feeRate(fee uint64, size int) float64 {
+	return float64(fee) / float64(size)
+}
+
+// Validates the op against the tip state plus the liable key's pending ops and queues it.
+// The op's nonce has to come right after the last pending op of the same key, or match a pending
+// op's nonce, in which case it replaces that op if it pays enough more fee. Ops using a name that
+// a pending rename moves are refused, unless they're replacing that rename.
+func (p *Pool) Add(op t.Op) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	replaced, err := p.add(op)
+
+	if err != nil {
+		return err
+	}
+
+	if replaced != nil {
+		p.publish(Event{Kind: EventReplaced, Op: op, Replaced: replaced})
+	} else {
+		p.publish(Event{Kind: EventAdded, Op: op})
+	}
+
+	return nil
+}
+
+// Returns the op that was replaced, if any
+func (p *Pool) add(op t.Op) (t.Op, error) {
+	hash := b.OpHash(op)
+
+	if _, exists := p.byHash[hash]; exists {
+		return nil, ErrAlreadyPending
+	}
+
+	keyPtr := op.LiableKey(&p.state)
+
+	if keyPtr == nil {
+		if err := op.Validate(&p.state); err != nil {
+			return nil, err
+		}
+
+		return nil, errors.New("op has no liable key")
+	}
+
+	key := *keyPtr
+	queue := p.queues[key]
+
+	for _, name := range names(op) {
+		if pending, exists := p.renames[name]; exists && (pending.key != key || pending.op.GetNonce() != op.GetNonce()) {
+			return nil, ErrNamePending
+		}
+	}
+
+	if len(queue) > 0 {
+		first := queue[0].op.GetNonce()
+
+		if nonce := op.GetNonce(); nonce >= first && nonce < first+uint32(len(queue)) {
+			return p.replace(queue, int(nonce-first), op)
+		}
+	}
+
+	if _, err := p.validateAfter(queue, []t.Op{op}); err != nil {
+		return nil, err
+	}
+
+	e := newEntry(op, hash, key)
+	p.queues[key] = append(queue, e)
+	p.index(e)
+	p.bytes += e.size
+
+	// If e doesn't fit either, whatever went before it shouldn't have gone. e is still the tail of its
+	// queue once the rest are back.
+	if evicted := p.evictOverflow(); slices.Contains(evicted, e) {
+		p.restore(evicted)
+		p.removeTail(e)
+
+		return nil, ErrMempoolFull
+	}
+
+	return nil, nil
+}
+
+// The names an op resolves or moves
+func names(op t.Op) []string {
+	var names []string
+
+	switch op := op.(type) {
+	case *b.Txn:
+		for _, addr := range op.Addresses() {
+			if addr.UsesName {
+				names = append(names, *addr.Name)
+			}
+		}
+	case *b.Rename:
+		names = append(names, op.Name)
+	}
+
+	return names
+}
+
+func (p *Pool) index(e *entry) {
+	p.byHash[e.hash] = e
+
+	if rename, ok := e.op.(*b.Rename); ok {
+		p.renames[rename.Name] = e
+	}
+}
+
+func (p *Pool) unindex(e *entry) {
+	delete(p.byHash, e.hash)
+
+	if rename, ok := e.op.(*b.Rename); ok && p.renames[rename.Name] == e {
+		delete(p.renames, rename.Name)
+	}
+}
+
+func newEntry(op t.Op, hash [32]byte, key secp256k1.PublicKey) *entry {
+	size := len(op.Encode())
+
+	return &entry{
+		op:      op,
+		hash:    hash,
+		key:     key,
+		size:    size,
+		feeRate: feeRate(op.GetFee(), size),
+	}
+}
+
+// Swaps queue[i] for op. The ops queued behind it have to stay valid, since the replacement
+// can spend more than the op it replaces. If the replacement doesn't fit in the pool, the pool is
+// left as it was.
+func (p *Pool) replace(queue []*entry, i int, op t.Op) (t.Op, error) {
+	old := queue[i]
+	s := slot{key: old.key, nonce: old.op.GetNonce()}
+
+	if p.replacements[s] >= p.config.MaxReplacements {
+		return nil, ErrReplacementLimit
+	}
+
+	e := newEntry(op, b.OpHash(op), old.key)
+	bumpedRate := old.feeRate * float64(100+p.config.ReplaceFeeRateBump) / 100
+	minFee := old.op.GetFee() + p.config.ReplaceIncrementalFeeRate*uint64(e.size)
+
+	if e.feeRate < bumpedRate || op.GetFee() < minFee {
+		return nil, ErrReplacementFee
+	}
+
+	ops := []t.Op{op}
+
+	for _, later := range queue[i+1:] {
+		ops = append(ops, later.op)
+	}
+
+	if failed, err := p.validateAfter(queue[:i], ops); failed == 0 && err != nil {
+		return nil, err
+	} else if err != nil {
+		return nil, ErrReplacementBreaks
+	}
+
+	p.swap(queue, i, old, e)
+	p.replacements[s] += 1
+
+	// Ops are only evicted from the ends of queues, so the ops behind e went before it did
+	if evicted := p.evictOverflow(); slices.Contains(evicted, e) {
+		p.restore(evicted)
+		p.swap(p.queues[old.key], i, e, old)
+		p.replacements[s] -= 1
+
+		return nil, ErrMempoolFull
+	}
+
+	return old.op, nil
+}
+
+// Puts to in from's place at queue[i]
+func (p *Pool) swap(queue []*entry, i int, from *entry, to *entry) {
+	queue[i] = to
+	p.unindex(from)
+	p.index(to)
+	p.bytes += to.size - from.size
+}
+
+// Evicts until the pool fits under the cap again. Returns the evicted ops in the order they went.
+func (p *Pool) evictOverflow() []*entry {
+	var evicted []*entry
+
+	for p.bytes > p.config.MaxBytes {
+		e := p.evict()
+
+		if e == nil {
+			break
+		}
+
+		evicted = append(evicted, e)
+	}
+
+	return evicted
+}
+
+// Undoes evictOverflow, putting the last evicted op back first so every queue is as it was
+func (p *Pool) restore(evicted []*entry) {
+	for _, e := range slices.Backward(evicted) {
+		p.queues[e.key] = append(p.queues[e.key], e)
+		p.index(e)
+		p.bytes += e.size
+	}
+}
+
+// Applies the queued ops to the private state, then validates and applies each of ops in turn.
+// Returns the position in ops of the first invalid one. Everything is undone before returning.
+func (p *Pool) validateAfter(queue []*entry, ops []t.Op) (int, error) {
+	undos := make([]t.UndoOp, 0, len(queue)+len(ops))
+
+	for _, pending := range queue {
+		undos = append(undos, pending.op.PerformOp(&p.state))
+	}
+
+	var err error
+	failed := 0
+
+	for i, op := range ops {
+		if err = op.Validate(&p.state); err != nil {
+			failed = i
+			break
+		}
+
+		undos = append(undos, op.PerformOp(&p.state))
+	}
+
+	for i := len(undos) - 1; i >= 0; i-- {
+		undos[i].PerformUndo(&p.state)
+	}
+
+	return failed, err
+}
+
+// Drops the op with the lowest fee rate among the ends of the queues. Only the last op of a
+// queue can go, otherwise the ops behind it would be left with a nonce gap.
+func (p *Pool) evict() *entry {
+	var worst *entry
+
+	for _, queue := range p.queues {
+		tail := queue[len(queue)-1]
+
+		if worst == nil || tail.feeRate < worst.feeRate {
+			worst = tail
+		}
+	}
+
+	if worst != nil {
+		p.removeTail(worst)
+	}
+
+	return worst
+}
+
+func (p *Pool) removeTail(e *entry) {
+	queue := p.queues[e.key]
+
+	if len(queue) == 1 {
+		delete(p.queues, e.key)
+	} else {
+		p.queues[e.key] = queue[:len(queue)-1]
+	}
+
+	p.unindex(e)
+	p.bytes -= e.size
+}
+
+func (p *Pool) Has(hash [32]byte) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, exists := p.byHash[hash]
+	return exists
+}
+
+// Returns nil if the op isn't pending
+func (p *Pool) Get(hash [32]byte) t.Op {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if e, exists := p.byHash[hash]; exists {
+		return e.op
+	}
+
+	return nil
+}
+
+func (p *Pool) Count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.byHash)
+}
+
+// Total encoded size of every pending op
+func (p *Pool) Bytes() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.bytes
+}
+
+// The pending ops of a key, in nonce order
+func (p *Pool) Pending(key *secp256k1.PublicKey) []t.Op {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	queue := p.queues[*key]
+	ops := make([]t.Op, len(queue))
+
+	for i, e := range queue {
+		ops[i] = e.op
+	}
+
+	return ops
+}
+
+// Every pending op, in no particular order
+func (p *Pool) Ops() []t.Op {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ops := make([]t.Op, 0, len(p.byHash))
+
+	for _, e := range p.byHash {
+		ops = append(ops, e.op)
+	}
+
+	return ops
+}
+
+// Every pending op, highest fee rate first. An op never comes before a lower nonce of the same
+// key, so any prefix of the result can be applied to the tip state in order.
+func (p *Pool) Ranked() []t.Op {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	heads := make(queueHeap, 0, len(p.queues))
+
+	for _, queue := range p.queues {
+		heads = append(heads, queue)
+	}
+
+	heap.Init(&heads)
+	ops := make([]t.Op, 0, len(p.byHash))
+
+	for heads.Len() > 0 {
+		queue := heads[0]
+		ops = append(ops, queue[0].op)
+
+		if len(queue) == 1 {
+			heap.Pop(&heads)
+		} else {
+			heads[0] = queue[1:]
+			heap.Fix(&heads, 0)
+		}
+	}
+
+	return ops
+}
+
+// Called once a block is connected and state is the new tip. Ops in the block are dropped and
+// everything else is revalidated, since the block may have spent balances the pending ops relied on.
+func (p *Pool) BlockConnected(block *t.Block, undo *b.BlockUndo, state *t.State) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, op := range block.Operations {
+		delete(p.byHash, b.OpHash(op))
+	}
+
+	p.rebuild(state, nil)
+}
+
+// Called once a block is disconnected and state is the new tip. The block's ops go back into the
+// pool so they can be mined again on the new chain. The first op of a block is its coinbase and is skipped.
+func (p *Pool) BlockDisconnected(block *t.Block, undo *b.BlockUndo, state *t.State) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var reinjected []t.Op
+
+	if len(block.Operations) > 1 {
+		reinjected = block.Operations[1:]
+	}
+
+	p.rebuild(state, reinjected)
+}
+
+// Resets the pool onto a new tip state and re-adds every op still in byHash plus extra.
+// Ops are added in nonce order so each queue is rebuilt front to back, and any that fail are dropped.
+func (p *Pool) rebuild(state *t.State, extra []t.Op) {
+	ops := make([]t.Op, 0, len(p.byHash)+len(extra))
+	ops = append(ops, extra...)
+
+	for _, queue := range p.queues {
+		for _, e := range queue {
+			if _, exists := p.byHash[e.hash]; exists {
+				ops = append(ops, e.op)
+			}
+		}
+	}
+
+	sort.SliceStable(ops, func(i, j int) bool {
+		return ops[i].GetNonce() < ops[j].GetNonce()
+	})
+
+	p.state = b.CopyState(state)
+	p.queues = make(map[secp256k1.PublicKey][]*entry)
+	p.byHash = make(map[[32]byte]*entry)
+	p.renames = make(map[string]*entry)
+	p.bytes = 0
+
+	for _, op := range ops {
+		p.add(op)
+	}
+
+	// Forget replacement counts for nonces that have been used up on chain
+	for s := range p.replacements {
+		account, exists := p.state.AccountSet[s.key]
+
+		if !exists || account.Nonce > s.nonce {
+			delete(p.replacements, s)
+		}
+	}
+}
+
+// Returns a channel that recieves every admission and replacement. Events are dropped rather than
+// blocking the pool if the channel's buffer is full. Call the returned func to unsubscribe.
+func (p *Pool) Subscribe(buffer int) (<-chan Event, func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ch := make(chan Event, buffer)
+	p.subscribers = append(p.subscribers, ch)
+
+	unsubscribe := func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		for i, sub := range p.subscribers {
+			if sub == ch {
+				p.subscribers = append(p.subscribers[:i], p.subscribers[i+1:]...)
+				close(ch)
+				return
+			}
+		}
+	}
+
+	return ch, unsubscribe
+}
+
+func (p *Pool) publish(event Event) {
+	for _, sub := range p.subscribers {
+		select {
+		case sub <- event:
+		default:
+		}
+	}
+}
+
+// Max heap of queues ordered by the fee rate of their first op
+type queueHeap [][]*entry
+
+func (h queueHeap) Len() int           { return len(h) }
+func (h queueHeap) Less(i, j int) bool { return h[i][0].feeRate > h[j][0].feeRate }
+func (h queueHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *queueHeap) Push(x any) {
+	*h = append(*h, x.([]*entry))
+}
+
+func (h *queueHeap) Pop() any {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
@@ -0,0 +1,162 @@
+package miner
+
+import (
+	"context"
+	"errors"
+	b "gold/blockchain"
+	"gold/mempool"
+	t "gold/types"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrNonceSpaceExhausted = errors.New("every header nonce was tried without meeting the target")
+	ErrExtraNonceExhausted = errors.New("every extra nonce in the partition was tried without meeting the target")
+)
+
+// How many hashes a thread does between checks for cancellation
+const checkInterval = 1 << 12
+
+// Splits the extra nonce space between miners working on the same template, such as several machines paying
+// the same coinbase. The miner at Index of Count uses the extra nonces Index, Index + Count, Index + 2 * Count
+// and so on, so no two miners ever build the same header. Threads of one miner split the header nonces instead.
+type Partition struct {
+	Index uint32
+	Count uint32
+}
+
+type Miner struct {
+	Params       *b.Params
+	Pool         *mempool.Pool
+	CoinbaseAddr t.Address
+	Threads      int
+	Partition    Partition
+	// How long to grind before checking whether the timestamp can be rolled forward
+	RollInterval time.Duration
+	// Where the miner gets the time from, replaceable for tests
+	Now func() time.Time
+}
+
+func New(params *b.Params, pool *mempool.Pool, coinbaseAddr t.Address, threads int) *Miner {
+	return &Miner{
+		Params:       params,
+		Pool:         pool,
+		CoinbaseAddr: coinbaseAddr,
+		Threads:      max(threads, 1),
+		Partition:    Partition{Index: 0, Count: 1},
+		RollInterval: time.Second,
+		Now:          time.Now,
+	}
+}
+
+// Builds a template on top of state and solves it. The block still has to be connected by the caller.
+// Every RollInterval the timestamp is rolled forward to the current time, and if time hasn't moved or the
+// header nonces run out, the next extra nonce of the miner's partition is used.
+func (m *Miner) MineBlock(ctx context.Context, state *t.State, prevHash [32]byte) (*t.Block, error) {
+	block := NewBlockTemplate(m.Pool, state, prevHash, &m.CoinbaseAddr, RollTimestamp(state, 0, m.Now()))
+	extraNonce := m.Partition.Index
+	b.SetExtraNonce(block, extraNonce)
+
+	for {
+		rollCtx, cancel := context.WithTimeout(ctx, m.RollInterval)
+		header, err := Solve(rollCtx, block.Header, m.Params.PowTarget, m.Threads)
+		cancel()
+
+		if err == nil {
+			block.Header = header
+			return block, nil
+		}
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		timestamp := RollTimestamp(state, block.Header.Timestamp, m.Now())
+
+		if timestamp != block.Header.Timestamp && err != ErrNonceSpaceExhausted {
+			block.Header.Timestamp = timestamp
+			continue
+		}
+
+		next := extraNonce + max(m.Partition.Count, 1)
+
+		if next < extraNonce {
+			return nil, ErrExtraNonceExhausted
+		}
+
+		extraNonce = next
+		b.SetExtraNonce(block, extraNonce)
+	}
+}
+
+// The timestamp to mine with. It follows the clock, but never goes backwards from current and is always
+// past the median time past, since a block at or before it is invalid.
+func RollTimestamp(state *t.State, current uint32, now time.Time) uint32 {
+	timestamp := max(current, uint32(now.Unix()))
+	earliest := b.MedianTimePast(state) + 1
+
+	if uint64(timestamp) < earliest {
+		return uint32(earliest)
+	}
+
+	return timestamp
+}
+
+// Grinds the header nonce until the header hash meets the target. Thread i tries nonces i, i + threads,
+// i + 2 * threads and so on, so no two threads ever hash the same header.
+func Solve(ctx context.Context, header t.Header, target [32]byte, threads int) (t.Header, error) {
+	threads = max(threads, 1)
+
+	var found atomic.Bool
+	var wg sync.WaitGroup
+	solutions := make(chan t.Header, threads)
+
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+
+		go func(candidate t.Header) {
+			defer wg.Done()
+
+			for count := 0; ; count++ {
+				if count%checkInterval == 0 && (found.Load() || ctx.Err() != nil) {
+					return
+				}
+
+				if b.CheckProofOfWork(candidate, target) {
+					found.Store(true)
+					solutions <- candidate
+					return
+				}
+
+				next := candidate.Nonce + uint64(threads)
+
+				// Wrapped around, this thread's share of the nonces is used up
+				if next < candidate.Nonce {
+					return
+				}
+
+				candidate.Nonce = next
+			}
+		}(withNonce(header, uint64(i)))
+	}
+
+	wg.Wait()
+	close(solutions)
+
+	if solution, ok := <-solutions; ok {
+		return solution, nil
+	}
+
+	if ctx.Err() != nil {
+		return header, ctx.Err()
+	}
+
+	return header, ErrNonceSpaceExhausted
+}
+
+func withNonce(header t.Header, nonce uint64) t.Header {
+	header.Nonce = nonce
+	return header
+}
@@ -0,0 +1,64 @@
+package miner
+
+import (
+	b "gold/blockchain"
+	"gold/mempool"
+	t "gold/types"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+// Builds the most profitable block on top of state out of the pool's ops, paying the coinbase to coinbaseAddr.
+// Ops are taken in fee rate order. Up to the median block size every valid op goes in, past it an op only
+// goes in if its fee covers the reward it costs. Once an op of a key is left out, so is every later op of that
+// key, since they'd be missing a nonce. The header is left for the caller to solve.
+func NewBlockTemplate(pool *mempool.Pool, state *t.State, prevHash [32]byte, coinbaseAddr *t.Address, timestamp uint32) *t.Block {
+	block := &t.Block{
+		Header: t.Header{
+			PrevBlockHash: prevHash,
+			Timestamp:     timestamp,
+		},
+		Operations: []t.Op{b.TemplateCoinbase(coinbaseAddr)},
+	}
+
+	working := b.CopyState(state)
+	median := b.MedianBlockSize(state)
+	size := b.BlockSize(block)
+	skipped := make(map[secp256k1.PublicKey]bool)
+
+	for _, op := range pool.Ranked() {
+		keyPtr := op.LiableKey(&working)
+
+		if keyPtr == nil || skipped[*keyPtr] {
+			continue
+		}
+
+		opSize := len(op.Encode())
+
+		if !worthIncluding(size, opSize, op.GetFee(), median) || op.Validate(&working) != nil {
+			skipped[*keyPtr] = true
+			continue
+		}
+
+		op.PerformOp(&working)
+		block.Operations = append(block.Operations, op)
+		size += opSize
+	}
+
+	block.Operations[0] = b.Coinbase(coinbaseAddr, block, state)
+	block.Header.MerkleRoot = b.CalculateMerkleRoot(block.Operations)
+
+	return block
+}
+
+// An op is worth including if the reward lost to the size penalty is less than its fee
+func worthIncluding(size int, opSize int, fee uint64, median int) bool {
+	rewardBefore, _ := b.BlockReward(size, median)
+	rewardAfter, err := b.BlockReward(size+opSize, median)
+
+	if err != nil {
+		return false
+	}
+
+	return size+opSize <= median || rewardAfter+fee > rewardBefore
+}
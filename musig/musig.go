@@ -0,0 +1,368 @@
+// Package musig is MuSig2 for the signatures ops use. Several signers aggregate their keys into one
+// ordinary looking public key, which can hold an account or own a name like any other. Signing takes
+// two rounds:
+//  1. every signer makes a Session and sends the others its PublicNonce
+//  2. once it has every nonce, each signer makes a PartialSignature and sends it to whoever combines them
+//
+// Combine adds the partial signatures into a schnorr signature that checks against the aggregate key,
+// so Txn.CheckSig and Rename.CheckSig take it as is.
+//
+// Ops are signed with EC-Schnorr-DCRv0 rather than BIP340, so this follows the MuSig2 paper with the
+// challenge computed the way that scheme does, and doesn't interoperate with BIP327.
+package musig
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/decred/dcrd/crypto/blake256"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/schnorr"
+)
+
+const (
+	PublicNonceSize      = 66
+	PartialSignatureSize = 32
+)
+
+var (
+	ErrNoKeys           = errors.New("no keys to aggregate")
+	ErrDuplicateKey     = errors.New("key is in the aggregate more than once")
+	ErrNotSigner        = errors.New("key isn't part of the aggregate")
+	ErrNonceCount       = errors.New("need one nonce per signer")
+	ErrNonceMismatch    = errors.New("session's own nonce isn't where its key is")
+	ErrNonceUsed        = errors.New("session has already signed")
+	ErrInvalidNonce     = errors.New("public nonce is invalid")
+	ErrInvalidPartial   = errors.New("partial signature is invalid")
+	ErrUnusableNonce    = errors.New("aggregate nonce can't be used")
+	ErrSignatureInvalid = errors.New("combined signature doesn't verify")
+)
+
+// The two points a signer commits to for one signing session, compressed
+type PublicNonce [PublicNonceSize]byte
+
+// A signer's share of the final s value
+type PartialSignature [PartialSignatureSize]byte
+
+// Keys combined into one. Each key gets a coefficient that depends on the whole set, so nobody can
+// pick their key after seeing the others' to cancel them out.
+type AggregateKey struct {
+	// Sorted by their compressed encoding, which is the order nonces and partial signatures go in
+	Keys         []*secp256k1.PublicKey
+	PublicKey    *secp256k1.PublicKey
+	coefficients []secp256k1.ModNScalar
+}
+
+// Aggregates keys. The order they're given in doesn't matter.
+func AggregateKeys(keys []*secp256k1.PublicKey) (*AggregateKey, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+
+	sorted := slices.Clone(keys)
+	slices.SortFunc(sorted, func(a, b *secp256k1.PublicKey) int {
+		return bytes.Compare(a.SerializeCompressed(), b.SerializeCompressed())
+	})
+
+	list := make([]byte, 0, len(sorted)*secp256k1.PubKeyBytesLenCompressed)
+
+	for i, key := range sorted {
+		if i > 0 && key.IsEqual(sorted[i-1]) {
+			return nil, fmt.Errorf("%w: %x", ErrDuplicateKey, key.SerializeCompressed())
+		}
+
+		list = append(list, key.SerializeCompressed()...)
+	}
+
+	listHash := taggedHash("gold/musig/keylist", list)
+	agg := &AggregateKey{Keys: sorted, coefficients: make([]secp256k1.ModNScalar, len(sorted))}
+	var sum secp256k1.JacobianPoint
+
+	for i, key := range sorted {
+		agg.coefficients[i] = hashToScalar("gold/musig/coefficient", listHash[:], key.SerializeCompressed())
+
+		var point, weighted secp256k1.JacobianPoint
+		key.AsJacobian(&point)
+		secp256k1.ScalarMultNonConst(&agg.coefficients[i], &point, &weighted)
+		secp256k1.AddNonConst(&sum, &weighted, &sum)
+	}
+
+	if isInfinity(&sum) {
+		return nil, ErrNoKeys
+	}
+
+	sum.ToAffine()
+	agg.PublicKey = secp256k1.NewPublicKey(&sum.X, &sum.Y)
+	return agg, nil
+}
+
+// Where key is in Keys, or -1
+func (agg *AggregateKey) Index(key *secp256k1.PublicKey) int {
+	return slices.IndexFunc(agg.Keys, key.IsEqual)
+}
+
+// One signer's side of signing one hash. A session signs once, reusing its nonces would leak the key.
+type Session struct {
+	agg    *AggregateKey
+	index  int
+	key    secp256k1.ModNScalar
+	hash   [32]byte
+	nonces [2]secp256k1.ModNScalar
+	public PublicNonce
+	used   bool
+}
+
+// Starts signing hash with sk, which has to be one of agg's keys
+func NewSession(agg *AggregateKey, sk *secp256k1.PrivateKey, hash [32]byte) (*Session, error) {
+	index := agg.Index(sk.PubKey())
+
+	if index < 0 {
+		return nil, ErrNotSigner
+	}
+
+	s := &Session{agg: agg, index: index, key: sk.Key, hash: hash}
+
+	// Random, with the key and hash mixed in so a bad random source alone doesn't repeat nonces
+	random := make([]byte, 32)
+	rand.Read(random)
+	keyBytes := sk.Key.Bytes()
+
+	for i := range s.nonces {
+		s.nonces[i] = hashToScalar("gold/musig/nonce", random, keyBytes[:], agg.PublicKey.SerializeCompressed(), hash[:], []byte{byte(i)})
+
+		var point secp256k1.JacobianPoint
+		secp256k1.ScalarBaseMultNonConst(&s.nonces[i], &point)
+		point.ToAffine()
+		copy(s.public[i*33:], secp256k1.NewPublicKey(&point.X, &point.Y).SerializeCompressed())
+	}
+
+	clear(keyBytes[:])
+	return s, nil
+}
+
+// What to send the other signers in the first round
+func (s *Session) PublicNonce() PublicNonce {
+	return s.public
+}
+
+// The second round. nonces has every signer's public nonce in the order of the aggregate's Keys,
+// this session's own included.
+func (s *Session) Sign(nonces []PublicNonce) (PartialSignature, error) {
+	if s.used {
+		return PartialSignature{}, ErrNonceUsed
+	}
+
+	if len(nonces) != len(s.agg.Keys) {
+		return PartialSignature{}, ErrNonceCount
+	}
+
+	if nonces[s.index] != s.public {
+		return PartialSignature{}, ErrNonceMismatch
+	}
+
+	ctx, err := newSigningContext(s.agg, s.hash, nonces)
+
+	if err != nil {
+		return PartialSignature{}, err
+	}
+
+	// s_i = k1 + b*k2 - e*a_i*d_i, with the nonce part negated if R had to be
+	k := new(secp256k1.ModNScalar).Mul2(&ctx.b, &s.nonces[1]).Add(&s.nonces[0])
+
+	if ctx.negate {
+		k.Negate()
+	}
+
+	partial := new(secp256k1.ModNScalar).Mul2(&ctx.e, &s.agg.coefficients[s.index]).Mul(&s.key).Negate().Add(k)
+
+	s.used = true
+	s.key.Zero()
+	s.nonces[0].Zero()
+	s.nonces[1].Zero()
+	k.Zero()
+
+	return partial.Bytes(), nil
+}
+
+// Adds the partial signatures into the final signature, checking each one first so a bad signer is
+// named rather than just producing a signature that doesn't verify. nonces and partials are in the
+// order of the aggregate's Keys.
+func Combine(agg *AggregateKey, hash [32]byte, nonces []PublicNonce, partials []PartialSignature) (*schnorr.Signature, error) {
+	if len(nonces) != len(agg.Keys) || len(partials) != len(agg.Keys) {
+		return nil, ErrNonceCount
+	}
+
+	ctx, err := newSigningContext(agg, hash, nonces)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var sum secp256k1.ModNScalar
+
+	for i := range partials {
+		if err := ctx.verifyPartial(agg, i, nonces[i], partials[i]); err != nil {
+			return nil, err
+		}
+
+		var partial secp256k1.ModNScalar
+		partial.SetBytes((*[32]byte)(&partials[i]))
+		sum.Add(&partial)
+	}
+
+	sig := schnorr.NewSignature(&ctx.r, &sum)
+
+	if !sig.Verify(hash[:], agg.PublicKey) {
+		return nil, ErrSignatureInvalid
+	}
+
+	return sig, nil
+}
+
+// What every signer works out the same way once it has all the nonces
+type signingContext struct {
+	// Weight of each signer's second nonce
+	b secp256k1.ModNScalar
+	// The challenge, as schnorr.Verify computes it from r and the hash
+	e secp256k1.ModNScalar
+	r secp256k1.FieldVal
+	// Whether R = R1 + b*R2 had an odd y, so every nonce has to be negated to match the even R the
+	// signature scheme requires
+	negate bool
+}
+
+func newSigningContext(agg *AggregateKey, hash [32]byte, nonces []PublicNonce) (*signingContext, error) {
+	var r1, r2 secp256k1.JacobianPoint
+
+	for i, nonce := range nonces {
+		first, second, err := nonce.points()
+
+		if err != nil {
+			return nil, fmt.Errorf("%w: signer %d", err, i)
+		}
+
+		secp256k1.AddNonConst(&r1, &first, &r1)
+		secp256k1.AddNonConst(&r2, &second, &r2)
+	}
+
+	if isInfinity(&r1) || isInfinity(&r2) {
+		return nil, ErrUnusableNonce
+	}
+
+	r1.ToAffine()
+	r2.ToAffine()
+	ctx := &signingContext{}
+	ctx.b = hashToScalar("gold/musig/noncecoef",
+		secp256k1.NewPublicKey(&r1.X, &r1.Y).SerializeCompressed(),
+		secp256k1.NewPublicKey(&r2.X, &r2.Y).SerializeCompressed(),
+		agg.PublicKey.SerializeCompressed(), hash[:])
+
+	var weighted, R secp256k1.JacobianPoint
+	secp256k1.ScalarMultNonConst(&ctx.b, &r2, &weighted)
+	secp256k1.AddNonConst(&r1, &weighted, &R)
+
+	if isInfinity(&R) {
+		return nil, ErrUnusableNonce
+	}
+
+	R.ToAffine()
+	ctx.negate = R.Y.IsOdd()
+	ctx.r = R.X
+
+	// e = BLAKE-256(r || m), which schnorr doesn't export. It's rejected rather than reduced when it's
+	// over the group order, which happens about 1 in 2^128 times.
+	var input [64]byte
+	ctx.r.PutBytesUnchecked(input[:32])
+	copy(input[32:], hash[:])
+	commitment := blake256.Sum256(input[:])
+
+	if overflow := ctx.e.SetBytes(&commitment); overflow != 0 {
+		return nil, ErrUnusableNonce
+	}
+
+	return ctx, nil
+}
+
+// Checks s_i*G + e*a_i*X_i equals the signer's effective nonce
+func (ctx *signingContext) verifyPartial(agg *AggregateKey, i int, nonce PublicNonce, partial PartialSignature) error {
+	var s secp256k1.ModNScalar
+
+	if overflow := s.SetBytes((*[32]byte)(&partial)); overflow != 0 {
+		return fmt.Errorf("%w: signer %d", ErrInvalidPartial, i)
+	}
+
+	first, second, err := nonce.points()
+
+	if err != nil {
+		return fmt.Errorf("%w: signer %d", err, i)
+	}
+
+	var expected, weighted secp256k1.JacobianPoint
+	secp256k1.ScalarMultNonConst(&ctx.b, &second, &weighted)
+	secp256k1.AddNonConst(&first, &weighted, &expected)
+
+	var sG, key, eaX, actual secp256k1.JacobianPoint
+	ea := new(secp256k1.ModNScalar).Mul2(&ctx.e, &agg.coefficients[i])
+	agg.Keys[i].AsJacobian(&key)
+	secp256k1.ScalarBaseMultNonConst(&s, &sG)
+	secp256k1.ScalarMultNonConst(ea, &key, &eaX)
+	secp256k1.AddNonConst(&sG, &eaX, &actual)
+
+	expected.ToAffine()
+	actual.ToAffine()
+
+	if ctx.negate {
+		expected.Y.Negate(1).Normalize()
+	}
+
+	if !expected.X.Equals(&actual.X) || !expected.Y.Equals(&actual.Y) {
+		return fmt.Errorf("%w: signer %d", ErrInvalidPartial, i)
+	}
+
+	return nil
+}
+
+func (nonce PublicNonce) points() (secp256k1.JacobianPoint, secp256k1.JacobianPoint, error) {
+	var points [2]secp256k1.JacobianPoint
+
+	for i := range points {
+		key, err := secp256k1.ParsePubKey(nonce[i*33 : (i+1)*33])
+
+		if err != nil {
+			return points[0], points[1], ErrInvalidNonce
+		}
+
+		key.AsJacobian(&points[i])
+	}
+
+	return points[0], points[1], nil
+}
+
+func isInfinity(point *secp256k1.JacobianPoint) bool {
+	return (point.X.IsZero() && point.Y.IsZero()) || point.Z.IsZero()
+}
+
+// sha256(sha256(tag) || sha256(tag) || data...), like BIP340, so hashes for different purposes never collide
+func taggedHash(tag string, data ...[]byte) [32]byte {
+	tagHash := sha256.Sum256([]byte(tag))
+	h := sha256.New()
+	h.Write(tagHash[:])
+	h.Write(tagHash[:])
+
+	for _, d := range data {
+		h.Write(d)
+	}
+
+	return [32]byte(h.Sum(nil))
+}
+
+func hashToScalar(tag string, data ...[]byte) secp256k1.ModNScalar {
+	hash := taggedHash(tag, data...)
+	var scalar secp256k1.ModNScalar
+	scalar.SetBytes(&hash)
+	return scalar
+}
@@ -0,0 +1,433 @@
+package p2p
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	mathrand "math/rand/v2"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	newBucketCount   = 256
+	triedBucketCount = 64
+	bucketSize       = 64
+	// How many new buckets the addresses from one source group can land in, and how many tried buckets
+	// one address group can land in. Keeps a single network from taking over the book.
+	newBucketsPerSourceGroup = 16
+	triedBucketsPerGroup     = 8
+	// Addresses whose last attempt failed this recently are skipped by Select
+	retryDelay = 30 * time.Second
+)
+
+// What the book knows about one address
+type KnownAddress struct {
+	Addr        string
+	Source      string
+	LastSeen    time.Time
+	LastTried   time.Time
+	LastSuccess time.Time
+	Attempts    int
+	Tried       bool
+
+	bucket int
+}
+
+// Peers the node has heard of. Addresses start in the new table and move to the tried table once an
+// outbound connection to them works. Both tables are split into buckets picked by a keyed hash of the
+// address's network group, so the addresses one network can get in are limited, and a full bucket evicts
+// from whichever group has the most entries in it. The book is saved to path, if set, so a restarted node
+// has peers to reconnect to straight away.
+type AddrBook struct {
+	path string
+	// Secret per book, so others can't work out which bucket an address will land in
+	key [32]byte
+
+	mu    sync.Mutex
+	addrs map[string]*KnownAddress
+	new   [newBucketCount][]*KnownAddress
+	tried [triedBucketCount][]*KnownAddress
+}
+
+type savedAddrBook struct {
+	Key   [32]byte
+	Addrs []*KnownAddress
+}
+
+// Loads the book saved at path, or starts an empty one if there isn't one yet. An empty path keeps
+// the book in memory only.
+func LoadAddrBook(path string) (*AddrBook, error) {
+	book := &AddrBook{path: path, addrs: make(map[string]*KnownAddress)}
+	rand.Read(book.key[:])
+
+	if path == "" {
+		return book, nil
+	}
+
+	data, err := os.ReadFile(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return book, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var saved savedAddrBook
+
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, err
+	}
+
+	book.key = saved.Key
+
+	for _, known := range saved.Addrs {
+		if known.Tried {
+			book.insertTried(known)
+		} else {
+			book.insertNew(known)
+		}
+	}
+
+	return book, nil
+}
+
+// Reads seed peers from a file with one "host:port" per line. Blank lines and lines starting with # are skipped.
+func ReadSeedFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	seeds := make([]string, 0)
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if _, _, err := net.SplitHostPort(line); err != nil {
+			return nil, err
+		}
+
+		seeds = append(seeds, line)
+	}
+
+	return seeds, scanner.Err()
+}
+
+// Adds addresses heard from source to the new table. Addresses already known just have LastSeen updated.
+// Returns how many were new.
+func (b *AddrBook) Add(addrs []string, source string, lastSeen time.Time) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	added := 0
+
+	for _, addr := range addrs {
+		if known, exists := b.addrs[addr]; exists {
+			if lastSeen.After(known.LastSeen) {
+				known.LastSeen = lastSeen
+			}
+
+			continue
+		}
+
+		b.insertNew(&KnownAddress{Addr: addr, Source: source, LastSeen: lastSeen})
+		added += 1
+	}
+
+	return added
+}
+
+// Records a connection attempt. Until Good is called for it, Select leaves the address alone for retryDelay.
+func (b *AddrBook) Attempt(addr string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if known, exists := b.addrs[addr]; exists {
+		known.LastTried = time.Now()
+		known.Attempts += 1
+	}
+}
+
+// Moves an address that was successfully connected to into the tried table
+func (b *AddrBook) Good(addr string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	known, exists := b.addrs[addr]
+
+	if !exists {
+		known = &KnownAddress{Addr: addr, Source: addr}
+	} else {
+		b.remove(known)
+	}
+
+	now := time.Now()
+	known.LastSeen = now
+	known.LastSuccess = now
+	known.Attempts = 0
+	b.insertTried(known)
+}
+
+// Picks an address to connect to, half the time from each table if both have any. Addresses that skip
+// returns true for, or that failed within retryDelay, are never picked.
+func (b *AddrBook) Select(skip func(addr string) bool) (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	candidates := make([]*KnownAddress, 0)
+	triedCandidates := make([]*KnownAddress, 0)
+
+	for _, known := range b.addrs {
+		failedRecently := known.LastTried.After(known.LastSuccess) && time.Since(known.LastTried) < retryDelay
+
+		if failedRecently || skip(known.Addr) {
+			continue
+		}
+
+		if known.Tried {
+			triedCandidates = append(triedCandidates, known)
+		} else {
+			candidates = append(candidates, known)
+		}
+	}
+
+	if len(triedCandidates) > 0 && (len(candidates) == 0 || mathrand.IntN(2) == 0) {
+		candidates = triedCandidates
+	}
+
+	if len(candidates) == 0 {
+		return "", false
+	}
+
+	return candidates[mathrand.IntN(len(candidates))].Addr, true
+}
+
+// Up to max random addresses for sharing with peers. Only IP addresses are shared.
+func (b *AddrBook) Addresses(max int) []PeerAddress {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	addrs := make([]PeerAddress, 0, min(max, len(b.addrs)))
+
+	for _, known := range b.addrs {
+		if len(addrs) == max {
+			break
+		}
+
+		if addr, ok := parsePeerAddress(known.Addr, known.LastSeen); ok {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	return addrs
+}
+
+// How many addresses are in the new and tried tables
+func (b *AddrBook) Size() (int, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	tried := 0
+
+	for _, known := range b.addrs {
+		if known.Tried {
+			tried += 1
+		}
+	}
+
+	return len(b.addrs) - tried, tried
+}
+
+// Writes the book to its path, through a temporary file so a crash never leaves half a book behind
+func (b *AddrBook) Save() error {
+	if b.path == "" {
+		return nil
+	}
+
+	b.mu.Lock()
+	saved := savedAddrBook{Key: b.key, Addrs: make([]*KnownAddress, 0, len(b.addrs))}
+
+	for _, known := range b.addrs {
+		saved.Addrs = append(saved.Addrs, known)
+	}
+
+	data, err := json.Marshal(saved)
+	b.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(b.path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(b.path+".tmp", b.path)
+}
+
+func (b *AddrBook) insertNew(known *KnownAddress) {
+	group := addrGroup(known.Addr)
+	index := b.hash(addrGroup(known.Source), b.hash(group)%newBucketsPerSourceGroup) % newBucketCount
+
+	known.Tried = false
+	known.bucket = int(index)
+	b.new[index] = b.insert(b.new[index], known)
+}
+
+func (b *AddrBook) insertTried(known *KnownAddress) {
+	group := addrGroup(known.Addr)
+	index := b.hash(group, b.hash(known.Addr)%triedBucketsPerGroup) % triedBucketCount
+
+	known.Tried = true
+	known.bucket = int(index)
+	bucket := b.tried[index]
+
+	// Whatever a full tried bucket evicts goes back to the new table rather than being forgotten
+	if len(bucket) >= bucketSize {
+		evicted := diversityVictim(bucket)
+		b.remove(evicted)
+		b.addrs[known.Addr] = known
+		b.tried[index] = append(b.tried[index], known)
+		b.insertNew(evicted)
+		return
+	}
+
+	b.tried[index] = b.insert(bucket, known)
+}
+
+// Appends known to bucket, evicting from the most common group first if it's full
+func (b *AddrBook) insert(bucket []*KnownAddress, known *KnownAddress) []*KnownAddress {
+	if len(bucket) >= bucketSize {
+		evicted := diversityVictim(bucket)
+		delete(b.addrs, evicted.Addr)
+		bucket = removeFrom(bucket, evicted)
+	}
+
+	b.addrs[known.Addr] = known
+	return append(bucket, known)
+}
+
+func (b *AddrBook) remove(known *KnownAddress) {
+	delete(b.addrs, known.Addr)
+
+	if known.Tried {
+		b.tried[known.bucket] = removeFrom(b.tried[known.bucket], known)
+	} else {
+		b.new[known.bucket] = removeFrom(b.new[known.bucket], known)
+	}
+}
+
+func removeFrom(bucket []*KnownAddress, known *KnownAddress) []*KnownAddress {
+	for i, other := range bucket {
+		if other == known {
+			return append(bucket[:i], bucket[i+1:]...)
+		}
+	}
+
+	return bucket
+}
+
+// The entry to evict from a full bucket. Entries from the group with the most entries in the bucket go first,
+// then the ones that failed the most attempts, then the ones heard from longest ago.
+func diversityVictim(bucket []*KnownAddress) *KnownAddress {
+	groupCounts := make(map[string]int)
+
+	for _, known := range bucket {
+		groupCounts[addrGroup(known.Addr)] += 1
+	}
+
+	victim := bucket[0]
+
+	for _, known := range bucket[1:] {
+		count, victimCount := groupCounts[addrGroup(known.Addr)], groupCounts[addrGroup(victim.Addr)]
+
+		if count != victimCount {
+			if count > victimCount {
+				victim = known
+			}
+
+			continue
+		}
+
+		if known.Attempts != victim.Attempts {
+			if known.Attempts > victim.Attempts {
+				victim = known
+			}
+
+			continue
+		}
+
+		if known.LastSeen.Before(victim.LastSeen) {
+			victim = known
+		}
+	}
+
+	return victim
+}
+
+func (b *AddrBook) hash(data string, extra ...uint64) uint64 {
+	input := append(b.key[:0:0], b.key[:]...)
+	input = append(input, data...)
+
+	for _, value := range extra {
+		input = binary.LittleEndian.AppendUint64(input, value)
+	}
+
+	sum := sha256.Sum256(input)
+	return binary.LittleEndian.Uint64(sum[:8])
+}
+
+// The network an address belongs to: the /16 for IPv4, the /32 for IPv6, and the host itself for names
+func addrGroup(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+
+	if err != nil {
+		host = addr
+	}
+
+	ip := net.ParseIP(host)
+
+	if ip == nil {
+		return host
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(16, 32)).String()
+	}
+
+	return ip.Mask(net.CIDRMask(32, 128)).String()
+}
+
+func parsePeerAddress(addr string, lastSeen time.Time) (PeerAddress, bool) {
+	host, portString, err := net.SplitHostPort(addr)
+
+	if err != nil {
+		return PeerAddress{}, false
+	}
+
+	ip := net.ParseIP(host)
+	port, err := net.LookupPort("tcp", portString)
+
+	if ip == nil || err != nil {
+		return PeerAddress{}, false
+	}
+
+	return PeerAddress{IP: ip, Port: uint16(port), LastSeen: uint32(lastSeen.Unix())}, true
+}
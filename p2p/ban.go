@@ -0,0 +1,178 @@
+package p2p
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Misbehavior points for each kind of offence. A host is banned once its score reaches Config.BanThreshold,
+// 100 by default. Blocks and headers that fail validation or their proof of work can't be sent by accident,
+// so one is enough. A bad signature takes two, and a malformed message four, since a buggy peer can send
+// those too.
+const (
+	penaltyMalformedMessage = 25
+	penaltyInvalidBlock     = 100
+	penaltyInvalidHeader    = 100
+	// Other invalid ops cost nothing, since they can go stale between being relayed and arriving
+	penaltyBadSignature = 50
+)
+
+// Misbehavior scores halve every hour, so a host that slips up now and then is never banned for it
+const scoreHalfLife = time.Hour
+
+// Hosts that are banned and when each ban ends, and the misbehavior score of every host that hasn't been
+// banned yet. Both are by IP, so a peer can't start over by reconnecting, from another port or not.
+// If path is set the bans are saved there whenever they change. Scores only live in memory.
+type banList struct {
+	path string
+
+	mu     sync.Mutex
+	bans   map[string]time.Time
+	scores map[string]hostScore
+}
+
+type hostScore struct {
+	points int
+	// When points was last worked out, it's decayed from there
+	at time.Time
+}
+
+// Rounded, so points added moments apart add up exactly
+func (s hostScore) decayed(now time.Time) int {
+	return int(math.Round(float64(s.points) * math.Exp2(-now.Sub(s.at).Seconds()/scoreHalfLife.Seconds())))
+}
+
+func loadBanList(path string) (*banList, error) {
+	list := &banList{path: path, bans: make(map[string]time.Time), scores: make(map[string]hostScore)}
+
+	if path == "" {
+		return list, nil
+	}
+
+	data, err := os.ReadFile(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return list, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &list.bans); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+func (l *banList) ban(host string, until time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.bans[host] = until
+	delete(l.scores, host)
+	return l.save()
+}
+
+// Adds points to the host's score and returns the scores before and after
+func (l *banList) addScore(host string, points int) (int, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	before := l.scores[host].decayed(now)
+	l.scores[host] = hostScore{points: before + points, at: now}
+
+	return before, before + points
+}
+
+func (l *banList) score(host string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.scores[host].decayed(time.Now())
+}
+
+func (l *banList) unban(host string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.bans, host)
+	return l.save()
+}
+
+func (l *banList) banned(host string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	until, exists := l.bans[host]
+
+	if exists && time.Now().After(until) {
+		delete(l.bans, host)
+		l.save()
+		return false
+	}
+
+	return exists
+}
+
+// Writes to a temporary file first so a crash never leaves half a list behind
+func (l *banList) save() error {
+	if l.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(l.bans)
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(l.path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(l.path+".tmp", l.path)
+}
+
+// The IP part of a "host:port" address
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+
+	if err != nil {
+		return addr
+	}
+
+	return host
+}
+
+// Refills at rate tokens per second up to burst. Only used from the peer's read loop, so it isn't locked.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) tokenBucket {
+	return tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+func (t *tokenBucket) take() bool {
+	now := time.Now()
+	t.tokens = min(t.burst, t.tokens+now.Sub(t.last).Seconds()*t.rate)
+	t.last = now
+
+	if t.tokens < 1 {
+		return false
+	}
+
+	t.tokens -= 1
+	return true
+}
@@ -0,0 +1,292 @@
+package p2p
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	b "gold/blockchain"
+	t "gold/types"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+)
+
+// Most compact blocks waiting on missing ops at once. Past this the oldest are forgotten and the full
+// block gets fetched if it's still wanted.
+const maxPendingCompactBlocks = 16
+
+// Returned by ReconstructBlock when two ops could be behind the same short ID, in which case the full
+// block has to be fetched instead
+var ErrShortIDCollision = errors.New("short ID matches more than one op")
+
+// First 6 bytes of the sha256 of an op's encoding, salted per block so nobody can make ops that collide
+// in every block
+type ShortID [6]byte
+
+// The salt for a compact block's short IDs, from its header and the nonce the sender picked
+func compactKey(header t.Header, nonce uint64) [32]byte {
+	data := binary.LittleEndian.AppendUint64(b.EncodeHeader(header), nonce)
+	return sha256.Sum256(data)
+}
+
+func ShortOpID(key [32]byte, op t.Op) ShortID {
+	sum := sha256.Sum256(append(key[:], op.Encode()...))
+
+	var id ShortID
+	copy(id[:], sum[:])
+	return id
+}
+
+// Builds the compact form of a block. The coinbase is always prefilled, since no mempool has it.
+func NewCompactBlock(block *t.Block, nonce uint64) *MsgCompactBlock {
+	key := compactKey(block.Header, nonce)
+	compact := &MsgCompactBlock{Header: block.Header, Nonce: nonce, ShortIDs: make([]ShortID, 0, len(block.Operations))}
+
+	for i, op := range block.Operations {
+		if i == 0 {
+			compact.Prefilled = append(compact.Prefilled, PrefilledOp{Index: 0, Op: op})
+			continue
+		}
+
+		compact.ShortIDs = append(compact.ShortIDs, ShortOpID(key, op))
+	}
+
+	return compact
+}
+
+// Fills in a compact block from the prefilled ops and whichever of ops match its short IDs. Positions nothing
+// matched are left nil in the block and returned as missing. The merkle root isn't checked, since a short ID
+// can match the wrong op without anyone knowing until the whole block is there.
+func ReconstructBlock(compact *MsgCompactBlock, ops []t.Op) (*t.Block, []uint32, error) {
+	count := len(compact.ShortIDs) + len(compact.Prefilled)
+	block := &t.Block{Header: compact.Header, Operations: make([]t.Op, count)}
+
+	for _, prefilled := range compact.Prefilled {
+		if int(prefilled.Index) >= count || block.Operations[prefilled.Index] != nil {
+			return nil, nil, errors.New("prefilled op has a bad index")
+		}
+
+		block.Operations[prefilled.Index] = prefilled.Op
+	}
+
+	slots := make(map[ShortID]int, len(compact.ShortIDs))
+	next := 0
+
+	for _, id := range compact.ShortIDs {
+		for block.Operations[next] != nil {
+			next += 1
+		}
+
+		if _, exists := slots[id]; exists {
+			return nil, nil, ErrShortIDCollision
+		}
+
+		slots[id] = next
+		next += 1
+	}
+
+	key := compactKey(compact.Header, compact.Nonce)
+	filled := make(map[int]bool)
+
+	for _, op := range ops {
+		slot, exists := slots[ShortOpID(key, op)]
+
+		if !exists {
+			continue
+		}
+
+		if filled[slot] {
+			return nil, nil, ErrShortIDCollision
+		}
+
+		filled[slot] = true
+		block.Operations[slot] = op
+	}
+
+	missing := make([]uint32, 0)
+
+	for i, op := range block.Operations {
+		if op == nil {
+			missing = append(missing, uint32(i))
+		}
+	}
+
+	return block, missing, nil
+}
+
+// How compact block relay has gone so far
+type CompactBlockStats struct {
+	Received int
+	// Blocks rebuilt without fetching the full block, whether or not some ops had to be asked for
+	Reconstructed int
+	OpsRequested  int
+	// Blocks that had to be fetched in full after a short ID collision
+	Fallbacks int
+}
+
+type compactRelay struct {
+	received      atomic.Int64
+	reconstructed atomic.Int64
+	opsRequested  atomic.Int64
+	fallbacks     atomic.Int64
+
+	mu sync.Mutex
+	// Compact blocks waiting on the ops asked for with getblockops, by block hash
+	pending map[[32]byte]*partialBlock
+	// Counts compact blocks as they're added to pending, so the oldest can be found
+	arrivals uint64
+}
+
+type partialBlock struct {
+	peer    *Peer
+	block   *t.Block
+	missing []uint32
+	arrival uint64
+}
+
+func newCompactRelay() *compactRelay {
+	return &compactRelay{pending: make(map[[32]byte]*partialBlock)}
+}
+
+// Drops the pending compact block that arrived first. Called with mu held.
+func (c *compactRelay) forgetOldest() {
+	var oldest [32]byte
+	var oldestArrival uint64
+
+	for hash, partial := range c.pending {
+		if oldestArrival == 0 || partial.arrival < oldestArrival {
+			oldest, oldestArrival = hash, partial.arrival
+		}
+	}
+
+	delete(c.pending, oldest)
+}
+
+func (n *Node) CompactBlockStats() CompactBlockStats {
+	return CompactBlockStats{
+		Received:      int(n.compact.received.Load()),
+		Reconstructed: int(n.compact.reconstructed.Load()),
+		OpsRequested:  int(n.compact.opsRequested.Load()),
+		Fallbacks:     int(n.compact.fallbacks.Load()),
+	}
+}
+
+func (n *Node) handleCompactBlock(peer *Peer, compact *MsgCompactBlock) {
+	n.compact.received.Add(1)
+	hash := b.HashBlockHeader(compact.Header)
+
+	if n.chain.HasBlock(hash) {
+		return
+	}
+
+	// The header is checked on its own first, so a bad one costs nothing to rebuild
+	if err := n.chain.ProcessHeader(compact.Header); err == b.ErrOrphanBlock {
+		peer.Send(&MsgGetHeaders{Locator: n.chain.HeaderLocator()})
+		return
+	} else if errors.Is(err, b.ErrInvalidHeader) || errors.Is(err, b.ErrInvalidBlock) {
+		n.misbehaving(peer, penaltyInvalidHeader, err.Error())
+		return
+	} else if err != nil && err != b.ErrDuplicateBlock {
+		return
+	}
+
+	block, missing, err := ReconstructBlock(compact, n.pool.Ops())
+
+	if err == ErrShortIDCollision {
+		n.fallBackToFullBlock(peer, hash)
+		return
+	}
+
+	if err != nil {
+		n.misbehaving(peer, penaltyMalformedMessage, err.Error())
+		return
+	}
+
+	if len(missing) == 0 {
+		n.finishCompactBlock(peer, hash, block)
+		return
+	}
+
+	n.compact.mu.Lock()
+
+	if len(n.compact.pending) >= maxPendingCompactBlocks {
+		n.compact.forgetOldest()
+	}
+
+	n.compact.arrivals += 1
+	n.compact.pending[hash] = &partialBlock{peer: peer, block: block, missing: missing, arrival: n.compact.arrivals}
+	n.compact.mu.Unlock()
+
+	n.compact.opsRequested.Add(int64(len(missing)))
+	peer.Send(&MsgGetBlockOps{BlockHash: hash, Indexes: missing})
+}
+
+func (n *Node) handleGetBlockOps(peer *Peer, getBlockOps *MsgGetBlockOps) {
+	block, exists := n.chain.GetBlock(getBlockOps.BlockHash)
+
+	if !exists {
+		peer.Send(&MsgNotFound{Items: []InvItem{{Type: InvBlock, Hash: getBlockOps.BlockHash}}})
+		return
+	}
+
+	ops := make([]t.Op, 0, len(getBlockOps.Indexes))
+
+	for _, index := range getBlockOps.Indexes {
+		if int(index) >= len(block.Operations) {
+			n.misbehaving(peer, penaltyMalformedMessage, "asked for an op past the end of a block")
+			return
+		}
+
+		ops = append(ops, block.Operations[index])
+	}
+
+	peer.Send(&MsgBlockOps{BlockHash: getBlockOps.BlockHash, Ops: ops})
+}
+
+func (n *Node) handleBlockOps(peer *Peer, blockOps *MsgBlockOps) {
+	n.compact.mu.Lock()
+	partial, exists := n.compact.pending[blockOps.BlockHash]
+
+	if exists && partial.peer == peer {
+		delete(n.compact.pending, blockOps.BlockHash)
+	}
+
+	n.compact.mu.Unlock()
+
+	// Unasked for ops are ignored
+	if !exists || partial.peer != peer {
+		return
+	}
+
+	if len(blockOps.Ops) != len(partial.missing) {
+		n.misbehaving(peer, penaltyMalformedMessage, "sent the wrong number of block ops")
+		return
+	}
+
+	for i, index := range partial.missing {
+		partial.block.Operations[index] = blockOps.Ops[i]
+	}
+
+	n.finishCompactBlock(peer, blockOps.BlockHash, partial.block)
+}
+
+// A rebuilt block whose merkle root doesn't match had a short ID match the wrong op, so the full block
+// is fetched instead
+func (n *Node) finishCompactBlock(peer *Peer, hash [32]byte, block *t.Block) {
+	if b.CalculateMerkleRoot(block.Operations) != block.Header.MerkleRoot {
+		n.fallBackToFullBlock(peer, hash)
+		return
+	}
+
+	n.compact.reconstructed.Add(1)
+	n.processBlock(peer, block)
+}
+
+func (n *Node) fallBackToFullBlock(peer *Peer, hash [32]byte) {
+	n.compact.fallbacks.Add(1)
+	peer.Send(&MsgGetData{Items: []InvItem{{Type: InvBlock, Hash: hash}}})
+}
+
+func (n *Node) sendCompactBlock(peer *Peer, block *t.Block) {
+	peer.Send(NewCompactBlock(block, rand.Uint64()))
+}
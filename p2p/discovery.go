@@ -0,0 +1,159 @@
+package p2p
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	connectInterval   = time.Second
+	saveAddrsInterval = 5 * time.Minute
+	// Addr messages this small are passed on, since they're most likely a node announcing itself
+	maxAddrRelay = 10
+	// How many peers each relayed address is passed on to
+	addrRelayPeers = 2
+)
+
+func (n *Node) AddrBook() *AddrBook {
+	return n.addrs
+}
+
+// Keeps TargetOutbound connections open to peers from the address book, and saves the book now and then
+func (n *Node) connectLoop() {
+	defer n.wg.Done()
+
+	connectTicker := time.NewTicker(connectInterval)
+	defer connectTicker.Stop()
+	saveTicker := time.NewTicker(saveAddrsInterval)
+	defer saveTicker.Stop()
+
+	n.fillOutbound()
+
+	for {
+		select {
+		case <-connectTicker.C:
+			n.fillOutbound()
+		case <-saveTicker.C:
+			n.addrs.Save()
+		case <-n.quit:
+			return
+		}
+	}
+}
+
+func (n *Node) fillOutbound() {
+	if n.config.TargetOutbound <= 0 {
+		return
+	}
+
+	n.mu.Lock()
+	outbound := len(n.dialing)
+	skip := make(map[string]struct{})
+
+	for addr := range n.dialing {
+		skip[addr] = struct{}{}
+	}
+
+	for peer := range n.peers {
+		if !peer.Inbound() {
+			outbound += 1
+		}
+
+		skip[peer.Addr()] = struct{}{}
+		skip[peer.ListenAddr()] = struct{}{}
+	}
+
+	if n.listener != nil {
+		skip[n.listener.Addr().String()] = struct{}{}
+	}
+
+	n.mu.Unlock()
+
+	for ; outbound < n.config.TargetOutbound; outbound++ {
+		addr, ok := n.addrs.Select(func(addr string) bool {
+			_, skipped := skip[addr]
+			return skipped || n.Banned(hostOf(addr))
+		})
+
+		if !ok {
+			return
+		}
+
+		skip[addr] = struct{}{}
+		n.addrs.Attempt(addr)
+
+		n.mu.Lock()
+		n.dialing[addr] = struct{}{}
+		n.mu.Unlock()
+
+		go func() {
+			n.Connect(addr)
+
+			n.mu.Lock()
+			delete(n.dialing, addr)
+			n.mu.Unlock()
+		}()
+	}
+}
+
+// Outbound peers that worked go in the tried table and get asked for more addresses. Inbound peers that
+// accept connections are added to the book and announced to a couple of other peers.
+func (n *Node) peerConnected(peer *Peer) {
+	if !peer.Inbound() {
+		n.addrs.Good(peer.Addr())
+		peer.Send(&MsgGetAddr{})
+		return
+	}
+
+	if listenAddr := peer.ListenAddr(); listenAddr != "" {
+		if n.addrs.Add([]string{listenAddr}, peer.Addr(), time.Now()) > 0 {
+			if addr, ok := parsePeerAddress(listenAddr, time.Now()); ok {
+				n.relayAddrs([]PeerAddress{addr}, peer)
+			}
+		}
+	}
+}
+
+func (n *Node) handleAddr(peer *Peer, msg *MsgAddr) {
+	now := time.Now()
+	fresh := make([]PeerAddress, 0)
+
+	for _, addr := range msg.Addresses {
+		if addr.Port == 0 || addr.IP.IsUnspecified() {
+			continue
+		}
+
+		// Nobody gets to claim they saw a peer in the future
+		lastSeen := time.Unix(int64(addr.LastSeen), 0)
+
+		if lastSeen.After(now) {
+			lastSeen = now
+		}
+
+		if n.addrs.Add([]string{addr.String()}, peer.Addr(), lastSeen) > 0 {
+			fresh = append(fresh, addr)
+		}
+	}
+
+	// Only addresses that were new here get passed on, so announcements die out instead of looping
+	if len(msg.Addresses) <= maxAddrRelay && len(fresh) > 0 {
+		n.relayAddrs(fresh, peer)
+	}
+}
+
+func (n *Node) relayAddrs(addrs []PeerAddress, from *Peer) {
+	peers := n.Peers()
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	sent := 0
+
+	for _, peer := range peers {
+		if sent == addrRelayPeers {
+			return
+		}
+
+		if peer != from {
+			peer.Send(&MsgAddr{Addresses: addrs})
+			sent += 1
+		}
+	}
+}
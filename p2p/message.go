@@ -0,0 +1,648 @@
+package p2p
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	b "gold/blockchain"
+	t "gold/types"
+	"io"
+	"net"
+	"strconv"
+)
+
+const (
+	ProtocolVersion uint32 = 2
+	// Peers older than this are refused during the handshake. Version 2 added ListenPort.
+	MinProtocolVersion uint32 = 2
+	// Most headers sent in reply to one getheaders
+	MaxHeadersPerMessage = 2000
+	// Most items in one inv, getdata or notfound
+	MaxInvPerMessage = 50_000
+	// Most addresses in one addr
+	MaxAddrPerMessage = 1000
+)
+
+// Returned by ReadMessage for anything no honest peer would send
+var ErrMalformedMessage = errors.New("malformed message")
+
+type Command uint8
+
+const (
+	CmdVersion Command = iota
+	CmdVerAck
+	CmdPing
+	CmdPong
+	CmdInv
+	CmdGetData
+	CmdNotFound
+	CmdGetHeaders
+	CmdHeaders
+	CmdBlock
+	CmdOp
+	CmdDisconnect
+	CmdGetAddr
+	CmdAddr
+	CmdCompactBlock
+	CmdGetBlockOps
+	CmdBlockOps
+)
+
+type Message interface {
+	Command() Command
+	Encode() []byte
+}
+
+// Sent by both sides as soon as a connection opens. Nonce is random per node, so a node that
+// dials itself sees its own nonce come back and hangs up.
+type MsgVersion struct {
+	Version     uint32
+	ChainID     uint32
+	GenesisHash [32]byte
+	Height      uint32
+	Nonce       uint64
+	// Port the sender accepts peers on, or 0 if it doesn't
+	ListenPort uint16
+}
+
+type MsgVerAck struct{}
+
+type MsgPing struct {
+	Nonce uint64
+}
+
+type MsgPong struct {
+	Nonce uint64
+}
+
+type InvType uint8
+
+const (
+	InvBlock InvType = iota
+	InvOp
+	// Asks for a block as a MsgCompactBlock, only used in getdata
+	InvCompactBlock
+)
+
+// A block is identified by its header hash and an op by OpHash
+type InvItem struct {
+	Type InvType
+	Hash [32]byte
+}
+
+// Announces blocks and ops the sender has
+type MsgInv struct {
+	Items []InvItem
+}
+
+// Asks for the blocks and ops behind the items
+type MsgGetData struct {
+	Items []InvItem
+}
+
+// Reply to the items of a getdata the sender doesn't have
+type MsgNotFound struct {
+	Items []InvItem
+}
+
+// Asks for the headers after the first locator hash the reciever has on its main chain, up to Stop or
+// MaxHeadersPerMessage. A zero Stop means no limit.
+type MsgGetHeaders struct {
+	Locator [][32]byte
+	Stop    [32]byte
+}
+
+type MsgHeaders struct {
+	Headers []t.Header
+}
+
+type MsgBlock struct {
+	Block *t.Block
+}
+
+type MsgOp struct {
+	Op t.Op
+}
+
+// Sent before hanging up so the other side knows why
+type MsgDisconnect struct {
+	Reason string
+}
+
+// A block as its header and a short ID for each op, see compact.go. Ops the reciever can't have, like the
+// coinbase, are sent whole in Prefilled. ShortIDs are for the rest of the ops in block order.
+type MsgCompactBlock struct {
+	Header    t.Header
+	Nonce     uint64
+	ShortIDs  []ShortID
+	Prefilled []PrefilledOp
+}
+
+type PrefilledOp struct {
+	// Position of the op in the block
+	Index uint32
+	Op    t.Op
+}
+
+// Asks for the ops of a compact block that the reciever couldn't find in its mempool
+type MsgGetBlockOps struct {
+	BlockHash [32]byte
+	Indexes   []uint32
+}
+
+// Reply to getblockops, with the ops in the order they were asked for
+type MsgBlockOps struct {
+	BlockHash [32]byte
+	Ops       []t.Op
+}
+
+// Asks for some of the addresses the reciever knows
+type MsgGetAddr struct{}
+
+// Where a peer can be reached and when it was last heard from. IP is always 16 bytes on the wire,
+// with IPv4 mapped into IPv6.
+type PeerAddress struct {
+	IP       net.IP
+	Port     uint16
+	LastSeen uint32
+}
+
+func (a PeerAddress) String() string {
+	return net.JoinHostPort(a.IP.String(), strconv.Itoa(int(a.Port)))
+}
+
+// Shares known peer addresses, either in reply to getaddr or unprompted when a node starts listening
+type MsgAddr struct {
+	Addresses []PeerAddress
+}
+
+func (m *MsgVersion) Command() Command      { return CmdVersion }
+func (m *MsgVerAck) Command() Command       { return CmdVerAck }
+func (m *MsgPing) Command() Command         { return CmdPing }
+func (m *MsgPong) Command() Command         { return CmdPong }
+func (m *MsgInv) Command() Command          { return CmdInv }
+func (m *MsgGetData) Command() Command      { return CmdGetData }
+func (m *MsgNotFound) Command() Command     { return CmdNotFound }
+func (m *MsgGetHeaders) Command() Command   { return CmdGetHeaders }
+func (m *MsgHeaders) Command() Command      { return CmdHeaders }
+func (m *MsgBlock) Command() Command        { return CmdBlock }
+func (m *MsgOp) Command() Command           { return CmdOp }
+func (m *MsgDisconnect) Command() Command   { return CmdDisconnect }
+func (m *MsgGetAddr) Command() Command      { return CmdGetAddr }
+func (m *MsgAddr) Command() Command         { return CmdAddr }
+func (m *MsgCompactBlock) Command() Command { return CmdCompactBlock }
+func (m *MsgGetBlockOps) Command() Command  { return CmdGetBlockOps }
+func (m *MsgBlockOps) Command() Command     { return CmdBlockOps }
+
+func (m *MsgVersion) Encode() []byte {
+	data := binary.LittleEndian.AppendUint32(nil, m.Version)
+	data = binary.LittleEndian.AppendUint32(data, m.ChainID)
+	data = append(data, m.GenesisHash[:]...)
+	data = binary.LittleEndian.AppendUint32(data, m.Height)
+	data = binary.LittleEndian.AppendUint64(data, m.Nonce)
+	data = binary.LittleEndian.AppendUint16(data, m.ListenPort)
+	return data
+}
+
+func (m *MsgVerAck) Encode() []byte {
+	return nil
+}
+
+func (m *MsgPing) Encode() []byte {
+	return binary.LittleEndian.AppendUint64(nil, m.Nonce)
+}
+
+func (m *MsgPong) Encode() []byte {
+	return binary.LittleEndian.AppendUint64(nil, m.Nonce)
+}
+
+func (m *MsgInv) Encode() []byte {
+	return encodeInv(m.Items)
+}
+
+func (m *MsgGetData) Encode() []byte {
+	return encodeInv(m.Items)
+}
+
+func (m *MsgNotFound) Encode() []byte {
+	return encodeInv(m.Items)
+}
+
+func (m *MsgGetHeaders) Encode() []byte {
+	data := []byte{byte(len(m.Locator))}
+
+	for _, hash := range m.Locator {
+		data = append(data, hash[:]...)
+	}
+
+	return append(data, m.Stop[:]...)
+}
+
+func (m *MsgHeaders) Encode() []byte {
+	data := binary.LittleEndian.AppendUint32(nil, uint32(len(m.Headers)))
+
+	for _, header := range m.Headers {
+		data = append(data, b.EncodeHeader(header)...)
+	}
+
+	return data
+}
+
+func (m *MsgBlock) Encode() []byte {
+	return b.EncodeBlock(m.Block)
+}
+
+func (m *MsgOp) Encode() []byte {
+	return m.Op.Encode()
+}
+
+func (m *MsgDisconnect) Encode() []byte {
+	reason := []byte(m.Reason)[:min(len(m.Reason), 255)]
+	return append([]byte{byte(len(reason))}, reason...)
+}
+
+func (m *MsgGetAddr) Encode() []byte {
+	return nil
+}
+
+func (m *MsgAddr) Encode() []byte {
+	data := binary.LittleEndian.AppendUint32(nil, uint32(len(m.Addresses)))
+
+	for _, addr := range m.Addresses {
+		data = append(data, addr.IP.To16()...)
+		data = binary.LittleEndian.AppendUint16(data, addr.Port)
+		data = binary.LittleEndian.AppendUint32(data, addr.LastSeen)
+	}
+
+	return data
+}
+
+func (m *MsgCompactBlock) Encode() []byte {
+	data := b.EncodeHeader(m.Header)
+	data = binary.LittleEndian.AppendUint64(data, m.Nonce)
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(m.ShortIDs)))
+
+	for _, id := range m.ShortIDs {
+		data = append(data, id[:]...)
+	}
+
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(m.Prefilled)))
+
+	for _, prefilled := range m.Prefilled {
+		data = binary.LittleEndian.AppendUint32(data, prefilled.Index)
+		data = encodeOp(data, prefilled.Op)
+	}
+
+	return data
+}
+
+func (m *MsgGetBlockOps) Encode() []byte {
+	data := append([]byte{}, m.BlockHash[:]...)
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(m.Indexes)))
+
+	for _, index := range m.Indexes {
+		data = binary.LittleEndian.AppendUint32(data, index)
+	}
+
+	return data
+}
+
+func (m *MsgBlockOps) Encode() []byte {
+	data := append([]byte{}, m.BlockHash[:]...)
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(m.Ops)))
+
+	for _, op := range m.Ops {
+		data = encodeOp(data, op)
+	}
+
+	return data
+}
+
+// Ops inside other messages are prefixed with their length, since they aren't the whole payload
+func encodeOp(data []byte, op t.Op) []byte {
+	encoded := op.Encode()
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(encoded)))
+	return append(data, encoded...)
+}
+
+func encodeInv(items []InvItem) []byte {
+	data := binary.LittleEndian.AppendUint32(nil, uint32(len(items)))
+
+	for _, item := range items {
+		data = append(data, byte(item.Type))
+		data = append(data, item.Hash[:]...)
+	}
+
+	return data
+}
+
+// Reads fixed size fields off a payload, failing once it runs out
+type payloadReader struct {
+	data []byte
+	err  error
+}
+
+func (r *payloadReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+
+	if len(r.data) < n {
+		r.err = errors.New("payload is too short")
+		return nil
+	}
+
+	chunk := r.data[:n]
+	r.data = r.data[n:]
+	return chunk
+}
+
+func (r *payloadReader) byte() byte {
+	if chunk := r.next(1); chunk != nil {
+		return chunk[0]
+	}
+
+	return 0
+}
+
+func (r *payloadReader) uint16() uint16 {
+	if chunk := r.next(2); chunk != nil {
+		return binary.LittleEndian.Uint16(chunk)
+	}
+
+	return 0
+}
+
+func (r *payloadReader) uint32() uint32 {
+	if chunk := r.next(4); chunk != nil {
+		return binary.LittleEndian.Uint32(chunk)
+	}
+
+	return 0
+}
+
+func (r *payloadReader) uint64() uint64 {
+	if chunk := r.next(8); chunk != nil {
+		return binary.LittleEndian.Uint64(chunk)
+	}
+
+	return 0
+}
+
+func (r *payloadReader) hash() [32]byte {
+	var hash [32]byte
+	copy(hash[:], r.next(32))
+	return hash
+}
+
+// A count of items that are at least itemSize bytes each, checked against what's left of the payload
+func (r *payloadReader) count(itemSize int, limit int) int {
+	count := int(r.uint32())
+
+	if r.err == nil && (count > limit || count*itemSize > len(r.data)) {
+		r.err = errors.New("payload claims more items than allowed")
+	}
+
+	return count
+}
+
+func (r *payloadReader) op() t.Op {
+	size := int(r.uint32())
+	data := r.next(size)
+
+	if r.err != nil {
+		return nil
+	}
+
+	op, err := b.DecodeOp(data)
+
+	if err != nil {
+		r.err = err
+	}
+
+	return op
+}
+
+func (r *payloadReader) remaining() int {
+	return len(r.data)
+}
+
+func (r *payloadReader) finish() error {
+	if r.err == nil && len(r.data) != 0 {
+		r.err = errors.New("payload continues past the end of the message")
+	}
+
+	return r.err
+}
+
+func decodeInv(r *payloadReader) []InvItem {
+	count := r.count(33, MaxInvPerMessage)
+	items := make([]InvItem, 0, count)
+
+	for i := 0; i < count && r.err == nil; i++ {
+		items = append(items, InvItem{Type: InvType(r.byte()), Hash: r.hash()})
+	}
+
+	return items
+}
+
+// Decodes the payload of a message with the given command
+func DecodeMessage(command Command, payload []byte) (Message, error) {
+	r := &payloadReader{data: payload}
+	var msg Message
+
+	switch command {
+	case CmdVersion:
+		msg = &MsgVersion{
+			Version:     r.uint32(),
+			ChainID:     r.uint32(),
+			GenesisHash: r.hash(),
+			Height:      r.uint32(),
+			Nonce:       r.uint64(),
+			ListenPort:  r.uint16(),
+		}
+	case CmdVerAck:
+		msg = &MsgVerAck{}
+	case CmdPing:
+		msg = &MsgPing{Nonce: r.uint64()}
+	case CmdPong:
+		msg = &MsgPong{Nonce: r.uint64()}
+	case CmdInv:
+		msg = &MsgInv{Items: decodeInv(r)}
+	case CmdGetData:
+		msg = &MsgGetData{Items: decodeInv(r)}
+	case CmdNotFound:
+		msg = &MsgNotFound{Items: decodeInv(r)}
+	case CmdGetHeaders:
+		getHeaders := &MsgGetHeaders{}
+		count := int(r.byte())
+
+		for i := 0; i < count && r.err == nil; i++ {
+			getHeaders.Locator = append(getHeaders.Locator, r.hash())
+		}
+
+		getHeaders.Stop = r.hash()
+		msg = getHeaders
+	case CmdHeaders:
+		count := r.count(b.HeaderSize, MaxHeadersPerMessage)
+		headers := &MsgHeaders{Headers: make([]t.Header, 0, count)}
+
+		for i := 0; i < count && r.err == nil; i++ {
+			header, err := b.DecodeHeader(r.next(b.HeaderSize))
+
+			if r.err == nil && err != nil {
+				r.err = err
+			}
+
+			headers.Headers = append(headers.Headers, header)
+		}
+
+		msg = headers
+	case CmdBlock:
+		block, err := b.DecodeBlock(payload)
+		return &MsgBlock{Block: block}, err
+	case CmdOp:
+		op, err := b.DecodeOp(payload)
+		return &MsgOp{Op: op}, err
+	case CmdDisconnect:
+		msg = &MsgDisconnect{Reason: string(r.next(int(r.byte())))}
+	case CmdCompactBlock:
+		header, err := b.DecodeHeader(r.next(b.HeaderSize))
+
+		if r.err == nil && err != nil {
+			r.err = err
+		}
+
+		compact := &MsgCompactBlock{Header: header, Nonce: r.uint64()}
+		count := r.count(len(ShortID{}), r.remaining())
+		compact.ShortIDs = make([]ShortID, count)
+
+		for i := 0; i < count && r.err == nil; i++ {
+			copy(compact.ShortIDs[i][:], r.next(len(ShortID{})))
+		}
+
+		count = r.count(8, r.remaining())
+
+		for i := 0; i < count && r.err == nil; i++ {
+			compact.Prefilled = append(compact.Prefilled, PrefilledOp{Index: r.uint32(), Op: r.op()})
+		}
+
+		msg = compact
+	case CmdGetBlockOps:
+		getBlockOps := &MsgGetBlockOps{BlockHash: r.hash()}
+		count := r.count(4, r.remaining())
+
+		for i := 0; i < count && r.err == nil; i++ {
+			getBlockOps.Indexes = append(getBlockOps.Indexes, r.uint32())
+		}
+
+		msg = getBlockOps
+	case CmdBlockOps:
+		blockOps := &MsgBlockOps{BlockHash: r.hash()}
+		count := r.count(4, r.remaining())
+
+		for i := 0; i < count && r.err == nil; i++ {
+			blockOps.Ops = append(blockOps.Ops, r.op())
+		}
+
+		msg = blockOps
+	case CmdGetAddr:
+		msg = &MsgGetAddr{}
+	case CmdAddr:
+		count := r.count(22, MaxAddrPerMessage)
+		addr := &MsgAddr{Addresses: make([]PeerAddress, 0, count)}
+
+		for i := 0; i < count && r.err == nil; i++ {
+			ip := net.IP(append([]byte{}, r.next(16)...))
+			addr.Addresses = append(addr.Addresses, PeerAddress{IP: ip, Port: r.uint16(), LastSeen: r.uint32()})
+		}
+
+		msg = addr
+	default:
+		return nil, fmt.Errorf("unknown command %d", command)
+	}
+
+	if err := r.finish(); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// Frames are the command, the payload length, then the payload
+func WriteMessage(w io.Writer, msg Message) error {
+	payload := msg.Encode()
+	frame := []byte{byte(msg.Command())}
+	frame = binary.LittleEndian.AppendUint32(frame, uint32(len(payload)))
+	frame = append(frame, payload...)
+
+	_, err := w.Write(frame)
+	return err
+}
+
+// Largest payload each command can have. Blocks get b.MaxBlockEncodingSize of the largest block that can
+// follow the tip, and an op can take up a whole block.
+func MaxPayloadSize(command Command, maxBlockSize int) int {
+	switch command {
+	case CmdVersion:
+		return 54
+	case CmdVerAck:
+		return 0
+	case CmdPing, CmdPong:
+		return 8
+	case CmdInv, CmdGetData, CmdNotFound:
+		return 4 + MaxInvPerMessage*33
+	case CmdGetHeaders:
+		return 1 + 255*32 + 32
+	case CmdHeaders:
+		return 4 + MaxHeadersPerMessage*b.HeaderSize
+	case CmdBlock:
+		return b.MaxBlockEncodingSize(maxBlockSize)
+	case CmdOp:
+		return maxBlockSize
+	case CmdDisconnect:
+		return 256
+	case CmdCompactBlock, CmdBlockOps:
+		return 2*maxBlockSize + 4
+	case CmdGetBlockOps:
+		// An index for every op, and every op is well over 4 bytes
+		return 36 + 2*maxBlockSize
+	case CmdGetAddr:
+		return 0
+	case CmdAddr:
+		return 4 + MaxAddrPerMessage*22
+	default:
+		return 0
+	}
+}
+
+// Reads one message, refusing payloads bigger than MaxPayloadSize allows. Oversized, unknown and undecodable
+// messages give an error wrapping ErrMalformedMessage.
+func ReadMessage(r io.Reader, maxBlockSize int) (Message, error) {
+	var prefix [5]byte
+
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+
+	command := Command(prefix[0])
+	size := binary.LittleEndian.Uint32(prefix[1:])
+
+	if limit := MaxPayloadSize(command, maxBlockSize); size > uint32(limit) {
+		return nil, fmt.Errorf("%w: command %d of %d bytes is over the limit of %d", ErrMalformedMessage, command, size, limit)
+	}
+
+	payload := make([]byte, size)
+
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	msg, err := DecodeMessage(command, payload)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedMessage, err)
+	}
+
+	return msg, nil
+}
@@ -0,0 +1,515 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	b "gold/blockchain"
+	"gold/mempool"
+	t "gold/types"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Config struct {
+	Params *b.Params
+	// Address to accept peers on, such as "127.0.0.1:0". Empty means outbound connections only.
+	ListenAddr   string
+	MaxPeers     int
+	PingInterval time.Duration
+	// How long a peer gets to send a requested block before it's asked of someone else
+	BlockRequestTimeout time.Duration
+	// Called with the sync progress as headers and blocks arrive, if set
+	OnProgress func(SyncProgress)
+	// Misbehavior score at which a peer is banned, and for how long
+	BanThreshold int
+	BanDuration  time.Duration
+	// Where bans are kept between restarts. Empty keeps them in memory only.
+	BanFile string
+	// Ops each peer can relay per second, with bursts of up to OpRateBurst. Ops past that are dropped.
+	// Zero turns the limit off.
+	OpRateLimit float64
+	OpRateBurst int
+	// Where the address book is kept between restarts. Empty keeps it in memory only.
+	AddrBookFile string
+	// File of peers to add to the address book on start, see ReadSeedFile
+	SeedFile string
+	// Outbound connections the node keeps open to peers from its address book. Zero only connects when told to.
+	TargetOutbound int
+}
+
+func DefaultConfig(params *b.Params) Config {
+	return Config{
+		Params:              params,
+		ListenAddr:          ":8333",
+		MaxPeers:            125,
+		PingInterval:        2 * time.Minute,
+		BlockRequestTimeout: 20 * time.Second,
+		BanThreshold:        100,
+		BanDuration:         24 * time.Hour,
+		OpRateLimit:         10,
+		OpRateBurst:         100,
+		TargetOutbound:      8,
+	}
+}
+
+// Connects the chain and mempool to peers. Blocks and ops are announced with inv and fetched with getdata.
+// Nodes that are behind sync headers first, see syncManager.
+type Node struct {
+	config  Config
+	chain   *b.Chain
+	pool    *mempool.Pool
+	sync    *syncManager
+	bans    *banList
+	addrs   *AddrBook
+	compact *compactRelay
+	// Kept up to date with the tip, since every message read is checked against it
+	blockSizeLimit atomic.Int64
+	// Identifies this node in version messages so it never connects to itself
+	nonce uint64
+
+	mu       sync.Mutex
+	listener net.Listener
+	peers    map[*Peer]struct{}
+	// Addresses being dialed by the connect loop
+	dialing  map[string]struct{}
+	quit     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// Creates the node and hooks it into the chain and pool so new blocks and ops get announced.
+// Fails if the ban file, address book or seed file can't be read.
+func NewNode(config Config, chain *b.Chain, pool *mempool.Pool) (*Node, error) {
+	bans, err := loadBanList(config.BanFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := LoadAddrBook(config.AddrBookFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if config.SeedFile != "" {
+		seeds, err := ReadSeedFile(config.SeedFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		addrs.Add(seeds, "seed", time.Now())
+	}
+
+	node := &Node{
+		config:  config,
+		chain:   chain,
+		pool:    pool,
+		nonce:   rand.Uint64(),
+		peers:   make(map[*Peer]struct{}),
+		dialing: make(map[string]struct{}),
+		quit:    make(chan struct{}),
+		bans:    bans,
+		addrs:   addrs,
+		compact: newCompactRelay(),
+	}
+
+	chain.ReadState(func(state *t.State, tip [32]byte) {
+		node.blockSizeLimit.Store(int64(b.MaxBlockSize(state)))
+	})
+
+	node.sync = newSyncManager(node)
+	chain.AddListener(node)
+	go node.sync.run()
+
+	events, unsubscribe := pool.Subscribe(1024)
+
+	go func() {
+		defer unsubscribe()
+
+		for {
+			select {
+			case event := <-events:
+				node.broadcast(&MsgInv{Items: []InvItem{{Type: InvOp, Hash: b.OpHash(event.Op)}}}, nil)
+			case <-node.quit:
+				return
+			}
+		}
+	}()
+
+	return node, nil
+}
+
+// Starts accepting peers on the configured address and connecting to peers from the address book
+func (n *Node) Start() error {
+	if n.config.ListenAddr != "" {
+		listener, err := net.Listen("tcp", n.config.ListenAddr)
+
+		if err != nil {
+			return err
+		}
+
+		n.mu.Lock()
+		n.listener = listener
+		n.mu.Unlock()
+
+		n.wg.Add(1)
+		go n.acceptLoop(listener)
+	}
+
+	n.wg.Add(1)
+	go n.connectLoop()
+
+	return nil
+}
+
+// The address the node is listening on, or nil if it isn't
+func (n *Node) Addr() net.Addr {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.listener == nil {
+		return nil
+	}
+
+	return n.listener.Addr()
+}
+
+func (n *Node) acceptLoop(listener net.Listener) {
+	defer n.wg.Done()
+
+	for {
+		conn, err := listener.Accept()
+
+		if err != nil {
+			return
+		}
+
+		if n.Banned(hostOf(conn.RemoteAddr().String())) {
+			conn.Close()
+			continue
+		}
+
+		go n.setupPeer(conn, true)
+	}
+}
+
+// Dials a peer and waits for the handshake to finish
+func (n *Node) Connect(addr string) (*Peer, error) {
+	if n.Banned(hostOf(addr)) {
+		return nil, errors.New("peer is banned")
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, handshakeTimeout)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return n.setupPeer(conn, false)
+}
+
+func (n *Node) setupPeer(conn net.Conn, inbound bool) (*Peer, error) {
+	peer := newPeer(n, conn, inbound)
+
+	if err := peer.handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	n.mu.Lock()
+
+	select {
+	case <-n.quit:
+		n.mu.Unlock()
+		conn.Close()
+		return nil, errors.New("node is stopping")
+	default:
+	}
+
+	if n.config.MaxPeers > 0 && len(n.peers) >= n.config.MaxPeers {
+		n.mu.Unlock()
+		WriteMessage(conn, &MsgDisconnect{Reason: "too many peers"})
+		conn.Close()
+		return nil, errors.New("too many peers")
+	}
+
+	n.peers[peer] = struct{}{}
+	n.mu.Unlock()
+
+	peer.start()
+	n.sync.peerConnected(peer)
+	n.peerConnected(peer)
+
+	return peer, nil
+}
+
+func (n *Node) removePeer(peer *Peer, reason error) {
+	n.mu.Lock()
+	delete(n.peers, peer)
+	n.mu.Unlock()
+
+	n.sync.peerDisconnected(peer)
+}
+
+// Adds to the misbehavior score of the peer's host, banning it and disconnecting the peer once the score
+// reaches the threshold
+func (n *Node) misbehaving(peer *Peer, points int, reason string) {
+	host := hostOf(peer.Addr())
+	before, after := n.bans.addScore(host, points)
+
+	if after >= n.config.BanThreshold && before < n.config.BanThreshold {
+		n.Ban(host, n.config.BanDuration)
+		peer.Disconnect("banned for misbehaving: " + reason)
+	}
+}
+
+// Refuses connections from host for duration, and drops any peers already connected from it
+func (n *Node) Ban(host string, duration time.Duration) error {
+	err := n.bans.ban(host, time.Now().Add(duration))
+
+	for _, peer := range n.Peers() {
+		if hostOf(peer.Addr()) == host {
+			peer.Disconnect("banned")
+		}
+	}
+
+	return err
+}
+
+func (n *Node) Unban(host string) error {
+	return n.bans.unban(host)
+}
+
+func (n *Node) Banned(host string) bool {
+	return n.bans.banned(host)
+}
+
+func (n *Node) maxBlockSize() int {
+	return int(n.blockSizeLimit.Load())
+}
+
+func (n *Node) SyncProgress() SyncProgress {
+	return n.sync.progress()
+}
+
+func (n *Node) Peers() []*Peer {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	peers := make([]*Peer, 0, len(n.peers))
+
+	for peer := range n.peers {
+		peers = append(peers, peer)
+	}
+
+	return peers
+}
+
+// Says goodbye to every peer and stops listening
+func (n *Node) Stop() {
+	n.stopOnce.Do(func() {
+		n.mu.Lock()
+		close(n.quit)
+
+		if n.listener != nil {
+			n.listener.Close()
+		}
+
+		n.mu.Unlock()
+
+		for _, peer := range n.Peers() {
+			peer.Disconnect("node is shutting down")
+
+			select {
+			case <-peer.Done():
+			case <-time.After(time.Second):
+				peer.close(errors.New("timed out saying goodbye"))
+			}
+		}
+
+		n.wg.Wait()
+		n.addrs.Save()
+	})
+}
+
+// Sends msg to every peer except skip
+func (n *Node) broadcast(msg Message, skip *Peer) {
+	for _, peer := range n.Peers() {
+		if peer != skip {
+			peer.Send(msg)
+		}
+	}
+}
+
+func (n *Node) versionMessage() *MsgVersion {
+	_, height := n.chain.Tip()
+	listenPort := uint16(0)
+
+	if addr, ok := n.Addr().(*net.TCPAddr); ok {
+		listenPort = uint16(addr.Port)
+	}
+
+	return &MsgVersion{
+		Version:     ProtocolVersion,
+		ChainID:     n.config.Params.ChainID,
+		GenesisHash: n.config.Params.GenesisHash(),
+		Height:      uint32(height),
+		Nonce:       n.nonce,
+		ListenPort:  listenPort,
+	}
+}
+
+func (n *Node) checkVersion(version *MsgVersion) error {
+	if version.Version < MinProtocolVersion {
+		return fmt.Errorf("protocol version %d is too old", version.Version)
+	}
+
+	if version.ChainID != n.config.Params.ChainID {
+		return fmt.Errorf("chain ID %d does not match %d", version.ChainID, n.config.Params.ChainID)
+	}
+
+	if version.GenesisHash != n.config.Params.GenesisHash() {
+		return errors.New("genesis hash does not match")
+	}
+
+	if version.Nonce == n.nonce {
+		return errors.New("connected to self")
+	}
+
+	return nil
+}
+
+// Announces blocks as they join the main chain
+func (n *Node) BlockConnected(block *t.Block, undo *b.BlockUndo, state *t.State) {
+	n.blockSizeLimit.Store(int64(b.MaxBlockSize(state)))
+	n.broadcast(&MsgInv{Items: []InvItem{{Type: InvBlock, Hash: b.HashBlockHeader(block.Header)}}}, nil)
+}
+
+func (n *Node) BlockDisconnected(block *t.Block, undo *b.BlockUndo, state *t.State) {
+	n.blockSizeLimit.Store(int64(b.MaxBlockSize(state)))
+}
+
+func (n *Node) handleMessage(peer *Peer, msg Message) {
+	switch msg := msg.(type) {
+	case *MsgPing:
+		peer.Send(&MsgPong{Nonce: msg.Nonce})
+	case *MsgPong:
+		peer.handlePong(msg)
+	case *MsgInv:
+		n.handleInv(peer, msg)
+	case *MsgGetData:
+		n.handleGetData(peer, msg)
+	case *MsgGetHeaders:
+		peer.Send(&MsgHeaders{Headers: n.chain.HeadersAfter(msg.Locator, msg.Stop, MaxHeadersPerMessage)})
+	case *MsgHeaders:
+		if err := n.sync.handleHeaders(peer, msg); errors.Is(err, b.ErrInvalidHeader) || errors.Is(err, b.ErrInvalidBlock) {
+			n.misbehaving(peer, penaltyInvalidHeader, err.Error())
+		}
+	case *MsgBlock:
+		n.processBlock(peer, msg.Block)
+	case *MsgCompactBlock:
+		n.handleCompactBlock(peer, msg)
+	case *MsgGetBlockOps:
+		n.handleGetBlockOps(peer, msg)
+	case *MsgBlockOps:
+		n.handleBlockOps(peer, msg)
+	case *MsgNotFound:
+		n.sync.handleNotFound(peer, msg)
+	case *MsgOp:
+		n.handleOp(peer, msg)
+	case *MsgGetAddr:
+		peer.Send(&MsgAddr{Addresses: n.addrs.Addresses(MaxAddrPerMessage)})
+	case *MsgAddr:
+		n.handleAddr(peer, msg)
+	}
+}
+
+func (n *Node) processBlock(peer *Peer, block *t.Block) {
+	if err := n.sync.handleBlock(peer, &MsgBlock{Block: block}); blockIsInvalid(err) {
+		n.misbehaving(peer, penaltyInvalidBlock, err.Error())
+	}
+}
+
+func blockIsInvalid(err error) bool {
+	return errors.Is(err, b.ErrInvalidBlock) || errors.Is(err, b.ErrInvalidHeader) || errors.Is(err, b.ErrMerkleMismatch)
+}
+
+// Ops past the peer's rate limit are dropped. Only a bad signature or set of cosignatures counts against
+// the peer, the rest may just have gone stale on the way.
+func (n *Node) handleOp(peer *Peer, msg *MsgOp) {
+	if n.config.OpRateLimit > 0 && !peer.opBucket.take() {
+		return
+	}
+
+	if err := n.pool.Add(msg.Op); opIsBadlySigned(err) {
+		n.misbehaving(peer, penaltyBadSignature, err.Error())
+	}
+}
+
+func opIsBadlySigned(err error) bool {
+	for _, sigErr := range []error{b.ErrTxnSig, b.ErrRenameSig, b.ErrRegisterPolicySig, b.ErrInvalidCosignature, b.ErrThresholdNotMet} {
+		if errors.Is(err, sigErr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Asks for whatever was announced that isn't known yet. New blocks are asked for as compact blocks once the
+// node has caught up, since by then its mempool most likely has their ops.
+func (n *Node) handleInv(peer *Peer, inv *MsgInv) {
+	wanted := make([]InvItem, 0)
+	blockType := InvBlock
+
+	if n.sync.progress().Synced() {
+		blockType = InvCompactBlock
+	}
+
+	for _, item := range inv.Items {
+		if item.Type == InvBlock && !n.chain.HasBlock(item.Hash) {
+			wanted = append(wanted, InvItem{Type: blockType, Hash: item.Hash})
+		} else if item.Type == InvOp && !n.pool.Has(item.Hash) {
+			wanted = append(wanted, item)
+		}
+	}
+
+	if len(wanted) > 0 {
+		peer.Send(&MsgGetData{Items: wanted})
+	}
+}
+
+func (n *Node) handleGetData(peer *Peer, getData *MsgGetData) {
+	notFound := make([]InvItem, 0)
+
+	for _, item := range getData.Items {
+		switch item.Type {
+		case InvBlock:
+			if block, exists := n.chain.GetBlock(item.Hash); exists {
+				peer.Send(&MsgBlock{Block: block})
+				continue
+			}
+		case InvCompactBlock:
+			if block, exists := n.chain.GetBlock(item.Hash); exists {
+				n.sendCompactBlock(peer, block)
+				continue
+			}
+		case InvOp:
+			if op := n.pool.Get(item.Hash); op != nil {
+				peer.Send(&MsgOp{Op: op})
+				continue
+			}
+		}
+
+		notFound = append(notFound, item)
+	}
+
+	if len(notFound) > 0 {
+		peer.Send(&MsgNotFound{Items: notFound})
+	}
+}
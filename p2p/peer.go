@@ -0,0 +1,255 @@
+package p2p
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	handshakeTimeout = 10 * time.Second
+	// How many messages can wait to be sent before the peer is considered too slow and dropped
+	sendQueueSize = 1024
+)
+
+var errSendQueueFull = errors.New("send queue is full")
+
+type Peer struct {
+	node    *Node
+	conn    net.Conn
+	inbound bool
+	// What the peer sent in its handshake
+	version MsgVersion
+
+	send      chan Message
+	quit      chan struct{}
+	closeOnce sync.Once
+	// Nonce of the last ping sent and not yet answered, 0 if none is outstanding
+	pendingPing atomic.Uint64
+	// Best height the peer is known to have, from its version and the headers and blocks it sent since
+	height atomic.Int64
+	// Limits how many ops the peer can relay to us
+	opBucket tokenBucket
+}
+
+func newPeer(node *Node, conn net.Conn, inbound bool) *Peer {
+	return &Peer{
+		node:     node,
+		conn:     conn,
+		inbound:  inbound,
+		send:     make(chan Message, sendQueueSize),
+		quit:     make(chan struct{}),
+		opBucket: newTokenBucket(node.config.OpRateLimit, node.config.OpRateBurst),
+	}
+}
+
+func (p *Peer) Addr() string {
+	return p.conn.RemoteAddr().String()
+}
+
+func (p *Peer) Inbound() bool {
+	return p.inbound
+}
+
+// Where the peer accepts connections, or "" if it doesn't
+func (p *Peer) ListenAddr() string {
+	if p.version.ListenPort == 0 {
+		return ""
+	}
+
+	return net.JoinHostPort(hostOf(p.Addr()), strconv.Itoa(int(p.version.ListenPort)))
+}
+
+// The version message the peer sent during the handshake
+func (p *Peer) Version() MsgVersion {
+	return p.version
+}
+
+// Misbehavior points of the peer's host, see Node.misbehaving
+func (p *Peer) Score() int {
+	return p.node.bans.score(hostOf(p.Addr()))
+}
+
+func (p *Peer) Height() int {
+	return int(p.height.Load())
+}
+
+func (p *Peer) updateHeight(height int) {
+	for {
+		current := p.height.Load()
+
+		if int64(height) <= current || p.height.CompareAndSwap(current, int64(height)) {
+			return
+		}
+	}
+}
+
+// Closed once the peer has disconnected
+func (p *Peer) Done() <-chan struct{} {
+	return p.quit
+}
+
+// Queues a message without blocking. If the peer isn't keeping up it gets dropped.
+func (p *Peer) Send(msg Message) {
+	select {
+	case p.send <- msg:
+	case <-p.quit:
+	default:
+		p.close(errSendQueueFull)
+	}
+}
+
+// Tells the peer why, then hangs up once everything queued before has been sent
+func (p *Peer) Disconnect(reason string) {
+	p.Send(&MsgDisconnect{Reason: reason})
+}
+
+func (p *Peer) close(reason error) {
+	p.closeOnce.Do(func() {
+		close(p.quit)
+		p.conn.Close()
+		p.node.removePeer(p, reason)
+	})
+}
+
+// Both sides send their version straight away, then a verack once they've accepted the other's
+func (p *Peer) handshake() error {
+	p.conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer p.conn.SetDeadline(time.Time{})
+
+	if err := WriteMessage(p.conn, p.node.versionMessage()); err != nil {
+		return err
+	}
+
+	msg, err := ReadMessage(p.conn, p.node.maxBlockSize())
+
+	if err != nil {
+		return err
+	}
+
+	version, ok := msg.(*MsgVersion)
+
+	if !ok {
+		return errors.New("expected a version message")
+	}
+
+	if err := p.node.checkVersion(version); err != nil {
+		WriteMessage(p.conn, &MsgDisconnect{Reason: err.Error()})
+		return err
+	}
+
+	p.version = *version
+	p.height.Store(int64(version.Height))
+
+	if err := WriteMessage(p.conn, &MsgVerAck{}); err != nil {
+		return err
+	}
+
+	msg, err = ReadMessage(p.conn, p.node.maxBlockSize())
+
+	if err != nil {
+		return err
+	}
+
+	if disconnect, ok := msg.(*MsgDisconnect); ok {
+		return fmt.Errorf("peer refused the handshake: %s", disconnect.Reason)
+	}
+
+	if _, ok := msg.(*MsgVerAck); !ok {
+		return errors.New("expected a verack message")
+	}
+
+	return nil
+}
+
+func (p *Peer) start() {
+	go p.readLoop()
+	go p.writeLoop()
+	go p.pingLoop()
+}
+
+func (p *Peer) readLoop() {
+	reader := bufio.NewReader(p.conn)
+
+	for {
+		msg, err := ReadMessage(reader, p.node.maxBlockSize())
+
+		if errors.Is(err, ErrMalformedMessage) {
+			p.node.misbehaving(p, penaltyMalformedMessage, err.Error())
+		}
+
+		if err != nil {
+			p.close(err)
+			return
+		}
+
+		if disconnect, ok := msg.(*MsgDisconnect); ok {
+			p.close(fmt.Errorf("peer disconnected: %s", disconnect.Reason))
+			return
+		}
+
+		p.node.handleMessage(p, msg)
+	}
+}
+
+func (p *Peer) writeLoop() {
+	writer := bufio.NewWriter(p.conn)
+
+	for {
+		select {
+		case msg := <-p.send:
+			if err := WriteMessage(writer, msg); err != nil {
+				p.close(err)
+				return
+			}
+
+			// Only flush once the queue is empty, so bursts of messages share writes
+			if len(p.send) == 0 {
+				if err := writer.Flush(); err != nil {
+					p.close(err)
+					return
+				}
+			}
+
+			if disconnect, ok := msg.(*MsgDisconnect); ok {
+				writer.Flush()
+				p.close(fmt.Errorf("disconnected: %s", disconnect.Reason))
+				return
+			}
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// Pings every PingInterval. A peer that hasn't answered the last ping by the next one is dropped.
+func (p *Peer) pingLoop() {
+	ticker := time.NewTicker(p.node.config.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if p.pendingPing.Load() != 0 {
+				p.close(errors.New("ping timed out"))
+				return
+			}
+
+			nonce := rand.Uint64() | 1
+			p.pendingPing.Store(nonce)
+			p.Send(&MsgPing{Nonce: nonce})
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+func (p *Peer) handlePong(pong *MsgPong) {
+	p.pendingPing.CompareAndSwap(pong.Nonce, 0)
+}
@@ -0,0 +1,239 @@
+package p2p
+
+import (
+	"errors"
+	b "gold/blockchain"
+	"sync"
+	"time"
+)
+
+const (
+	// Most blocks asked of one peer at a time
+	maxBlocksInFlightPerPeer = 16
+	syncTickInterval         = 500 * time.Millisecond
+)
+
+// How far along the node is in catching up with the best header chain it knows of
+type SyncProgress struct {
+	HeaderHeight   int
+	BlockHeight    int
+	BlocksInFlight int
+	Peers          int
+}
+
+func (p SyncProgress) Synced() bool {
+	return p.BlockHeight >= p.HeaderHeight
+}
+
+type blockRequest struct {
+	peer *Peer
+	sent time.Time
+}
+
+// Syncs headers first. Peers are asked for headers from the best header onwards until they run out, then the
+// blocks under those headers are requested from every peer that has them at once. The chain connects blocks in
+// order as the gaps fill in, and since headers and blocks are stored as they arrive, a restarted node carries on
+// from where it was.
+type syncManager struct {
+	node *Node
+
+	mu       sync.Mutex
+	inFlight map[[32]byte]blockRequest
+}
+
+func newSyncManager(node *Node) *syncManager {
+	return &syncManager{
+		node:     node,
+		inFlight: make(map[[32]byte]blockRequest),
+	}
+}
+
+func (s *syncManager) run() {
+	ticker := time.NewTicker(syncTickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.expireRequests()
+			s.fillRequests()
+			s.reportProgress()
+		case <-s.node.quit:
+			return
+		}
+	}
+}
+
+func (s *syncManager) peerConnected(peer *Peer) {
+	if _, height := s.node.chain.BestHeader(); peer.Height() > height {
+		peer.Send(&MsgGetHeaders{Locator: s.node.chain.HeaderLocator()})
+	}
+
+	s.fillRequests()
+}
+
+// Whatever the peer had in flight gets asked of someone else
+func (s *syncManager) peerDisconnected(peer *Peer) {
+	s.mu.Lock()
+
+	for hash, request := range s.inFlight {
+		if request.peer == peer {
+			delete(s.inFlight, hash)
+		}
+	}
+
+	s.mu.Unlock()
+
+	s.fillRequests()
+}
+
+// Adds the headers in order. A full message means the peer has more, so the next batch is asked for.
+// Returns the first header that doesn't fit onto the chain, if any.
+func (s *syncManager) handleHeaders(peer *Peer, headers *MsgHeaders) error {
+	chain := s.node.chain
+
+	for _, header := range headers.Headers {
+		if err := chain.ProcessHeader(header); err != nil && err != b.ErrDuplicateBlock {
+			return err
+		}
+
+		if height, exists := chain.HeaderHeight(b.HashBlockHeader(header)); exists {
+			peer.updateHeight(height)
+		}
+	}
+
+	if len(headers.Headers) == MaxHeadersPerMessage {
+		peer.Send(&MsgGetHeaders{Locator: chain.HeaderLocator()})
+	}
+
+	s.fillRequests()
+	s.reportProgress()
+
+	return nil
+}
+
+func (s *syncManager) handleBlock(peer *Peer, block *MsgBlock) error {
+	hash := b.HashBlockHeader(block.Block.Header)
+
+	s.mu.Lock()
+	delete(s.inFlight, hash)
+	s.mu.Unlock()
+
+	err := s.node.chain.ProcessBlock(block.Block)
+
+	// A block we can't place means we're missing headers before it
+	if errors.Is(err, b.ErrOrphanBlock) {
+		peer.Send(&MsgGetHeaders{Locator: s.node.chain.HeaderLocator()})
+	} else if height, exists := s.node.chain.HeaderHeight(hash); exists {
+		peer.updateHeight(height)
+	}
+
+	s.fillRequests()
+	s.reportProgress()
+
+	if err == b.ErrDuplicateBlock {
+		return nil
+	}
+
+	return err
+}
+
+func (s *syncManager) handleNotFound(peer *Peer, notFound *MsgNotFound) {
+	s.mu.Lock()
+
+	for _, item := range notFound.Items {
+		if request, exists := s.inFlight[item.Hash]; exists && request.peer == peer {
+			delete(s.inFlight, item.Hash)
+		}
+	}
+
+	s.mu.Unlock()
+}
+
+func (s *syncManager) expireRequests() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash, request := range s.inFlight {
+		if time.Since(request.sent) > s.node.config.BlockRequestTimeout {
+			delete(s.inFlight, hash)
+		}
+	}
+}
+
+// Spreads requests for missing blocks over the peers that are ahead of the tip, lowest blocks first,
+// keeping up to maxBlocksInFlightPerPeer with each
+func (s *syncManager) fillRequests() {
+	chain := s.node.chain
+	_, tipHeight := chain.Tip()
+	peers := make([]*Peer, 0)
+
+	for _, peer := range s.node.Peers() {
+		if peer.Height() > tipHeight {
+			peers = append(peers, peer)
+		}
+	}
+
+	if len(peers) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts := make(map[*Peer]int)
+
+	for _, request := range s.inFlight {
+		counts[request.peer] += 1
+	}
+
+	requests := make(map[*Peer][]InvItem)
+
+	for _, hash := range chain.MissingBlocks(len(s.inFlight) + len(peers)*maxBlocksInFlightPerPeer) {
+		if _, exists := s.inFlight[hash]; exists {
+			continue
+		}
+
+		var chosen *Peer
+
+		for _, peer := range peers {
+			if counts[peer] < maxBlocksInFlightPerPeer && (chosen == nil || counts[peer] < counts[chosen]) {
+				chosen = peer
+			}
+		}
+
+		if chosen == nil {
+			break
+		}
+
+		counts[chosen] += 1
+		s.inFlight[hash] = blockRequest{peer: chosen, sent: time.Now()}
+		requests[chosen] = append(requests[chosen], InvItem{Type: InvBlock, Hash: hash})
+	}
+
+	for peer, items := range requests {
+		peer.Send(&MsgGetData{Items: items})
+	}
+}
+
+func (s *syncManager) progress() SyncProgress {
+	s.mu.Lock()
+	inFlight := len(s.inFlight)
+	s.mu.Unlock()
+
+	_, headerHeight := s.node.chain.BestHeader()
+	_, blockHeight := s.node.chain.Tip()
+
+	return SyncProgress{
+		HeaderHeight:   headerHeight,
+		BlockHeight:    blockHeight,
+		BlocksInFlight: inFlight,
+		Peers:          len(s.node.Peers()),
+	}
+}
+
+func (s *syncManager) reportProgress() {
+	if s.node.config.OnProgress != nil {
+		s.node.config.OnProgress(s.progress())
+	}
+}
@@ -0,0 +1,83 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// Calls a Server over HTTP, reading the password from its cookie file
+type Client struct {
+	url      string
+	password string
+	nextID   atomic.Uint64
+}
+
+func NewClient(addr string, cookieFile string) (*Client, error) {
+	cookie, err := os.ReadFile(cookieFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	password, found := strings.CutPrefix(strings.TrimSpace(string(cookie)), CookieUser+":")
+
+	if !found {
+		return nil, fmt.Errorf("%s is not a cookie file", cookieFile)
+	}
+
+	return &Client{url: "http://" + addr, password: password}, nil
+}
+
+// Calls method with params, which can be a slice for positional params or a map for named ones, and decodes
+// the result into result. Errors from the server are returned as *Error.
+func (c *Client) Call(method string, params any, result any) error {
+	body, err := json.Marshal(map[string]any{"jsonrpc": "2.0", "method": method, "params": params, "id": c.nextID.Add(1)})
+
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(body))
+
+	if err != nil {
+		return err
+	}
+
+	req.SetBasicAuth(CookieUser, c.password)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("rpc server replied %s", resp.Status)
+	}
+
+	var reply struct {
+		Result json.RawMessage `json:"result"`
+		Error  *Error          `json:"error"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return err
+	}
+
+	if reply.Error != nil {
+		return reply.Error
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	return json.Unmarshal(reply.Result, result)
+}
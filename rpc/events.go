@@ -0,0 +1,309 @@
+package rpc
+
+import (
+	"encoding/json"
+	b "gold/blockchain"
+	"gold/mempool"
+	t "gold/types"
+	"slices"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+// Topics that can be subscribed to over the websocket
+const (
+	// Every block that joins or leaves the main chain. A reorg is the disconnects down to the fork followed
+	// by the connects up to the new tip.
+	TopicBlocks = "blocks"
+	// Ops touching the address in the filter, as they enter the mempool, get confirmed and get unconfirmed
+	TopicOps = "ops"
+	// Every op the mempool admits
+	TopicMempool = "mempool"
+	// Name ownership changes, for every name or only the one in the filter
+	TopicNames = "names"
+)
+
+type TipEvent struct {
+	// "connected" or "disconnected"
+	Type  string `json:"type"`
+	Block Block  `json:"block"`
+	// The main chain tip once the block was connected or disconnected
+	Tip       string `json:"tip"`
+	TipHeight int    `json:"tipHeight"`
+	// What the block's renames did. For a disconnected block these have just been undone.
+	NameChanges []NameChange `json:"nameChanges"`
+}
+
+type NameChange struct {
+	Name string `json:"name"`
+	// The owner before the rename, "" if the name wasn't registered
+	From string `json:"from"`
+	To   string `json:"to"`
+	// True when the block was disconnected, so the name is back with From
+	Undone    bool   `json:"undone"`
+	BlockHash string `json:"blockHash"`
+	Height    int    `json:"height"`
+}
+
+type OpEvent struct {
+	// "pending" when admitted to the mempool, "confirmed" when its block is connected and "unconfirmed"
+	// when its block is disconnected
+	Status    string `json:"status"`
+	Op        Op     `json:"op"`
+	BlockHash string `json:"blockHash,omitempty"`
+	Height    int    `json:"height,omitempty"`
+}
+
+type MempoolEvent struct {
+	Op Op `json:"op"`
+	// Hash of the op this one replaced, if it was a replacement
+	Replaced string `json:"replaced,omitempty"`
+}
+
+type subscription struct {
+	id     uint64
+	topic  string
+	filter string
+	// Parsed from filter for TopicOps
+	addr t.Address
+}
+
+type notification struct {
+	JSONRPC string             `json:"jsonrpc"`
+	Method  string             `json:"method"`
+	Params  notificationParams `json:"params"`
+}
+
+type notificationParams struct {
+	Subscription uint64 `json:"subscription"`
+	Result       any    `json:"result"`
+}
+
+// The names and keys an op touches, so ops subscriptions can match either
+type touched struct {
+	names []string
+	keys  []*secp256k1.PublicKey
+}
+
+func (tc *touched) addAddress(addr *t.Address, owners map[string]*secp256k1.PublicKey, state *t.State) {
+	key := b.AddressToPk(addr, &state.KeyNameSet)
+
+	if addr.UsesName {
+		tc.names = append(tc.names, *addr.Name)
+
+		if owner, ok := owners[*addr.Name]; ok {
+			key = owner
+		}
+	}
+
+	if key != nil {
+		tc.keys = append(tc.keys, key)
+	}
+}
+
+// Names are resolved with owners, the owners as of the op in its block, or against state for ops that
+// aren't in one. A rename touches its old owner too, which comes from undo if the op has been applied,
+// otherwise from state.
+func touchedBy(op t.Op, undo t.UndoOp, owners map[string]*secp256k1.PublicKey, state *t.State) touched {
+	var tc touched
+
+	switch op := op.(type) {
+	case *b.Txn:
+		for _, addr := range op.Addresses() {
+			tc.addAddress(&addr, owners, state)
+		}
+	case *b.Rename:
+		tc.names = append(tc.names, op.Name)
+		tc.keys = append(tc.keys, op.NewKey)
+
+		if renameUndo, ok := undo.(*b.RenameUndo); ok && renameUndo.OldOwner != nil {
+			tc.keys = append(tc.keys, renameUndo.OldOwner)
+		} else if owner := state.KeyNameSet[op.Name]; owner != nil {
+			tc.keys = append(tc.keys, owner)
+		}
+	case *b.RegisterPolicy:
+		tc.keys = append(tc.keys, op.Funder, b.PolicyKey(&op.Policy))
+	}
+
+	return tc
+}
+
+func (tc *touched) matches(addr *t.Address) bool {
+	if addr.UsesName {
+		return slices.Contains(tc.names, *addr.Name)
+	}
+
+	return slices.ContainsFunc(tc.keys, addr.Key.IsEqual)
+}
+
+func (s *Server) BlockConnected(block *t.Block, undo *b.BlockUndo, state *t.State) {
+	hash := b.HashBlockHeader(block.Header)
+	s.publishBlock("connected", hash, state.Height, block, undo, state, hash, state.Height)
+}
+
+func (s *Server) BlockDisconnected(block *t.Block, undo *b.BlockUndo, state *t.State) {
+	hash := b.HashBlockHeader(block.Header)
+	s.publishBlock("disconnected", hash, state.Height+1, block, undo, state, block.Header.PrevBlockHash, state.Height)
+}
+
+func (s *Server) publishBlock(kind string, hash [32]byte, height int, block *t.Block, undo *b.BlockUndo, state *t.State, tip [32]byte, tipHeight int) {
+	if !s.hasSubscribers() {
+		return
+	}
+
+	event := &TipEvent{
+		Type:        kind,
+		Block:       BlockJSON(s.chain.Params(), hash, height, block),
+		Tip:         formatHash(tip),
+		TipHeight:   tipHeight,
+		NameChanges: make([]NameChange, 0),
+	}
+
+	opUndos := make([]t.UndoOp, len(block.Operations))
+
+	if undo != nil {
+		copy(opUndos, undo.Undos)
+	}
+
+	for i, op := range block.Operations {
+		rename, ok := op.(*b.Rename)
+
+		if !ok {
+			continue
+		}
+
+		change := NameChange{Name: rename.Name, To: keyAddress(s.chain.Params(), rename.NewKey), Undone: kind == "disconnected", BlockHash: event.Block.Hash, Height: height}
+
+		if renameUndo, ok := opUndos[i].(*b.RenameUndo); ok && renameUndo.OldOwner != nil {
+			change.From = keyAddress(s.chain.Params(), renameUndo.OldOwner)
+		}
+
+		event.NameChanges = append(event.NameChanges, change)
+	}
+
+	status := "confirmed"
+
+	if kind == "disconnected" {
+		status = "unconfirmed"
+	}
+
+	touches := make([]touched, len(block.Operations))
+	owners := b.NameOwnersBeforeOps(block, undo, state)
+
+	for i, op := range block.Operations {
+		touches[i] = touchedBy(op, opUndos[i], owners[i], state)
+	}
+
+	s.publish(func(sub *subscription) []any {
+		switch sub.topic {
+		case TopicBlocks:
+			return []any{event}
+		case TopicNames:
+			changes := make([]any, 0)
+
+			for _, change := range event.NameChanges {
+				if sub.filter == "" || sub.filter == change.Name {
+					changes = append(changes, change)
+				}
+			}
+
+			return changes
+		case TopicOps:
+			events := make([]any, 0)
+
+			for i := range block.Operations {
+				if touches[i].matches(&sub.addr) {
+					events = append(events, &OpEvent{Status: status, Op: event.Block.Ops[i], BlockHash: event.Block.Hash, Height: height})
+				}
+			}
+
+			return events
+		}
+
+		return nil
+	})
+}
+
+// Passes mempool admissions on until the server stops
+func (s *Server) watchPool(events <-chan mempool.Event) {
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+
+			s.publishPoolEvent(event)
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+func (s *Server) publishPoolEvent(event mempool.Event) {
+	if !s.hasSubscribers() {
+		return
+	}
+
+	opJSON := OpJSON(s.chain.Params(), event.Op)
+	admitted := &MempoolEvent{Op: opJSON}
+
+	if event.Replaced != nil {
+		admitted.Replaced = formatHash(b.OpHash(event.Replaced))
+	}
+
+	var tc touched
+
+	s.chain.ReadState(func(state *t.State, tip [32]byte) {
+		tc = touchedBy(event.Op, nil, nil, state)
+	})
+
+	s.publish(func(sub *subscription) []any {
+		switch sub.topic {
+		case TopicMempool:
+			return []any{admitted}
+		case TopicOps:
+			if tc.matches(&sub.addr) {
+				return []any{&OpEvent{Status: "pending", Op: opJSON}}
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *Server) hasSubscribers() bool {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+
+	return len(s.clients) > 0
+}
+
+// Sends each subscription whatever results it wants. Clients too slow to keep up are dropped rather
+// than holding up the chain.
+func (s *Server) publish(results func(sub *subscription) []any) {
+	s.clientsMu.Lock()
+	clients := make([]*wsClient, 0, len(s.clients))
+
+	for client := range s.clients {
+		clients = append(clients, client)
+	}
+
+	s.clientsMu.Unlock()
+
+	for _, client := range clients {
+		for _, sub := range client.subscriptions() {
+			for _, result := range results(sub) {
+				data, err := json.Marshal(&notification{
+					JSONRPC: "2.0",
+					Method:  "subscription",
+					Params:  notificationParams{Subscription: sub.id, Result: result},
+				})
+
+				if err == nil {
+					client.queue(data)
+				}
+			}
+		}
+	}
+}
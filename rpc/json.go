@@ -0,0 +1,136 @@
+package rpc
+
+import (
+	"encoding/hex"
+	b "gold/blockchain"
+	t "gold/types"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+type Header struct {
+	Hash string `json:"hash"`
+	// -1 if the header isn't on the main chain
+	Height        int    `json:"height"`
+	PrevBlockHash string `json:"prevBlockHash"`
+	MerkleRoot    string `json:"merkleRoot"`
+	Timestamp     uint32 `json:"timestamp"`
+	Nonce         uint64 `json:"nonce"`
+}
+
+type Block struct {
+	Header
+	Size int  `json:"size"`
+	Ops  []Op `json:"ops"`
+}
+
+type Payment struct {
+	Reciever string `json:"reciever"`
+	Amount   uint64 `json:"amount"`
+}
+
+// An m-of-n policy and the address of the account it controls
+type Policy struct {
+	Address   string   `json:"address"`
+	Threshold uint8    `json:"threshold"`
+	Keys      []string `json:"keys"`
+}
+
+// A txn, rename or policy registration. Only the fields for its type are set.
+type Op struct {
+	Type  string `json:"type"`
+	Hash  string `json:"hash"`
+	Hex   string `json:"hex"`
+	Fee   uint64 `json:"fee"`
+	Nonce uint32 `json:"nonce"`
+
+	Sender   string    `json:"sender,omitempty"`
+	Payments []Payment `json:"payments,omitempty"`
+
+	Name   string `json:"name,omitempty"`
+	NewKey string `json:"newKey,omitempty"`
+
+	Policy *Policy `json:"policy,omitempty"`
+	Funder string  `json:"funder,omitempty"`
+
+	// How many of the policy's keys signed, when the liable key is a policy
+	Cosignatures int `json:"cosignatures,omitempty"`
+}
+
+// The height of a block on the main chain, or -1 if it isn't on it
+func (s *Server) mainHeight(hash [32]byte) int {
+	if height, onMain := s.chain.HeightOf(hash); onMain {
+		return height
+	}
+
+	return -1
+}
+
+func HeaderJSON(hash [32]byte, height int, header t.Header) Header {
+	return Header{
+		Hash:          formatHash(hash),
+		Height:        height,
+		PrevBlockHash: formatHash(header.PrevBlockHash),
+		MerkleRoot:    formatHash(header.MerkleRoot),
+		Timestamp:     header.Timestamp,
+		Nonce:         header.Nonce,
+	}
+}
+
+func BlockJSON(params *b.Params, hash [32]byte, height int, block *t.Block) Block {
+	result := Block{Header: HeaderJSON(hash, height, block.Header), Size: b.BlockSize(block), Ops: make([]Op, 0, len(block.Operations))}
+
+	for _, op := range block.Operations {
+		result.Ops = append(result.Ops, OpJSON(params, op))
+	}
+
+	return result
+}
+
+// Addresses are written with the network params' prefix
+func OpJSON(params *b.Params, op t.Op) Op {
+	result := Op{
+		Hash:  formatHash(b.OpHash(op)),
+		Hex:   hex.EncodeToString(op.Encode()),
+		Fee:   op.GetFee(),
+		Nonce: op.GetNonce(),
+	}
+
+	switch op := op.(type) {
+	case *b.Txn:
+		result.Type = "txn"
+		result.Sender = params.FormatAddress(op.Sender)
+
+		for _, payment := range op.Payments {
+			result.Payments = append(result.Payments, Payment{Reciever: params.FormatAddress(payment.Reciever), Amount: payment.Amount})
+		}
+
+		result.Cosignatures = len(op.Cosignatures)
+	case *b.Rename:
+		result.Type = "rename"
+		result.Name = op.Name
+		result.NewKey = keyAddress(params, op.NewKey)
+		result.Cosignatures = len(op.Cosignatures)
+	case *b.RegisterPolicy:
+		result.Type = "registerPolicy"
+		result.Policy = PolicyJSON(params, &op.Policy)
+		result.Funder = keyAddress(params, op.Funder)
+		result.Cosignatures = len(op.Cosignatures)
+	}
+
+	return result
+}
+
+func PolicyJSON(params *b.Params, policy *t.Policy) *Policy {
+	result := &Policy{Address: keyAddress(params, b.PolicyKey(policy)), Threshold: policy.Threshold, Keys: make([]string, len(policy.Keys))}
+
+	for i, key := range policy.Keys {
+		result.Keys[i] = keyAddress(params, key)
+	}
+
+	return result
+}
+
+func keyAddress(params *b.Params, key *secp256k1.PublicKey) string {
+	return params.FormatAddress(b.AddrFromKey(key))
+}
@@ -0,0 +1,369 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	b "gold/blockchain"
+	"gold/fees"
+	t "gold/types"
+	"strings"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+type method struct {
+	// Names of the params in positional order
+	params  []string
+	handler func(s *Server, args []json.RawMessage) (any, error)
+}
+
+var methods map[string]method
+
+func init() {
+	methods = map[string]method{
+		"getBalance":   {[]string{"address"}, (*Server).getBalance},
+		"getNonce":     {[]string{"address"}, (*Server).getNonce},
+		"resolveName":  {[]string{"name"}, (*Server).resolveName},
+		"getPolicy":    {[]string{"address"}, (*Server).getPolicy},
+		"getBlock":     {[]string{"block"}, (*Server).getBlock},
+		"getHeader":    {[]string{"block"}, (*Server).getHeader},
+		"submitOp":     {[]string{"hex"}, (*Server).submitOp},
+		"getMempool":   {nil, (*Server).getMempool},
+		"getChainInfo": {nil, (*Server).getChainInfo},
+		"estimateFee":  {[]string{"targetBlocks"}, (*Server).estimateFee},
+	}
+}
+
+type Balance struct {
+	Address string `json:"address"`
+	Key     string `json:"key"`
+	Balance uint64 `json:"balance"`
+}
+
+type Nonce struct {
+	Address string `json:"address"`
+	Key     string `json:"key"`
+	// Nonce of the account at the tip
+	Confirmed uint32 `json:"confirmed"`
+	// Nonce the next op should use, counting the ones already in the mempool
+	Next uint32 `json:"next"`
+}
+
+type ChainInfo struct {
+	Network         string `json:"network"`
+	ChainID         uint32 `json:"chainId"`
+	Tip             string `json:"tip"`
+	Height          int    `json:"height"`
+	BestHeader      string `json:"bestHeader"`
+	HeaderHeight    int    `json:"headerHeight"`
+	MedianTimePast  uint64 `json:"medianTimePast"`
+	MedianBlockSize int    `json:"medianBlockSize"`
+	MaxBlockSize    int    `json:"maxBlockSize"`
+	MempoolOps      int    `json:"mempoolOps"`
+	MempoolBytes    int    `json:"mempoolBytes"`
+	// Lookups in the cache of verified signatures the mempool and block validation share
+	SigCache SigCacheInfo `json:"sigCache"`
+}
+
+type SigCacheInfo struct {
+	Hits    int     `json:"hits"`
+	Misses  int     `json:"misses"`
+	HitRate float64 `json:"hitRate"`
+	Entries int     `json:"entries"`
+	Size    int     `json:"size"`
+}
+
+type FeeEstimate struct {
+	// Fee per byte of the op's encoding
+	FeeRate      uint64 `json:"feeRate"`
+	TargetBlocks int    `json:"targetBlocks"`
+}
+
+type Mempool struct {
+	Count int `json:"count"`
+	Bytes int `json:"bytes"`
+	// Op hashes, highest fee rate first
+	Ops []string `json:"ops"`
+}
+
+// Balances of keys that have never been paid are 0. Names that aren't registered are an error.
+func (s *Server) getBalance(args []json.RawMessage) (any, error) {
+	addr, err := s.addressParam(args[0])
+
+	if err != nil {
+		return nil, err
+	}
+
+	var result *Balance
+
+	s.chain.ReadState(func(state *t.State, tip [32]byte) {
+		key := b.AddressToPk(&addr, &state.KeyNameSet)
+
+		if key == nil {
+			return
+		}
+
+		result = &Balance{Address: s.chain.Params().FormatAddress(addr), Key: keyAddress(s.chain.Params(), key)}
+
+		if account, exists := state.AccountSet[*key]; exists {
+			result.Balance = account.Balance
+		}
+	})
+
+	if result == nil {
+		return nil, &Error{CodeNotFound, "name is not registered"}
+	}
+
+	return result, nil
+}
+
+func (s *Server) getNonce(args []json.RawMessage) (any, error) {
+	addr, err := s.addressParam(args[0])
+
+	if err != nil {
+		return nil, err
+	}
+
+	var result *Nonce
+	var key *secp256k1.PublicKey
+
+	s.chain.ReadState(func(state *t.State, tip [32]byte) {
+		key = b.AddressToPk(&addr, &state.KeyNameSet)
+
+		if key == nil {
+			return
+		}
+
+		result = &Nonce{Address: s.chain.Params().FormatAddress(addr), Key: keyAddress(s.chain.Params(), key)}
+
+		if account, exists := state.AccountSet[*key]; exists {
+			result.Confirmed = account.Nonce
+		}
+	})
+
+	if result == nil {
+		return nil, &Error{CodeNotFound, "name is not registered"}
+	}
+
+	result.Next = result.Confirmed + uint32(len(s.pool.Pending(key)))
+
+	return result, nil
+}
+
+// The key address a name belongs to. The name can be given with or without its @.
+func (s *Server) resolveName(args []json.RawMessage) (any, error) {
+	var name string
+
+	if err := json.Unmarshal(args[0], &name); err != nil {
+		return nil, invalidParams("name must be a string")
+	}
+
+	name = strings.TrimPrefix(name, "@")
+
+	var key *secp256k1.PublicKey
+
+	s.chain.ReadState(func(state *t.State, tip [32]byte) {
+		key = state.KeyNameSet[name]
+	})
+
+	if key == nil {
+		return nil, &Error{CodeNotFound, "name is not registered"}
+	}
+
+	return keyAddress(s.chain.Params(), key), nil
+}
+
+// The policy controlling an address, which can be its policy key or a name the policy owns
+func (s *Server) getPolicy(args []json.RawMessage) (any, error) {
+	addr, err := s.addressParam(args[0])
+
+	if err != nil {
+		return nil, err
+	}
+
+	var result *Policy
+
+	s.chain.ReadState(func(state *t.State, tip [32]byte) {
+		if key := b.AddressToPk(&addr, &state.KeyNameSet); key != nil {
+			if policy, exists := state.PolicySet[*key]; exists {
+				result = PolicyJSON(s.chain.Params(), policy)
+			}
+		}
+	})
+
+	if result == nil {
+		return nil, &Error{CodeNotFound, "address is not controlled by a policy"}
+	}
+
+	return result, nil
+}
+
+func (s *Server) getBlock(args []json.RawMessage) (any, error) {
+	hash, err := s.blockParam(args[0])
+
+	if err != nil {
+		return nil, err
+	}
+
+	block, exists := s.chain.GetBlock(hash)
+
+	if !exists {
+		return nil, &Error{CodeNotFound, "block not found"}
+	}
+
+	return BlockJSON(s.chain.Params(), hash, s.mainHeight(hash), block), nil
+}
+
+func (s *Server) getHeader(args []json.RawMessage) (any, error) {
+	hash, err := s.blockParam(args[0])
+
+	if err != nil {
+		return nil, err
+	}
+
+	header, exists := s.chain.GetHeader(hash)
+
+	if !exists {
+		return nil, &Error{CodeNotFound, "header not found"}
+	}
+
+	return HeaderJSON(hash, s.mainHeight(hash), header), nil
+}
+
+// Adds the op to the mempool, which relays it to peers. Returns its hash.
+func (s *Server) submitOp(args []json.RawMessage) (any, error) {
+	var encoded string
+
+	if err := json.Unmarshal(args[0], &encoded); err != nil {
+		return nil, invalidParams("hex must be a string")
+	}
+
+	data, err := hex.DecodeString(encoded)
+
+	if err != nil {
+		return nil, invalidParams("op is not valid hex")
+	}
+
+	op, err := b.DecodeOp(data)
+
+	if err != nil {
+		return nil, invalidParams(fmt.Sprintf("op can't be decoded: %v", err))
+	}
+
+	if err := s.pool.Add(op); err != nil {
+		return nil, &Error{CodeOpRejected, err.Error()}
+	}
+
+	return formatHash(b.OpHash(op)), nil
+}
+
+func (s *Server) getMempool(args []json.RawMessage) (any, error) {
+	ops := s.pool.Ranked()
+	result := &Mempool{Count: len(ops), Bytes: s.pool.Bytes(), Ops: make([]string, 0, len(ops))}
+
+	for _, op := range ops {
+		result.Ops = append(result.Ops, formatHash(b.OpHash(op)))
+	}
+
+	return result, nil
+}
+
+func (s *Server) getChainInfo(args []json.RawMessage) (any, error) {
+	params := s.chain.Params()
+	info := &ChainInfo{Network: params.Name, ChainID: params.ChainID}
+
+	s.chain.ReadState(func(state *t.State, tip [32]byte) {
+		info.Tip = formatHash(tip)
+		info.Height = state.Height
+		info.MedianTimePast = b.MedianTimePast(state)
+		info.MedianBlockSize = b.MedianBlockSize(state)
+		info.MaxBlockSize = b.MaxBlockSize(state)
+	})
+
+	bestHeader, headerHeight := s.chain.BestHeader()
+	info.BestHeader = formatHash(bestHeader)
+	info.HeaderHeight = headerHeight
+	info.MempoolOps = s.pool.Count()
+	info.MempoolBytes = s.pool.Bytes()
+
+	cache := b.SigCache.Stats()
+	info.SigCache = SigCacheInfo{Hits: cache.Hits, Misses: cache.Misses, HitRate: cache.HitRate(), Entries: cache.Entries, Size: cache.Size}
+
+	return info, nil
+}
+
+// Fee rate to get an op mined within targetBlocks blocks. Targets past fees.MaxTargetBlocks are
+// clamped, and the result says which target was used.
+func (s *Server) estimateFee(args []json.RawMessage) (any, error) {
+	var target int
+
+	if err := json.Unmarshal(args[0], &target); err != nil || target < 1 {
+		return nil, invalidParams("targetBlocks must be a positive number")
+	}
+
+	target = min(target, fees.MaxTargetBlocks)
+	return &FeeEstimate{FeeRate: s.fees.EstimateFee(target), TargetBlocks: target}, nil
+}
+
+// A block is either a hash as a hex string or a height on the main chain as a number
+func (s *Server) blockParam(arg json.RawMessage) ([32]byte, error) {
+	var height int
+
+	if err := json.Unmarshal(arg, &height); err == nil {
+		hash, exists := s.chain.BlockHashAt(height)
+
+		if !exists {
+			return hash, &Error{CodeNotFound, "no block at that height"}
+		}
+
+		return hash, nil
+	}
+
+	var encoded string
+
+	if err := json.Unmarshal(arg, &encoded); err != nil {
+		return [32]byte{}, invalidParams("block must be a hash or a height")
+	}
+
+	hash, err := parseHash(encoded)
+
+	if err != nil {
+		return hash, invalidParams(err.Error())
+	}
+
+	return hash, nil
+}
+
+func (s *Server) addressParam(arg json.RawMessage) (t.Address, error) {
+	var encoded string
+
+	if err := json.Unmarshal(arg, &encoded); err != nil {
+		return t.Address{}, invalidParams("address must be a string")
+	}
+
+	addr, err := s.chain.Params().ParseAddress(encoded)
+
+	if err != nil {
+		return addr, invalidParams(err.Error())
+	}
+
+	return addr, nil
+}
+
+func parseHash(encoded string) ([32]byte, error) {
+	var hash [32]byte
+	data, err := hex.DecodeString(encoded)
+
+	if err != nil || len(data) != len(hash) {
+		return hash, errors.New("hash must be 32 bytes of hex")
+	}
+
+	copy(hash[:], data)
+	return hash, nil
+}
+
+func formatHash(hash [32]byte) string {
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,324 @@
+package rpc
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	b "gold/blockchain"
+	"gold/fees"
+	"gold/mempool"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Username that goes with the cookie password, the same as bitcoind uses
+	CookieUser = "__cookie__"
+	// Big enough for submitOp with an op the size of a whole block
+	maxRequestSize = 16 << 20
+)
+
+// JSON-RPC 2.0 error codes. The ones above -32000 are the spec's, the rest are ours.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+	CodeNotFound       = -32001
+	CodeOpRejected     = -32002
+)
+
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+type Config struct {
+	// Should stay on localhost, anyone who can read the cookie file can use it
+	ListenAddr string
+	// Where the password for this run is written. It's removed again by Stop.
+	CookieFile string
+}
+
+func DefaultConfig(dataDir string) Config {
+	return Config{
+		ListenAddr: "127.0.0.1:8332",
+		CookieFile: filepath.Join(dataDir, ".cookie"),
+	}
+}
+
+// Answers JSON-RPC 2.0 requests over HTTP POST, authenticated with HTTP basic auth using a random
+// password written to the cookie file
+type Server struct {
+	config Config
+	chain  *b.Chain
+	pool   *mempool.Pool
+	fees   *fees.Estimator
+	cookie string
+
+	listener net.Listener
+	http     *http.Server
+	quit     chan struct{}
+	// Stops the mempool events watchPool reads
+	unsubscribePool func()
+
+	clientsMu sync.Mutex
+	clients   map[*wsClient]struct{}
+	nextSub   atomic.Uint64
+}
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  any             `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+func NewServer(config Config, chain *b.Chain, pool *mempool.Pool) *Server {
+	s := &Server{config: config, chain: chain, pool: pool, fees: fees.NewEstimator(chain, pool), quit: make(chan struct{}), clients: make(map[*wsClient]struct{})}
+	chain.AddListener(s)
+	return s
+}
+
+// Writes a fresh cookie and starts listening. Websocket clients connect to /ws with the same auth.
+func (s *Server) Start() error {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	s.cookie = hex.EncodeToString(secret)
+
+	if err := os.WriteFile(s.config.CookieFile, []byte(CookieUser+":"+s.cookie), 0o600); err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", s.config.ListenAddr)
+
+	if err != nil {
+		os.Remove(s.config.CookieFile)
+		return err
+	}
+
+	s.listener = listener
+	s.http = &http.Server{Handler: s, ReadHeaderTimeout: 10 * time.Second}
+	go s.http.Serve(listener)
+
+	events, unsubscribe := s.pool.Subscribe(1024)
+	s.unsubscribePool = unsubscribe
+	go s.watchPool(events)
+
+	return nil
+}
+
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+func (s *Server) Stop() error {
+	os.Remove(s.config.CookieFile)
+	close(s.quit)
+	s.unsubscribePool()
+
+	s.clientsMu.Lock()
+
+	for client := range s.clients {
+		client.close()
+	}
+
+	s.clientsMu.Unlock()
+
+	return s.http.Close()
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet && r.URL.Path == "/ws" {
+		if !s.authorized(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="gold"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		s.serveWebsocket(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="gold"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize))
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	reply, ok := s.handleBody(body)
+
+	if !ok {
+		// Nothing but notifications, which get no reply
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	json.NewEncoder(w).Encode(reply)
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	user, password, ok := r.BasicAuth()
+
+	return ok && user == CookieUser && subtle.ConstantTimeCompare([]byte(password), []byte(s.cookie)) == 1
+}
+
+// Handles a single request or a batch. Returns false if there's nothing to reply with.
+func (s *Server) handleBody(body []byte) (any, bool) {
+	trimmed := strings.TrimSpace(string(body))
+
+	if !strings.HasPrefix(trimmed, "[") {
+		var req request
+
+		if err := json.Unmarshal(body, &req); err != nil {
+			return &response{JSONRPC: "2.0", Error: &Error{CodeParseError, err.Error()}, ID: json.RawMessage("null")}, true
+		}
+
+		reply := s.handle(&req)
+		return reply, reply != nil
+	}
+
+	var batch []json.RawMessage
+
+	if err := json.Unmarshal(body, &batch); err != nil {
+		return &response{JSONRPC: "2.0", Error: &Error{CodeParseError, err.Error()}, ID: json.RawMessage("null")}, true
+	}
+
+	if len(batch) == 0 {
+		return &response{JSONRPC: "2.0", Error: &Error{CodeInvalidRequest, "empty batch"}, ID: json.RawMessage("null")}, true
+	}
+
+	replies := make([]*response, 0, len(batch))
+
+	for _, raw := range batch {
+		var req request
+
+		if err := json.Unmarshal(raw, &req); err != nil {
+			replies = append(replies, &response{JSONRPC: "2.0", Error: &Error{CodeInvalidRequest, err.Error()}, ID: json.RawMessage("null")})
+			continue
+		}
+
+		if reply := s.handle(&req); reply != nil {
+			replies = append(replies, reply)
+		}
+	}
+
+	return replies, len(replies) > 0
+}
+
+// Requests without an id are notifications, which are run but get no reply
+func (s *Server) handle(req *request) *response {
+	reply := &response{JSONRPC: "2.0", ID: req.ID}
+
+	if len(req.ID) == 0 {
+		reply.ID = json.RawMessage("null")
+	}
+
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		reply.Error = &Error{CodeInvalidRequest, "not a JSON-RPC 2.0 request"}
+		return reply
+	}
+
+	result, err := s.call(req.Method, req.Params)
+
+	if len(req.ID) == 0 {
+		return nil
+	}
+
+	var rpcErr *Error
+
+	if errors.As(err, &rpcErr) {
+		reply.Error = rpcErr
+	} else if err != nil {
+		reply.Error = &Error{CodeInternalError, err.Error()}
+	} else {
+		reply.Result = result
+	}
+
+	return reply
+}
+
+// Runs a method with params given either by position or by name
+func (s *Server) call(name string, params json.RawMessage) (any, error) {
+	m, exists := methods[name]
+
+	if !exists {
+		return nil, &Error{CodeMethodNotFound, fmt.Sprintf("method %q not found", name)}
+	}
+
+	args := make([]json.RawMessage, len(m.params))
+	trimmed := strings.TrimSpace(string(params))
+
+	switch {
+	case trimmed == "" || trimmed == "null":
+	case strings.HasPrefix(trimmed, "["):
+		var positional []json.RawMessage
+
+		if err := json.Unmarshal(params, &positional); err != nil {
+			return nil, invalidParams(err.Error())
+		}
+
+		if len(positional) > len(m.params) {
+			return nil, invalidParams(fmt.Sprintf("%s takes %d params", name, len(m.params)))
+		}
+
+		copy(args, positional)
+	default:
+		var named map[string]json.RawMessage
+
+		if err := json.Unmarshal(params, &named); err != nil {
+			return nil, invalidParams(err.Error())
+		}
+
+		for i, param := range m.params {
+			args[i] = named[param]
+		}
+	}
+
+	for i, arg := range args {
+		if len(arg) == 0 || string(arg) == "null" {
+			return nil, invalidParams(fmt.Sprintf("missing param %q", m.params[i]))
+		}
+	}
+
+	return m.handler(s, args)
+}
+
+func invalidParams(message string) *Error {
+	return &Error{CodeInvalidParams, message}
+}
@@ -0,0 +1,262 @@
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// Notifications queued for a client before it's considered too slow and dropped
+	wsSendBuffer = 256
+	wsWriteWait  = 10 * time.Second
+	// Pings keep idle connections alive through proxies, and a client that stops answering is dropped
+	wsPingPeriod = 30 * time.Second
+	wsPongWait   = 2 * wsPingPeriod
+)
+
+var upgrader = websocket.Upgrader{ReadBufferSize: 4096, WriteBufferSize: 4096}
+
+// A websocket connection. It can make any of the usual calls, plus subscribe and unsubscribe.
+type wsClient struct {
+	conn *websocket.Conn
+	send chan []byte
+	quit chan struct{}
+	once sync.Once
+
+	mu   sync.Mutex
+	subs []*subscription
+}
+
+func (c *wsClient) subscriptions() []*subscription {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return slices.Clone(c.subs)
+}
+
+// Queues a message without blocking. A client whose queue is full is closed.
+func (c *wsClient) queue(data []byte) {
+	select {
+	case c.send <- data:
+	case <-c.quit:
+	default:
+		c.close()
+	}
+}
+
+func (c *wsClient) close() {
+	c.once.Do(func() {
+		close(c.quit)
+		c.conn.Close()
+	})
+}
+
+// Upgrades an authorized GET to a websocket and serves it until either side hangs up
+func (s *Server) serveWebsocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+
+	if err != nil {
+		return
+	}
+
+	client := &wsClient{conn: conn, send: make(chan []byte, wsSendBuffer), quit: make(chan struct{})}
+
+	s.clientsMu.Lock()
+	s.clients[client] = struct{}{}
+	s.clientsMu.Unlock()
+
+	go s.writeLoop(client)
+	s.readLoop(client)
+
+	s.clientsMu.Lock()
+	delete(s.clients, client)
+	s.clientsMu.Unlock()
+
+	client.close()
+}
+
+func (s *Server) readLoop(client *wsClient) {
+	client.conn.SetReadLimit(maxRequestSize)
+	client.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	client.conn.SetPongHandler(func(string) error {
+		return client.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		_, body, err := client.conn.ReadMessage()
+
+		if err != nil {
+			return
+		}
+
+		var reply any
+		var ok bool
+		var req request
+
+		if err := json.Unmarshal(body, &req); err == nil && (req.Method == "subscribe" || req.Method == "unsubscribe") {
+			reply = s.handleSubscription(client, &req)
+			ok = reply != nil
+		} else {
+			reply, ok = s.handleBody(body)
+		}
+
+		if !ok {
+			continue
+		}
+
+		data, err := json.Marshal(reply)
+
+		if err == nil {
+			client.queue(data)
+		}
+	}
+}
+
+func (s *Server) writeLoop(client *wsClient) {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case data := <-client.send:
+			client.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+
+			if err := client.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				client.close()
+				return
+			}
+		case <-ticker.C:
+			if err := client.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				client.close()
+				return
+			}
+		case <-client.quit:
+			return
+		}
+	}
+}
+
+// subscribe takes a topic and an optional filter, and returns the subscription's id. Notifications carry
+// that id. unsubscribe takes the id and returns whether there was such a subscription.
+func (s *Server) handleSubscription(client *wsClient, req *request) *response {
+	reply := &response{JSONRPC: "2.0", ID: req.ID}
+	var result any
+	var err error
+
+	if req.Method == "subscribe" {
+		result, err = s.subscribe(client, req.Params)
+	} else {
+		result, err = s.unsubscribe(client, req.Params)
+	}
+
+	if len(req.ID) == 0 {
+		return nil
+	}
+
+	if err != nil {
+		reply.Error = err.(*Error)
+	} else {
+		reply.Result = result
+	}
+
+	return reply
+}
+
+// Params are [topic, filter] or {"topic": ..., "filter": ...}
+func subscriptionParams(params json.RawMessage) ([]json.RawMessage, error) {
+	args := make([]json.RawMessage, 2)
+	trimmed := strings.TrimSpace(string(params))
+
+	if strings.HasPrefix(trimmed, "[") {
+		var positional []json.RawMessage
+
+		if err := json.Unmarshal(params, &positional); err != nil || len(positional) > len(args) {
+			return nil, invalidParams("subscribe takes a topic and a filter")
+		}
+
+		copy(args, positional)
+		return args, nil
+	}
+
+	var named map[string]json.RawMessage
+
+	if err := json.Unmarshal(params, &named); err != nil {
+		return nil, invalidParams("subscribe takes a topic and a filter")
+	}
+
+	args[0], args[1] = named["topic"], named["filter"]
+	return args, nil
+}
+
+func (s *Server) subscribe(client *wsClient, params json.RawMessage) (any, error) {
+	args, err := subscriptionParams(params)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sub := &subscription{}
+
+	if err := json.Unmarshal(args[0], &sub.topic); err != nil {
+		return nil, invalidParams("topic must be a string")
+	}
+
+	if len(args[1]) > 0 && string(args[1]) != "null" {
+		if err := json.Unmarshal(args[1], &sub.filter); err != nil {
+			return nil, invalidParams("filter must be a string")
+		}
+	}
+
+	switch sub.topic {
+	case TopicBlocks, TopicMempool, TopicNames:
+	case TopicOps:
+		if sub.filter == "" {
+			return nil, invalidParams("ops subscriptions need an address to filter on")
+		}
+
+		addr, err := s.chain.Params().ParseAddress(sub.filter)
+
+		if err != nil {
+			return nil, invalidParams(err.Error())
+		}
+
+		sub.addr = addr
+	default:
+		return nil, invalidParams(fmt.Sprintf("unknown topic %q", sub.topic))
+	}
+
+	sub.id = s.nextSub.Add(1)
+
+	client.mu.Lock()
+	client.subs = append(client.subs, sub)
+	client.mu.Unlock()
+
+	return sub.id, nil
+}
+
+func (s *Server) unsubscribe(client *wsClient, params json.RawMessage) (any, error) {
+	var args []uint64
+
+	if err := json.Unmarshal(params, &args); err != nil || len(args) != 1 {
+		return nil, invalidParams("unsubscribe takes a subscription id")
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	for i, sub := range client.subs {
+		if sub.id == args[0] {
+			client.subs = slices.Delete(client.subs, i, i+1)
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
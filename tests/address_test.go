@@ -0,0 +1,75 @@
+package tests
+
+import (
+	"errors"
+	b "gold/blockchain"
+	"gold/types"
+	"strings"
+	"testing"
+)
+
+func TestAddressEncoding(t *testing.T) {
+	regtest := &b.RegTestParams
+	_, pk := newKeypair()
+	encoded := regtest.FormatAddress(b.AddrFromKey(&pk))
+
+	if !strings.HasPrefix(encoded, "goldrt1") {
+		t.Fatalf("Expected a regtest address, got %s", encoded)
+	}
+
+	for _, text := range []string{encoded, strings.ToUpper(encoded)} {
+		if addr, err := regtest.ParseAddress(text); err != nil || addr.UsesName || !addr.Key.IsEqual(&pk) {
+			t.Errorf("Expected %s to parse back to the key, got %v", text, err)
+		}
+	}
+
+	// Every single character typo after the prefix is caught
+	for i := len("goldrt1"); i < len(encoded); i++ {
+		typo := []byte(encoded)
+		typo[i] = map[bool]byte{true: 'q', false: 'p'}[typo[i] != 'q']
+
+		if _, err := regtest.ParseAddress(string(typo)); !errors.Is(err, types.ErrInvalidAddress) {
+			t.Fatalf("Expected a typo at %d to be caught, got %v", i, err)
+		}
+	}
+
+	mixed := strings.ToUpper(encoded[:10]) + encoded[10:]
+
+	if _, err := regtest.ParseAddress(mixed); !errors.Is(err, types.ErrInvalidAddress) {
+		t.Errorf("Expected mixed case to be rejected, got %v", err)
+	}
+
+	// An address from another network doesn't parse, even though its checksum is fine
+	if _, err := b.MainNetParams.ParseAddress(encoded); err == nil || !strings.Contains(err.Error(), "another network") {
+		t.Errorf("Expected a regtest address to be refused on mainnet, got %v", err)
+	}
+
+	// Making a chain for another network doesn't change how this one's addresses are written
+	b.NewChain(&b.MainNetParams)
+
+	if regtest.FormatAddress(b.AddrFromKey(&pk)) != encoded {
+		t.Errorf("Expected regtest addresses to keep their prefix after a mainnet chain was made")
+	}
+
+	// From BIP 350's valid bech32m strings, which has the right checksum but no key
+	if _, err := types.ParseAddress("A1LQFN3A", "a"); err == nil || !strings.Contains(err.Error(), "not a compressed public key") {
+		t.Errorf("Expected the checksum of a bech32m test vector to match, got %v", err)
+	}
+
+	if addr, err := regtest.ParseAddress("@GitMonke"); err != nil || !addr.UsesName || *addr.Name != "GitMonke" || regtest.FormatAddress(addr) != "@GitMonke" {
+		t.Errorf("Expected a name address, got %+v and %v", addr, err)
+	}
+
+	// Names are encoded with a one byte length, so longer ones can't be addresses
+	longest := "@" + strings.Repeat("a", types.MaxNameLength)
+
+	if _, err := regtest.ParseAddress(longest); err != nil {
+		t.Errorf("Expected a %d byte name to parse, got %v", types.MaxNameLength, err)
+	}
+
+	for _, text := range []string{"@", "GitMonke", "", longest + "a"} {
+		if _, err := regtest.ParseAddress(text); !errors.Is(err, types.ErrInvalidAddress) {
+			t.Errorf("Expected %q to be rejected, got %v", text, err)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package tests
+
+import (
+	"crypto/sha256"
+	"fmt"
+	b "gold/blockchain"
+	"gold/types"
+	"strings"
+	"testing"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/schnorr"
+)
+
+type signedHash struct {
+	hash [32]byte
+	sig  *schnorr.Signature
+	key  *secp256k1.PublicKey
+}
+
+func signedHashes(n int) []signedHash {
+	signed := make([]signedHash, n)
+
+	for i := range signed {
+		sk, _ := secp256k1.GeneratePrivateKey()
+		signed[i].hash = sha256.Sum256([]byte(fmt.Sprint(i)))
+		signed[i].sig, _ = schnorr.Sign(sk, signed[i].hash[:])
+		signed[i].key = sk.PubKey()
+	}
+
+	return signed
+}
+
+func batchOf(signed []signedHash) *b.SigBatch {
+	batch := &b.SigBatch{}
+
+	for _, s := range signed {
+		batch.Add(s.hash, s.sig, s.key)
+	}
+
+	return batch
+}
+
+// A block of n txns, each from its own account, with the median block size raised so it fits
+func blockOfTxns(n int) (types.State, *types.Block) {
+	state := initState()
+
+	for i := range state.BlockSizes {
+		state.BlockSizes[i] = 1_000_000
+	}
+
+	state.Height = len(state.BlockSizes)
+
+	_, pkMiner := newKeypair()
+	initAccount(&state, "Miner", &pkMiner, 0)
+	addr := b.AddrFromKey(&pkMiner)
+	block := &types.Block{Header: types.Header{Timestamp: 1}, Operations: []types.Op{b.TemplateCoinbase(&addr)}}
+
+	for range n {
+		sk, pk := newKeypair()
+		state.AccountSet[pk] = &types.Account{Balance: 1000}
+		block.Operations = append(block.Operations, signedTxn(&sk, &pkMiner, 10, 5, 0))
+	}
+
+	block.Operations[0] = b.Coinbase(&addr, block, &state)
+	block.Header.MerkleRoot = b.CalculateMerkleRoot(block.Operations)
+
+	return state, block
+}
+
+func TestSigBatch(t *testing.T) {
+	for _, n := range []int{3, 300} {
+		signed := signedHashes(n)
+
+		if !batchOf(signed).Verify() {
+			t.Fatalf("Expected %d valid signatures to verify", n)
+		}
+
+		// Two valid signatures on the wrong hashes
+		swapped := append([]signedHash{}, signed...)
+		swapped[1].hash, swapped[2].hash = swapped[2].hash, swapped[1].hash
+		batch := batchOf(swapped)
+
+		if batch.Verify() || batch.FirstInvalid() != 1 {
+			t.Errorf("Expected swapped hashes to fail at 1 in a batch of %d, got %d", n, batch.FirstInvalid())
+		}
+
+		other, _ := secp256k1.GeneratePrivateKey()
+		forged := append([]signedHash{}, signed...)
+		forged[n-1].key = other.PubKey()
+		batch = batchOf(forged)
+
+		if batch.Verify() || batch.FirstInvalid() != n-1 {
+			t.Errorf("Expected the wrong key to fail at %d in a batch of %d", n-1, n)
+		}
+	}
+
+	if !(&b.SigBatch{}).Verify() {
+		t.Errorf("Expected an empty batch to verify")
+	}
+}
+
+func TestBlockSignaturesBatched(t *testing.T) {
+	state, block := blockOfTxns(50)
+	before := b.CopyState(&state)
+
+	if err := b.CheckBlock(block, &state); err != nil {
+		t.Fatalf("Expected the block to connect, got %v", err)
+	}
+
+	// A bad signature is pinned on its op even though the ops after it are fine
+	bad := block.Operations[17].(*b.Txn)
+	sk, _ := secp256k1.GeneratePrivateKey()
+	bad.Signature = bad.Sign(sk, chainID)
+	block.Header.MerkleRoot = b.CalculateMerkleRoot(block.Operations)
+
+	err := b.CheckBlock(block, &state)
+
+	if err == nil || err.Error() != "op 17 is invalid: "+b.ErrTxnSig.Error() {
+		t.Errorf("Expected op 17's signature to be blamed, got %v", err)
+	}
+
+	// And it's still blamed when a later op fails for another reason first
+	block.Operations[40].(*b.Txn).Nonce = 7
+	block.Header.MerkleRoot = b.CalculateMerkleRoot(block.Operations)
+
+	if err := b.CheckBlock(block, &state); err == nil || !strings.HasPrefix(err.Error(), "op 17 is invalid") {
+		t.Errorf("Expected op 17 to be blamed before op 40, got %v", err)
+	}
+
+	if state.AccountSet[*bad.Sender.Key].Balance != before.AccountSet[*bad.Sender.Key].Balance {
+		t.Errorf("Expected the state to be rolled back")
+	}
+}
+
+func BenchmarkSigVerify(bench *testing.B) {
+	for _, n := range []int{100, 1000, 4000} {
+		signed := signedHashes(n)
+
+		bench.Run(fmt.Sprintf("single/%d", n), func(bench *testing.B) {
+			for range bench.N {
+				for _, s := range signed {
+					s.sig.Verify(s.hash[:], s.key)
+				}
+			}
+		})
+
+		bench.Run(fmt.Sprintf("batch/%d", n), func(bench *testing.B) {
+			for range bench.N {
+				batchOf(signed).Verify()
+			}
+		})
+	}
+}
+
+func BenchmarkConnectBlock(bench *testing.B) {
+	// Off, so every run verifies every signature
+	b.SigCache.Reset(0)
+	defer b.SigCache.Reset(b.DefaultSigCacheSize)
+
+	for _, n := range []int{1000, 4000} {
+		state, block := blockOfTxns(n)
+
+		// Validating and applying each op as it comes, which checks every signature on its own
+		bench.Run(fmt.Sprintf("sequential/%d", n), func(bench *testing.B) {
+			for range bench.N {
+				undos := make([]types.UndoOp, 0, n)
+
+				for _, op := range block.Operations[1:] {
+					if err := op.Validate(&state); err != nil {
+						bench.Fatal(err)
+					}
+
+					undos = append(undos, op.PerformOp(&state))
+				}
+
+				for i := len(undos) - 1; i >= 0; i-- {
+					undos[i].PerformUndo(&state)
+				}
+			}
+		})
+
+		bench.Run(fmt.Sprintf("batched/%d", n), func(bench *testing.B) {
+			for range bench.N {
+				if err := b.CheckBlock(block, &state); err != nil {
+					bench.Fatal(err)
+				}
+			}
+		})
+
+		// Every signature already verified, as when the ops came through the mempool first
+		bench.Run(fmt.Sprintf("cached/%d", n), func(bench *testing.B) {
+			b.SigCache.Reset(b.DefaultSigCacheSize)
+			defer b.SigCache.Reset(0)
+
+			if err := b.CheckBlock(block, &state); err != nil {
+				bench.Fatal(err)
+			}
+
+			bench.ResetTimer()
+
+			for range bench.N {
+				if err := b.CheckBlock(block, &state); err != nil {
+					bench.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package tests
+
+import (
+	b "gold/blockchain"
+	"gold/types"
+	"testing"
+	"time"
+)
+
+func emptyBlock(state *types.State, addr types.Address, timestamp uint32) *types.Block {
+	block := &types.Block{
+		Header:     types.Header{Timestamp: timestamp},
+		Operations: []types.Op{b.TemplateCoinbase(&addr)},
+	}
+
+	block.Operations[0] = b.Coinbase(&addr, block, state)
+	block.Header.MerkleRoot = b.CalculateMerkleRoot(block.Operations)
+
+	return block
+}
+
+func TestMedianTimePastRule(t *testing.T) {
+	state := initState()
+	_, pkMonke := newKeypair()
+	initAccount(&state, "GitMonke", &pkMonke, 0)
+	addr := b.AddrFromKey(&pkMonke)
+
+	for _, timestamp := range []uint32{100, 300, 200} {
+		if _, err := b.ConnectBlock(emptyBlock(&state, addr, timestamp), &state); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The median of 100, 300 and 200 is 200
+	if err := b.CheckBlock(emptyBlock(&state, addr, 200), &state); err == nil {
+		t.Error("Expected a block at the median time past to be invalid")
+	}
+
+	block := emptyBlock(&state, addr, 201)
+	undo, err := b.ConnectBlock(block, &state)
+
+	if err != nil {
+		t.Fatalf("Expected a block after the median time past to connect, got %v", err)
+	}
+
+	if state.Timestamps[3] != 201 {
+		t.Errorf("Timestamp was not recorded, got %d wanted %d", state.Timestamps[3], 201)
+	}
+
+	b.DisconnectBlock(block, undo, &state)
+
+	if state.Timestamps[3] != 0 || state.Height != 3 {
+		t.Error("Disconnecting the block did not restore the timestamps")
+	}
+}
+
+func TestTimestampRingBufferWraps(t *testing.T) {
+	state := initState()
+	_, pkMonke := newKeypair()
+	initAccount(&state, "GitMonke", &pkMonke, 0)
+	addr := b.AddrFromKey(&pkMonke)
+
+	state.Height = len(state.Timestamps)
+	state.Timestamps[0] = 5
+
+	block := emptyBlock(&state, addr, 10_000)
+	undo, err := b.ConnectBlock(block, &state)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if state.Timestamps[0] != 10_000 {
+		t.Error("Expected the oldest timestamp to be overwritten")
+	}
+
+	b.DisconnectBlock(block, undo, &state)
+
+	if state.Timestamps[0] != 5 {
+		t.Error("Expected the overwritten timestamp to be restored")
+	}
+}
+
+func TestFutureTimestampRule(t *testing.T) {
+	state := initState()
+	now := time.Unix(1_000_000, 0)
+
+	header := types.Header{Timestamp: uint32(now.Add(b.MaxFutureBlockTime).Unix())}
+
+	if err := b.CheckHeaderTime(header, &state, now); err != nil {
+		t.Errorf("Expected a timestamp right at the limit to be fine, got %v", err)
+	}
+
+	header.Timestamp += 1
+
+	if err := b.CheckHeaderTime(header, &state, now); err == nil {
+		t.Error("Expected a timestamp past the limit to be refused")
+	}
+}
+
+func TestDecodeRoundTrip(t *testing.T) {
+	state := initState()
+	skMonke, pkMonke := newKeypair()
+	_, pkJeff := newKeypair()
+	initAccount(&state, "GitMonke", &pkMonke, 1000)
+
+	monkeAddr := b.AddrFromName("GitMonke")
+	txn := signedTxn(&skMonke, &pkJeff, 100, 10, 0)
+	txn.Payments = append(txn.Payments, b.Payment{Reciever: monkeAddr, Amount: 5})
+	txn.Signature = txn.Sign(&skMonke, chainID)
+
+	block := emptyBlock(&state, monkeAddr, 1)
+	block.Operations = append(block.Operations, txn, b.NewRename("Jeff", &skMonke, &pkJeff, chainID))
+	block.Header.MerkleRoot = b.CalculateMerkleRoot(block.Operations)
+
+	decoded, err := b.DecodeBlock(b.EncodeBlock(block))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b.HashBlockHeader(decoded.Header) != b.HashBlockHeader(block.Header) || b.CalculateMerkleRoot(decoded.Operations) != block.Header.MerkleRoot {
+		t.Error("Decoded block does not match the original")
+	}
+
+	if !b.IsCoinbase(decoded.Operations[0]) {
+		t.Error("Expected the coinbase to decode as a coinbase")
+	}
+
+	decodedTxn := decoded.Operations[1].(*b.Txn)
+
+	if err := decodedTxn.Validate(&state); err != nil {
+		t.Errorf("Expected the decoded txn to validate, got %v", err)
+	}
+
+	if *decodedTxn.Sender.Key != pkMonke {
+		t.Error("Expected decoded keys to equal the originals")
+	}
+
+	if _, err := b.DecodeOp(append(txn.Encode(), 0)); err == nil {
+		t.Error("Expected trailing bytes to be refused")
+	}
+
+	if _, err := b.DecodeOp(txn.Encode()[:20]); err == nil {
+		t.Error("Expected a truncated op to be refused")
+	}
+}
@@ -0,0 +1,333 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	b "gold/blockchain"
+	"gold/mempool"
+	"gold/miner"
+	"gold/types"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Mines a block on top of state and prevHash without touching any chain
+func mineBlock(t *testing.T, state *types.State, pool *mempool.Pool, prevHash [32]byte, coinbaseAddr types.Address) *types.Block {
+	m := miner.New(&b.RegTestParams, pool, coinbaseAddr, 1)
+	block, err := m.MineBlock(context.Background(), state, prevHash)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return block
+}
+
+func TestChainReorg(t *testing.T) {
+	skMonke, pkMonke := newKeypair()
+	_, pkJeff := newKeypair()
+	monkeAddr := b.AddrFromKey(&pkMonke)
+
+	chain := b.NewChain(&b.RegTestParams)
+	state, genesis := chain.Snapshot()
+	pool := mempool.New(&state, mempool.DefaultConfig())
+	chain.AddListener(pool)
+
+	// Both forks start from a block paying GitMonke
+	first := mineBlock(t, &state, pool, genesis, monkeAddr)
+
+	if err := chain.ProcessBlock(first); err != nil {
+		t.Fatal(err)
+	}
+
+	txn := signedTxn(&skMonke, &pkJeff, 100, 10, 0)
+
+	if err := pool.Add(txn); err != nil {
+		t.Fatal(err)
+	}
+
+	forkState, forkTip := chain.Snapshot()
+	withTxn := mineBlock(t, &forkState, pool, forkTip, monkeAddr)
+
+	if err := chain.ProcessBlock(withTxn); err != nil {
+		t.Fatal(err)
+	}
+
+	if pool.Has(b.OpHash(txn)) {
+		t.Error("Expected the mined op to leave the pool")
+	}
+
+	// A longer fork without the txn
+	emptyPool := mempool.New(&forkState, mempool.DefaultConfig())
+	var blocks []*types.Block
+	prevHash := forkTip
+
+	for i := 0; i < 2; i++ {
+		block := mineBlock(t, &forkState, emptyPool, prevHash, monkeAddr)
+
+		if _, err := b.ConnectBlock(block, &forkState); err != nil {
+			t.Fatal(err)
+		}
+
+		blocks = append(blocks, block)
+		prevHash = b.HashBlockHeader(block.Header)
+	}
+
+	if err := chain.ProcessBlock(blocks[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	if tip, _ := chain.Tip(); tip != b.HashBlockHeader(withTxn.Header) {
+		t.Error("Expected an equal work fork not to take over")
+	}
+
+	if err := chain.ProcessBlock(blocks[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	if tip, height := chain.Tip(); tip != prevHash || height != 3 {
+		t.Error("Expected the fork with more work to take over")
+	}
+
+	if _, onMain := chain.HeightOf(b.HashBlockHeader(withTxn.Header)); onMain {
+		t.Error("Expected the replaced block to leave the main chain")
+	}
+
+	if !pool.Has(b.OpHash(txn)) {
+		t.Error("Expected the op from the disconnected block to go back into the pool")
+	}
+
+	chain.ReadState(func(state *types.State, tip [32]byte) {
+		if _, exists := state.AccountSet[pkJeff]; exists {
+			t.Error("Expected the payment to Jeff to be undone")
+		}
+	})
+}
+
+func TestChainRejectsInvalidFork(t *testing.T) {
+	_, pkMonke := newKeypair()
+	monkeAddr := b.AddrFromKey(&pkMonke)
+
+	chain := b.NewChain(&b.RegTestParams)
+	state, genesis := chain.Snapshot()
+	pool := mempool.New(&state, mempool.DefaultConfig())
+
+	good := mineBlock(t, &state, pool, genesis, monkeAddr)
+	chain.ProcessBlock(good)
+
+	// Pays itself more than the reward, so the header is fine but the block isn't
+	bad := emptyBlock(&state, monkeAddr, good.Header.Timestamp)
+	bad.Header.PrevBlockHash = genesis
+	bad.Operations[0].(*b.Txn).Payments[0].Amount += 1
+	bad.Header.MerkleRoot = b.CalculateMerkleRoot(bad.Operations)
+	badHeader, _ := miner.Solve(context.Background(), bad.Header, b.RegTestParams.PowTarget, 1)
+	bad.Header = badHeader
+
+	badState := state
+	badState.Height = 1
+	next := emptyBlock(&badState, monkeAddr, good.Header.Timestamp+1)
+	next.Header.PrevBlockHash = b.HashBlockHeader(bad.Header)
+	nextHeader, _ := miner.Solve(context.Background(), next.Header, b.RegTestParams.PowTarget, 1)
+	next.Header = nextHeader
+
+	chain.ProcessBlock(bad)
+
+	if err := chain.ProcessBlock(next); err == nil {
+		t.Error("Expected the fork to be refused once its invalid block had to be connected")
+	}
+
+	if tip, _ := chain.Tip(); tip != b.HashBlockHeader(good.Header) {
+		t.Error("Expected the main chain to be put back")
+	}
+}
+
+func TestChainStoreResumes(t *testing.T) {
+	_, pkMonke := newKeypair()
+	monkeAddr := b.AddrFromKey(&pkMonke)
+	dir := t.TempDir()
+
+	source := b.NewChain(&b.RegTestParams)
+	blocks := make([]*types.Block, 5)
+
+	for i := range blocks {
+		state, tip := source.Snapshot()
+		pool := mempool.New(&state, mempool.DefaultConfig())
+		blocks[i] = mineBlock(t, &state, pool, tip, monkeAddr)
+
+		if err := source.ProcessBlock(blocks[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	chain, err := b.OpenChain(&b.RegTestParams, dir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every header but only the first two bodies, as if the node stopped halfway through syncing
+	for _, block := range blocks {
+		if err := chain.ProcessHeader(block.Header); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, block := range blocks[:2] {
+		if err := chain.ProcessBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := chain.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	chain, err = b.OpenChain(&b.RegTestParams, dir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer chain.Close()
+
+	if _, height := chain.BestHeader(); height != 5 {
+		t.Errorf("Best header is at height %d after reopening, wanted %d", height, 5)
+	}
+
+	if _, height := chain.Tip(); height != 2 {
+		t.Errorf("Tip is at height %d after reopening, wanted %d", height, 2)
+	}
+
+	if missing := chain.MissingBlocks(10); len(missing) != 3 {
+		t.Fatalf("%d blocks are missing after reopening, wanted %d", len(missing), 3)
+	}
+
+	for _, block := range blocks[2:] {
+		if err := chain.ProcessBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sourceTip, _ := source.Tip()
+
+	if tip, _ := chain.Tip(); tip != sourceTip {
+		t.Error("Reopened chain didn't reach the same tip once the missing blocks arrived")
+	}
+}
+
+func TestChainStoreStopsAtOversizedRecord(t *testing.T) {
+	_, pkMonke := newKeypair()
+	dir := t.TempDir()
+	chain, err := b.OpenChain(&b.RegTestParams, dir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, tip := chain.Snapshot()
+	block := mineBlock(t, &state, mempool.New(&state, mempool.DefaultConfig()), tip, b.AddrFromKey(&pkMonke))
+
+	if err := chain.ProcessBlock(block); err != nil {
+		t.Fatal(err)
+	}
+
+	chain.Close()
+	path := filepath.Join(dir, "chain.dat")
+	info, _ := os.Stat(path)
+
+	// A block record claiming 4GB, followed by a little of it
+	file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	file.Write(append([]byte{1, 0xff, 0xff, 0xff, 0xff}, make([]byte, 100)...))
+	file.Close()
+
+	chain, err = b.OpenChain(&b.RegTestParams, dir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer chain.Close()
+
+	if _, height := chain.Tip(); height != 1 {
+		t.Errorf("Tip is at height %d after reopening, wanted %d", height, 1)
+	}
+
+	if after, _ := os.Stat(path); after.Size() != info.Size() {
+		t.Errorf("Expected the log to be cut back to %d bytes, got %d", info.Size(), after.Size())
+	}
+}
+
+func TestChainStoreRefusesOversizedRecordMidLog(t *testing.T) {
+	_, pkMonke := newKeypair()
+	dir := t.TempDir()
+	chain, err := b.OpenChain(&b.RegTestParams, dir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, tip := chain.Snapshot()
+	block := mineBlock(t, &state, mempool.New(&state, mempool.DefaultConfig()), tip, b.AddrFromKey(&pkMonke))
+
+	if err := chain.ProcessBlock(block); err != nil {
+		t.Fatal(err)
+	}
+
+	chain.Close()
+	path := filepath.Join(dir, "chain.dat")
+	valid, _ := os.ReadFile(path)
+
+	// A header record far bigger than a header, with the valid records after it
+	corrupt := append([]byte{0, 200, 0, 0, 0}, make([]byte, 200)...)
+	os.WriteFile(path, append(corrupt, valid...), 0o600)
+
+	if _, err := b.OpenChain(&b.RegTestParams, dir); !errors.Is(err, b.ErrCorruptStore) {
+		t.Errorf("Expected the corrupt record to be refused, got %v", err)
+	}
+
+	if after, _ := os.Stat(path); after.Size() != int64(len(corrupt)+len(valid)) {
+		t.Errorf("Expected the log to be left alone, it's %d bytes", after.Size())
+	}
+}
+
+// Blocks were checked against the clock when they were stored, so a clock that's gone back since doesn't
+// stop the chain reopening
+func TestChainStoreReplaysBlocksAheadOfTheClock(t *testing.T) {
+	_, pkMonke := newKeypair()
+	dir := t.TempDir()
+	chain, err := b.OpenChain(&b.RegTestParams, dir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ahead := time.Now().Add(3 * b.MaxFutureBlockTime)
+	chain.Now = func() time.Time { return ahead }
+
+	state, tip := chain.Snapshot()
+	block := mineBlock(t, &state, mempool.New(&state, mempool.DefaultConfig()), tip, b.AddrFromKey(&pkMonke))
+	block.Header.Timestamp = uint32(ahead.Unix())
+
+	for !b.CheckProofOfWork(block.Header, b.RegTestParams.PowTarget) {
+		block.Header.Nonce++
+	}
+
+	if err := chain.ProcessBlock(block); err != nil {
+		t.Fatal(err)
+	}
+
+	chain.Close()
+	chain, err = b.OpenChain(&b.RegTestParams, dir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer chain.Close()
+
+	if hash, height := chain.Tip(); height != 1 || hash != b.HashBlockHeader(block.Header) {
+		t.Errorf("Expected the block from the future to be the tip again, tip is at height %d", height)
+	}
+}
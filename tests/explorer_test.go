@@ -0,0 +1,222 @@
+package tests
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	b "gold/blockchain"
+	"gold/explorer"
+	"gold/mempool"
+	"gold/types"
+	"net/http"
+	"testing"
+)
+
+func getJSON(t *testing.T, url string, result any) int {
+	resp, err := http.Get(url)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		t.Fatal(err)
+	}
+
+	return resp.StatusCode
+}
+
+func TestExplorerAddressHistory(t *testing.T) {
+	skMonke, pkMonke := newKeypair()
+	_, pkJeff := newKeypair()
+	_, pkBob := newKeypair()
+
+	chain := b.NewChain(&b.RegTestParams)
+	monkeAddr := b.RegTestParams.FormatAddress(b.AddrFromKey(&pkMonke))
+	jeffAddr := b.RegTestParams.FormatAddress(b.AddrFromKey(&pkJeff))
+	state, _ := chain.Snapshot()
+	pool := mempool.New(&state, mempool.DefaultConfig())
+	chain.AddListener(pool)
+
+	first := mineOnChain(t, chain, pool, b.AddrFromKey(&pkMonke))
+	forkState, _ := chain.Snapshot()
+
+	rename := &b.Rename{Name: "GitMonke", NewKey: &pkMonke, Fee: 10, Nonce: 0}
+	rename.Signature = rename.Sign(&skMonke, chainID)
+
+	if err := pool.Add(rename); err != nil {
+		t.Fatal(err)
+	}
+
+	mineOnChain(t, chain, pool, b.AddrFromKey(&pkMonke))
+
+	// Built after the first blocks, so it has to catch up on them
+	index, err := explorer.NewIndex(chain)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txn := &b.Txn{
+		Sender:   b.AddrFromName("GitMonke"),
+		Payments: []b.Payment{{Reciever: b.AddrFromKey(&pkJeff), Amount: 100}},
+		Fee:      10,
+		Nonce:    1,
+	}
+
+	txn.Signature = txn.Sign(&skMonke, chainID)
+
+	if err := pool.Add(txn); err != nil {
+		t.Fatal(err)
+	}
+
+	mineOnChain(t, chain, pool, b.AddrFromKey(&pkMonke))
+
+	config := explorer.DefaultConfig()
+	config.ListenAddr = "127.0.0.1:0"
+	server := explorer.NewServer(config, chain, index)
+
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Stop()
+	api := "http://" + server.Addr().String() + "/api"
+
+	var history explorer.AddressHistory
+	getJSON(t, api+"/address/@GitMonke", &history)
+
+	if history.Total != 2 || history.Entries[0].Type != "txn" || history.Entries[0].Role != explorer.RoleSender || history.Entries[1].Role != explorer.RoleName {
+		t.Errorf("Unexpected history for GitMonke: %+v", history)
+	}
+
+	// The txn sent from GitMonke is listed under the key it resolved to as well
+	getJSON(t, fmt.Sprintf("%s/address/%s?offset=1&limit=2", api, monkeAddr), &history)
+
+	if history.Total != 5 || len(history.Entries) != 2 || history.Entries[0].Height != 3 || history.Entries[0].Position != 0 || history.Entries[1].Role != explorer.RoleNewOwner {
+		t.Errorf("Unexpected second page of monke's history: %+v", history)
+	}
+
+	getJSON(t, api+"/address/"+jeffAddr, &history)
+
+	if history.Total != 1 || history.Entries[0].Role != explorer.RoleReciever || history.Entries[0].Height != 3 {
+		t.Errorf("Unexpected history for jeff: %+v", history)
+	}
+
+	txnHash := b.OpHash(txn)
+	var op explorer.IndexedOp
+
+	if status := getJSON(t, api+"/op/"+hex.EncodeToString(txnHash[:]), &op); status != http.StatusOK || op.Height != 3 || op.Position != 1 {
+		t.Errorf("Unexpected op lookup: %d %+v", status, op)
+	}
+
+	// A longer fork from the first block unwinds everything after it
+	emptyPool := mempool.New(&forkState, mempool.DefaultConfig())
+	prevHash := b.HashBlockHeader(first.Header)
+	var fork []*types.Block
+
+	for i := 0; i < 3; i++ {
+		block := mineBlock(t, &forkState, emptyPool, prevHash, b.AddrFromKey(&pkBob))
+
+		if _, err := b.ConnectBlock(block, &forkState); err != nil {
+			t.Fatal(err)
+		}
+
+		fork = append(fork, block)
+		prevHash = b.HashBlockHeader(block.Header)
+	}
+
+	for _, block := range fork {
+		if err := chain.ProcessBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, total := index.History(b.AddrFromName("GitMonke"), 0, 10); total != 0 {
+		t.Errorf("Expected GitMonke's history to be unwound, got %d entries", total)
+	}
+
+	if entries, total := index.History(b.AddrFromKey(&pkMonke), 0, 10); total != 1 || entries[0].Height != 1 {
+		t.Errorf("Expected only monke's first coinbase to be left, got %+v", entries)
+	}
+
+	if _, total := index.History(b.AddrFromKey(&pkBob), 0, 10); total != 3 {
+		t.Errorf("Expected the fork's coinbases to be indexed, got %d", total)
+	}
+
+	var failure map[string]string
+
+	if status := getJSON(t, api+"/op/"+hex.EncodeToString(txnHash[:]), &failure); status != http.StatusNotFound {
+		t.Errorf("Expected the disconnected txn not to be found, got %d", status)
+	}
+}
+
+func TestExplorerIndexResolvesPerOp(t *testing.T) {
+	skMonke, pkMonke := newKeypair()
+	_, pkJeff := newKeypair()
+	_, pkBob := newKeypair()
+
+	chain := b.NewChain(&b.RegTestParams)
+	state, _ := chain.Snapshot()
+	pool := mempool.New(&state, mempool.DefaultConfig())
+	chain.AddListener(pool)
+	index, err := explorer.NewIndex(chain)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mineOnChain(t, chain, pool, b.AddrFromKey(&pkMonke))
+
+	claim := &b.Rename{Name: "GitMonke", NewKey: &pkMonke, Fee: 10, Nonce: 0}
+	claim.Signature = claim.Sign(&skMonke, chainID)
+	pool.Add(claim)
+	mineOnChain(t, chain, pool, b.AddrFromKey(&pkMonke))
+
+	// Sent from GitMonke, then GitMonke is given to jeff in the same block
+	txn := &b.Txn{Sender: b.AddrFromName("GitMonke"), Payments: []b.Payment{{Reciever: b.AddrFromKey(&pkBob), Amount: 100}}, Fee: 10, Nonce: 1}
+	txn.Signature = txn.Sign(&skMonke, chainID)
+	give := &b.Rename{Name: "GitMonke", NewKey: &pkJeff, Fee: 10, Nonce: 2}
+	give.Signature = give.Sign(&skMonke, chainID)
+
+	for _, op := range []types.Op{txn, give} {
+		if err := pool.Add(op); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mineOnChain(t, chain, pool, b.AddrFromKey(&pkBob))
+
+	if entries, _ := index.History(b.AddrFromKey(&pkMonke), 0, 10); entries[0].Role != explorer.RoleOldOwner || entries[1].Role != explorer.RoleSender || entries[1].Height != 3 {
+		t.Errorf("Expected the txn to be listed under the key that owned the name when it was sent, got %+v", entries)
+	}
+
+	jeffEntries, _ := index.History(b.AddrFromKey(&pkJeff), 0, 10)
+
+	for _, entry := range jeffEntries {
+		if entry.Role == explorer.RoleSender {
+			t.Errorf("Expected jeff not to be listed as the sender, got %+v", entry)
+		}
+	}
+
+	// Empty blocks to the same address have the same coinbase
+	first := mineOnChain(t, chain, pool, b.AddrFromKey(&pkBob))
+	second := mineOnChain(t, chain, pool, b.AddrFromKey(&pkBob))
+	coinbase := b.OpHash(first.Operations[0])
+
+	if coinbase != b.OpHash(second.Operations[0]) {
+		t.Fatal("Expected the coinbases to have the same hash")
+	}
+
+	if _, height, _, found := index.FindOp(coinbase); !found || height != 5 {
+		t.Errorf("Expected the coinbase to be found in the newest block, got %d", height)
+	}
+
+	index.BlockDisconnected(second, nil, nil)
+
+	if _, height, _, found := index.FindOp(coinbase); !found || height != 4 {
+		t.Errorf("Expected the coinbase to still be found in the older block, got %d and %v", height, found)
+	}
+}
@@ -0,0 +1,73 @@
+package tests
+
+import (
+	b "gold/blockchain"
+	"gold/fees"
+	"gold/mempool"
+	"gold/wallet"
+	"testing"
+)
+
+func TestFeeEstimation(t *testing.T) {
+	chain := b.NewChain(&b.RegTestParams)
+	state, _ := chain.Snapshot()
+	pool := mempool.New(&state, mempool.DefaultConfig())
+	chain.AddListener(pool)
+	estimator := fees.NewEstimator(chain, pool)
+
+	if rate := estimator.EstimateFee(1); rate != fees.FallbackFeeRate {
+		t.Errorf("Expected the fallback rate on a fresh chain, got %d", rate)
+	}
+
+	skMonke, pkMonke := newKeypair()
+	_, pkJeff := newKeypair()
+	mineOnChain(t, chain, pool, b.AddrFromKey(&pkMonke))
+
+	// More than a block's worth of ops paying 5 per byte
+	builder := wallet.NewBuilder(chain, pool, nil)
+	builder.FeeRate = 5
+
+	for pool.Bytes() < 80_000 {
+		txn, err := builder.Txn(b.AddrFromKey(&pkMonke), &skMonke, []b.Payment{{Reciever: b.AddrFromKey(&pkJeff), Amount: 1}})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := pool.Add(txn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if rate := estimator.EstimateFee(1); rate != 6 {
+		t.Errorf("Expected to have to outbid the backlog for the next block, got %d", rate)
+	}
+
+	if rate := estimator.EstimateFee(2); rate != fees.FallbackFeeRate {
+		t.Errorf("Expected two blocks to fit the whole backlog, got %d", rate)
+	}
+
+	// Once a full block has taken ops at 5 per byte, that's what's suggested even with room to spare
+	block := mineOnChain(t, chain, pool, b.AddrFromKey(&pkMonke))
+
+	if b.BlockSize(block) < b.FullRewardZone/2 {
+		t.Fatalf("Expected a full block, got %d bytes", b.BlockSize(block))
+	}
+
+	if rate := estimator.EstimateFee(10); rate != 5 {
+		t.Errorf("Expected recent blocks to suggest 5 per byte, got %d", rate)
+	}
+
+	// Estimators started later catch up on recent blocks
+	if rate := fees.NewEstimator(chain, pool).EstimateFee(10); rate != 5 {
+		t.Errorf("Expected a new estimator to see the full block, got %d", rate)
+	}
+
+	estimated := wallet.NewBuilder(chain, pool, estimator)
+	estimated.TargetBlocks = 10
+	txn, err := estimated.Txn(b.AddrFromKey(&pkMonke), &skMonke, []b.Payment{{Reciever: b.AddrFromKey(&pkJeff), Amount: 1}})
+
+	if err != nil || txn.Fee != 5*uint64(len(txn.Encode())) {
+		t.Errorf("Expected the builder to use the estimate, got %v", err)
+	}
+}
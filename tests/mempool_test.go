@@ -0,0 +1,324 @@
+package tests
+
+import (
+	b "gold/blockchain"
+	"gold/mempool"
+	"gold/types"
+	"testing"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+func signedTxn(sk *secp256k1.PrivateKey, reciever *secp256k1.PublicKey, amount uint64, fee uint64, nonce uint32) *b.Txn {
+	txn := &b.Txn{
+		Sender:   b.AddrFromKey(sk.PubKey()),
+		Payments: []b.Payment{{Reciever: b.AddrFromKey(reciever), Amount: amount}},
+		Fee:      fee,
+		Nonce:    nonce,
+	}
+
+	txn.Signature = txn.Sign(sk, chainID)
+	return txn
+}
+
+func TestMempoolNonceQueue(t *testing.T) {
+	state := initState()
+	skMonke, pkMonke := newKeypair()
+	_, pkJeff := newKeypair()
+	initAccount(&state, "GitMonke", &pkMonke, 1000)
+
+	pool := mempool.New(&state, mempool.DefaultConfig())
+
+	if err := pool.Add(signedTxn(&skMonke, &pkJeff, 100, 10, 1)); err == nil {
+		t.Error("Expected an op with a nonce gap to be rejected")
+	}
+
+	for nonce := uint32(0); nonce < 3; nonce++ {
+		if err := pool.Add(signedTxn(&skMonke, &pkJeff, 100, 10, nonce)); err != nil {
+			t.Errorf("Expected op with nonce %d to be accepted, got %v", nonce, err)
+		}
+	}
+
+	// 3 * 110 is pending, so only 670 is left to spend
+	if err := pool.Add(signedTxn(&skMonke, &pkJeff, 670, 1, 3)); err == nil {
+		t.Error("Expected an op spending more than the pending balance to be rejected")
+	}
+
+	if err := pool.Add(signedTxn(&skMonke, &pkJeff, 660, 10, 3)); err != nil {
+		t.Errorf("Expected op spending the rest of the pending balance to be accepted, got %v", err)
+	}
+
+	if pool.Count() != 4 {
+		t.Errorf("Pool has %d ops, wanted %d", pool.Count(), 4)
+	}
+
+	if state.AccountSet[pkMonke].Balance != 1000 || state.AccountSet[pkMonke].Nonce != 0 {
+		t.Error("Adding to the mempool modified the tip state")
+	}
+}
+
+func TestMempoolRanking(t *testing.T) {
+	state := initState()
+	skMonke, pkMonke := newKeypair()
+	skJeff, pkJeff := newKeypair()
+	initAccount(&state, "GitMonke", &pkMonke, 1000)
+	initAccount(&state, "Jeff", &pkJeff, 1000)
+
+	pool := mempool.New(&state, mempool.DefaultConfig())
+	monke0 := signedTxn(&skMonke, &pkJeff, 1, 5, 0)
+	monke1 := signedTxn(&skMonke, &pkJeff, 1, 500, 1)
+	jeff0 := signedTxn(&skJeff, &pkMonke, 1, 50, 0)
+
+	for _, op := range []types.Op{monke0, monke1, jeff0} {
+		if err := pool.Add(op); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ranked := pool.Ranked()
+	wanted := []types.Op{jeff0, monke0, monke1}
+
+	for i := range wanted {
+		if ranked[i] != wanted[i] {
+			t.Errorf("Op %d was ranked incorrectly", i)
+		}
+	}
+}
+
+func TestMempoolEviction(t *testing.T) {
+	state := initState()
+	skMonke, pkMonke := newKeypair()
+	skJeff, pkJeff := newKeypair()
+	initAccount(&state, "GitMonke", &pkMonke, 1000)
+	initAccount(&state, "Jeff", &pkJeff, 1000)
+
+	cheap := signedTxn(&skMonke, &pkJeff, 1, 1, 0)
+	size := len(cheap.Encode())
+	pool := mempool.New(&state, mempool.Config{MaxBytes: size * 2})
+
+	pool.Add(cheap)
+	pool.Add(signedTxn(&skJeff, &pkMonke, 1, 10, 0))
+
+	if err := pool.Add(signedTxn(&skJeff, &pkMonke, 1, 0, 1)); err != mempool.ErrMempoolFull {
+		t.Errorf("Expected the lowest fee op to be refused, got %v", err)
+	}
+
+	if err := pool.Add(signedTxn(&skJeff, &pkMonke, 1, 10, 1)); err != nil {
+		t.Errorf("Expected a higher fee op to be accepted, got %v", err)
+	}
+
+	if pool.Has(b.OpHash(cheap)) {
+		t.Error("Expected the cheapest op to be evicted")
+	}
+
+	if pool.Bytes() > size*2 {
+		t.Errorf("Pool holds %d bytes, over the cap of %d", pool.Bytes(), size*2)
+	}
+}
+
+func TestMempoolReorg(t *testing.T) {
+	state := initState()
+	skMonke, pkMonke := newKeypair()
+	_, pkJeff := newKeypair()
+	initAccount(&state, "GitMonke", &pkMonke, 1000)
+
+	pool := mempool.New(&state, mempool.DefaultConfig())
+	first := signedTxn(&skMonke, &pkJeff, 100, 10, 0)
+	second := signedTxn(&skMonke, &pkJeff, 100, 10, 1)
+	pool.Add(first)
+	pool.Add(second)
+
+	block := types.Block{Operations: []types.Op{b.TemplateCoinbase(&types.Address{Key: &pkMonke}), first}}
+	undo := first.PerformOp(&state)
+	pool.BlockConnected(&block, nil, &state)
+
+	if pool.Has(b.OpHash(first)) || !pool.Has(b.OpHash(second)) {
+		t.Error("Expected only the confirmed op to leave the pool")
+	}
+
+	undo.PerformUndo(&state)
+	pool.BlockDisconnected(&block, nil, &state)
+
+	pending := pool.Pending(&pkMonke)
+
+	if len(pending) != 2 || pending[0] != first || pending[1] != second {
+		t.Error("Expected the disconnected op to be put back in front of the pending op")
+	}
+}
+
+func TestMempoolReplaceByFee(t *testing.T) {
+	state := initState()
+	skMonke, pkMonke := newKeypair()
+	_, pkJeff := newKeypair()
+	initAccount(&state, "GitMonke", &pkMonke, 10_000)
+
+	config := mempool.DefaultConfig()
+	config.MaxReplacements = 2
+	pool := mempool.New(&state, config)
+	events, unsubscribe := pool.Subscribe(10)
+	defer unsubscribe()
+
+	original := signedTxn(&skMonke, &pkJeff, 100, 100, 0)
+	pool.Add(original)
+	pool.Add(signedTxn(&skMonke, &pkJeff, 100, 100, 1))
+	<-events
+	<-events
+
+	if err := pool.Add(signedTxn(&skMonke, &pkJeff, 100, 101, 0)); err != mempool.ErrReplacementFee {
+		t.Errorf("Expected a tiny fee bump to be refused, got %v", err)
+	}
+
+	if err := pool.Add(signedTxn(&skMonke, &pkJeff, 9_600, 300, 0)); err != mempool.ErrReplacementBreaks {
+		t.Errorf("Expected a replacement that overspends the later op to be refused, got %v", err)
+	}
+
+	bumped := signedTxn(&skMonke, &pkJeff, 100, 300, 0)
+
+	if err := pool.Add(bumped); err != nil {
+		t.Fatalf("Expected the replacement to be accepted, got %v", err)
+	}
+
+	event := <-events
+
+	if event.Kind != mempool.EventReplaced || event.Op != bumped || event.Replaced != original {
+		t.Error("Expected a replacement event for the bumped op")
+	}
+
+	if pool.Has(b.OpHash(original)) || pool.Pending(&pkMonke)[0] != bumped || pool.Count() != 2 {
+		t.Error("Expected the bumped op to take the original's place")
+	}
+
+	pool.Add(signedTxn(&skMonke, &pkJeff, 100, 500, 0))
+
+	if err := pool.Add(signedTxn(&skMonke, &pkJeff, 100, 1_000, 0)); err != mempool.ErrReplacementLimit {
+		t.Errorf("Expected the replacement limit to be hit, got %v", err)
+	}
+}
+
+func TestMempoolReplacementThatDoesntFit(t *testing.T) {
+	state := initState()
+	skMonke, pkMonke := newKeypair()
+	skJeff, pkJeff := newKeypair()
+	initAccount(&state, "GitMonke", &pkMonke, 10_000)
+	initAccount(&state, "Jeff", &pkJeff, 10_000)
+
+	original := signedTxn(&skMonke, &pkJeff, 1, 10, 0)
+	size := len(original.Encode())
+	pool := mempool.New(&state, mempool.Config{MaxBytes: size * 2, ReplaceFeeRateBump: 10, MaxReplacements: 5})
+
+	pool.Add(original)
+	pool.Add(signedTxn(&skJeff, &pkMonke, 1, 1_000, 0))
+
+	// Pays more than the original, but with three payments it's too big to fit next to jeff's op
+	bigger := &b.Txn{Sender: b.AddrFromKey(&pkMonke), Fee: 20}
+
+	for range 3 {
+		bigger.Payments = append(bigger.Payments, b.Payment{Reciever: b.AddrFromKey(&pkJeff), Amount: 1})
+	}
+
+	bigger.Signature = bigger.Sign(&skMonke, chainID)
+
+	if err := pool.Add(bigger); err != mempool.ErrMempoolFull {
+		t.Fatalf("Expected the replacement not to fit, got %v", err)
+	}
+
+	if !pool.Has(b.OpHash(original)) || pool.Has(b.OpHash(bigger)) || pool.Count() != 2 || pool.Bytes() != size*2 {
+		t.Errorf("Expected the pool to be left as it was, has %d ops and %d bytes", pool.Count(), pool.Bytes())
+	}
+
+	// The failed replacement wasn't counted, and a smaller one still fits
+	for fee := uint64(20); fee <= 60; fee += 10 {
+		if err := pool.Add(signedTxn(&skMonke, &pkJeff, 1, fee, 0)); err != nil {
+			t.Fatalf("Expected a replacement paying %d to be accepted, got %v", fee, err)
+		}
+	}
+}
+
+// An op too cheap to stay in the pool is refused without costing anyone else their place
+func TestMempoolRefusedOpEvictsNothing(t *testing.T) {
+	state := initState()
+	skMonke, pkMonke := newKeypair()
+	skJeff, pkJeff := newKeypair()
+	skBob, pkBob := newKeypair()
+	initAccount(&state, "GitMonke", &pkMonke, 10_000)
+	initAccount(&state, "Jeff", &pkJeff, 10_000)
+	initAccount(&state, "Bob", &pkBob, 10_000)
+
+	cheap := signedTxn(&skMonke, &pkJeff, 1, 1, 0)
+	size := len(cheap.Encode())
+	pool := mempool.New(&state, mempool.Config{MaxBytes: size * 2})
+
+	pool.Add(cheap)
+	pool.Add(signedTxn(&skJeff, &pkMonke, 1, 1_000, 0))
+
+	// Pays a better rate than monke's op, but is too big to fit even once it's gone
+	bigger := &b.Txn{Sender: b.AddrFromKey(&pkBob), Fee: 20}
+
+	for range 3 {
+		bigger.Payments = append(bigger.Payments, b.Payment{Reciever: b.AddrFromKey(&pkJeff), Amount: 1})
+	}
+
+	bigger.Signature = bigger.Sign(&skBob, chainID)
+
+	if err := pool.Add(bigger); err != mempool.ErrMempoolFull {
+		t.Fatalf("Expected the op not to fit, got %v", err)
+	}
+
+	if !pool.Has(b.OpHash(cheap)) || pool.Has(b.OpHash(bigger)) || pool.Count() != 2 || pool.Bytes() != size*2 {
+		t.Errorf("Expected the pool to be left as it was, has %d ops and %d bytes", pool.Count(), pool.Bytes())
+	}
+
+	if pending := pool.Pending(&pkBob); len(pending) != 0 {
+		t.Errorf("Expected nothing pending for bob, got %d ops", len(pending))
+	}
+}
+
+func TestMempoolPendingRename(t *testing.T) {
+	state := initState()
+	skMonke, pkMonke := newKeypair()
+	skJeff, pkJeff := newKeypair()
+	skBob, pkBob := newKeypair()
+	initAccount(&state, "GitMonke", &pkMonke, 1000)
+	initAccount(&state, "Jeff", &pkJeff, 1000)
+	initAccount(&state, "Bob", &pkBob, 1000)
+
+	pool := mempool.New(&state, mempool.DefaultConfig())
+	give := &b.Rename{Name: "GitMonke", NewKey: &pkJeff, Fee: 10}
+	give.Signature = give.Sign(&skMonke, chainID)
+
+	if err := pool.Add(give); err != nil {
+		t.Fatal(err)
+	}
+
+	// Once the rename is mined GitMonke is jeff's, but at the tip it's still monke's
+	fromName := &b.Txn{Sender: b.AddrFromName("GitMonke"), Payments: []b.Payment{{Reciever: b.AddrFromKey(&pkBob), Amount: 10}}, Fee: 10, Nonce: 1}
+	fromName.Signature = fromName.Sign(&skJeff, chainID)
+	toName := &b.Txn{Sender: b.AddrFromKey(&pkBob), Payments: []b.Payment{{Reciever: b.AddrFromName("GitMonke"), Amount: 10}}, Fee: 10}
+	toName.Signature = toName.Sign(&skBob, chainID)
+	again := &b.Rename{Name: "GitMonke", NewKey: &pkBob, Fee: 10, Nonce: 1}
+	again.Signature = again.Sign(&skMonke, chainID)
+
+	for _, op := range []types.Op{fromName, toName, again} {
+		if err := pool.Add(op); err != mempool.ErrNamePending {
+			t.Errorf("Expected an op using the renamed name to be refused, got %v", err)
+		}
+	}
+
+	// The rename itself can still be replaced
+	bumped := &b.Rename{Name: "GitMonke", NewKey: &pkBob, Fee: 500}
+	bumped.Signature = bumped.Sign(&skMonke, chainID)
+
+	if err := pool.Add(bumped); err != nil {
+		t.Fatalf("Expected the rename to be replaced, got %v", err)
+	}
+
+	// Once it's mined the name resolves to its new owner at the tip
+	bumped.PerformOp(&state)
+	pool.BlockConnected(&types.Block{Operations: []types.Op{bumped}}, nil, &state)
+	toName.Nonce = 0
+	toName.Signature = toName.Sign(&skBob, chainID)
+
+	if err := pool.Add(toName); err != nil {
+		t.Errorf("Expected the name to be usable once the rename is mined, got %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+package tests
+
+import (
+	"context"
+	b "gold/blockchain"
+	"gold/mempool"
+	"gold/miner"
+	"testing"
+	"time"
+)
+
+func TestBlockRewardPenalty(t *testing.T) {
+	median := b.FullRewardZone
+
+	if reward, _ := b.BlockReward(median, median); reward != b.BaseReward {
+		t.Errorf("Block at the median got %d, wanted the full %d", reward, b.BaseReward)
+	}
+
+	if reward, _ := b.BlockReward(median*3/2, median); reward != b.BaseReward*3/4 {
+		t.Errorf("Block at 1.5x the median got %d, wanted %d", reward, b.BaseReward*3/4)
+	}
+
+	if _, err := b.BlockReward(median*2+1, median); err == nil {
+		t.Error("Expected a block over twice the median to be invalid")
+	}
+}
+
+func TestMineChain(t *testing.T) {
+	state := initState()
+	skMonke, pkMonke := newKeypair()
+	_, pkJeff := newKeypair()
+	initAccount(&state, "GitMonke", &pkMonke, 1000)
+
+	pool := mempool.New(&state, mempool.DefaultConfig())
+	m := miner.New(&b.RegTestParams, pool, b.AddrFromName("GitMonke"), 4)
+	prevHash := b.HashBlockHeader(b.GenesisHeader())
+
+	for nonce := uint32(0); nonce < 3; nonce++ {
+		if err := pool.Add(signedTxn(&skMonke, &pkJeff, 100, 10, nonce)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for height := 0; height < 3; height++ {
+		block, err := m.MineBlock(context.Background(), &state, prevHash)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !b.CheckProofOfWork(block.Header, b.RegTestParams.PowTarget) {
+			t.Error("Mined header does not meet the target")
+		}
+
+		if _, err := b.ConnectBlock(block, &state); err != nil {
+			t.Fatalf("Mined block %d did not connect: %v", height, err)
+		}
+
+		pool.BlockConnected(block, nil, &state)
+		prevHash = b.HashBlockHeader(block.Header)
+
+		// Everything pending fits in the first block
+		if height == 0 && len(block.Operations) != 4 {
+			t.Errorf("First block has %d ops, wanted %d", len(block.Operations), 4)
+		}
+	}
+
+	wanted := 1000 - 330 + 3*b.BaseReward + 30
+
+	if state.AccountSet[pkMonke].Balance != wanted || state.AccountSet[pkJeff].Balance != 300 {
+		t.Errorf("GitMonke has %d wanted %d, Jeff has %d wanted %d", state.AccountSet[pkMonke].Balance, wanted, state.AccountSet[pkJeff].Balance, 300)
+	}
+
+	if pool.Count() != 0 || state.Height != 3 {
+		t.Error("Expected every op to be mined into a chain of 3 blocks")
+	}
+}
+
+func TestTemplateKeepsNonceOrder(t *testing.T) {
+	state := initState()
+	skMonke, pkMonke := newKeypair()
+	_, pkJeff := newKeypair()
+	initAccount(&state, "GitMonke", &pkMonke, 1000)
+
+	pool := mempool.New(&state, mempool.DefaultConfig())
+	pool.Add(signedTxn(&skMonke, &pkJeff, 100, 1, 0))
+	pool.Add(signedTxn(&skMonke, &pkJeff, 100, 500, 1))
+
+	coinbaseAddr := b.AddrFromKey(&pkJeff)
+	block := miner.NewBlockTemplate(pool, &state, [32]byte{}, &coinbaseAddr, 1)
+
+	if len(block.Operations) != 3 || block.Operations[1].GetNonce() != 0 || block.Operations[2].GetNonce() != 1 {
+		t.Error("Expected both ops in nonce order despite the higher fee on the second")
+	}
+
+	if err := b.CheckBlock(block, &state); err != nil {
+		t.Errorf("Template is not a valid block: %v", err)
+	}
+
+	if _, ok := block.Operations[0].(*b.Txn); !ok || !b.IsCoinbase(block.Operations[0]) {
+		t.Error("Expected the first op to be the coinbase")
+	}
+}
+
+func TestExtraNonceChangesMerkleRoot(t *testing.T) {
+	state := initState()
+	_, pkMonke := newKeypair()
+	initAccount(&state, "GitMonke", &pkMonke, 0)
+
+	pool := mempool.New(&state, mempool.DefaultConfig())
+	addr := b.AddrFromKey(&pkMonke)
+	block := miner.NewBlockTemplate(pool, &state, [32]byte{}, &addr, 1)
+	root := block.Header.MerkleRoot
+
+	b.SetExtraNonce(block, 1)
+
+	if block.Header.MerkleRoot == root {
+		t.Error("Expected a new extra nonce to change the merkle root")
+	}
+
+	if err := b.CheckBlock(block, &state); err != nil {
+		t.Errorf("Expected a block with an extra nonce to stay valid, got %v", err)
+	}
+}
+
+func TestRollTimestamp(t *testing.T) {
+	state := initState()
+
+	for height, timestamp := range []uint64{100, 300, 200} {
+		state.Timestamps[height] = timestamp
+	}
+
+	state.Height = 3
+
+	if mtp := b.MedianTimePast(&state); mtp != 200 {
+		t.Errorf("Median time past was %d, wanted %d", mtp, 200)
+	}
+
+	if ts := miner.RollTimestamp(&state, 0, time.Unix(150, 0)); ts != 201 {
+		t.Errorf("Expected a clock behind the median time past to be bumped past it, got %d", ts)
+	}
+
+	if ts := miner.RollTimestamp(&state, 500, time.Unix(400, 0)); ts != 500 {
+		t.Errorf("Expected the timestamp never to roll backwards, got %d", ts)
+	}
+
+	if ts := miner.RollTimestamp(&state, 500, time.Unix(600, 0)); ts != 600 {
+		t.Errorf("Expected the timestamp to follow the clock, got %d", ts)
+	}
+}
+
+func TestPartitionedMinersBuildDistinctBlocks(t *testing.T) {
+	state := initState()
+	_, pkMonke := newKeypair()
+	initAccount(&state, "GitMonke", &pkMonke, 0)
+
+	pool := mempool.New(&state, mempool.DefaultConfig())
+	roots := make(map[[32]byte]bool)
+
+	for index := uint32(0); index < 2; index++ {
+		m := miner.New(&b.RegTestParams, pool, b.AddrFromKey(&pkMonke), 2)
+		m.Partition = miner.Partition{Index: index, Count: 2}
+		m.Now = func() time.Time { return time.Unix(1_000, 0) }
+
+		block, err := m.MineBlock(context.Background(), &state, [32]byte{})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		roots[block.Header.MerkleRoot] = true
+	}
+
+	if len(roots) != 2 {
+		t.Error("Expected miners in different partitions to use different extra nonces")
+	}
+}
@@ -0,0 +1,155 @@
+package tests
+
+import (
+	"errors"
+	b "gold/blockchain"
+	"gold/mempool"
+	"gold/musig"
+	"gold/types"
+	"testing"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/schnorr"
+)
+
+// Runs both rounds for every signer and combines the result
+func musigSign(t *testing.T, agg *musig.AggregateKey, signers []*secp256k1.PrivateKey, hash [32]byte) (*schnorr.Signature, error) {
+	sessions := make([]*musig.Session, len(agg.Keys))
+	nonces := make([]musig.PublicNonce, len(agg.Keys))
+
+	for _, sk := range signers {
+		session, err := musig.NewSession(agg, sk, hash)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		i := agg.Index(sk.PubKey())
+		sessions[i] = session
+		nonces[i] = session.PublicNonce()
+	}
+
+	partials := make([]musig.PartialSignature, len(agg.Keys))
+
+	for i, session := range sessions {
+		partial, err := session.Sign(nonces)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		partials[i] = partial
+	}
+
+	return musig.Combine(agg, hash, nonces, partials)
+}
+
+func TestMusig(t *testing.T) {
+	chain := b.NewChain(&b.RegTestParams)
+	state, _ := chain.Snapshot()
+	pool := mempool.New(&state, mempool.DefaultConfig())
+	chain.AddListener(pool)
+
+	var signers []*secp256k1.PrivateKey
+	var keys []*secp256k1.PublicKey
+
+	for range 3 {
+		sk, _ := secp256k1.GeneratePrivateKey()
+		signers = append(signers, sk)
+		keys = append(keys, sk.PubKey())
+	}
+
+	agg, err := musig.AggregateKeys(keys)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reversed, _ := musig.AggregateKeys([]*secp256k1.PublicKey{keys[2], keys[1], keys[0]})
+
+	if !reversed.PublicKey.IsEqual(agg.PublicKey) {
+		t.Errorf("Expected the aggregate not to depend on the order of the keys")
+	}
+
+	if _, err := musig.AggregateKeys([]*secp256k1.PublicKey{keys[0], keys[0]}); !errors.Is(err, musig.ErrDuplicateKey) {
+		t.Errorf("Expected a repeated key to be refused, got %v", err)
+	}
+
+	// The aggregate key is an ordinary account
+	mineOnChain(t, chain, pool, b.AddrFromKey(agg.PublicKey))
+	_, pkJeff := newKeypair()
+
+	txn := &b.Txn{
+		Sender:    b.AddrFromKey(agg.PublicKey),
+		Payments:  []b.Payment{{Reciever: b.AddrFromKey(&pkJeff), Amount: 100}},
+		Fee:       1000,
+		Signature: b.MinimalSignature(),
+	}
+
+	// Enough tries that R comes out with an odd y at least once
+	for range 8 {
+		sig, err := musigSign(t, agg, signers, txn.SigningHash(chainID))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !txn.CheckSig(sig, agg.PublicKey, chainID) {
+			t.Fatal("Expected the combined signature to pass CheckSig")
+		}
+	}
+
+	sig, _ := musigSign(t, agg, signers, txn.SigningHash(chainID))
+	txn.Signature = sig
+
+	if err := pool.Add(txn); err != nil {
+		t.Fatalf("Expected the mempool to take the txn, got %v", err)
+	}
+
+	rename := &b.Rename{Name: "Treasury", NewKey: agg.PublicKey, Fee: 1000, Nonce: 1, Signature: b.MinimalSignature()}
+	rename.Signature, _ = musigSign(t, agg, signers, rename.SigningHash(chainID))
+
+	if err := pool.Add(rename); err != nil {
+		t.Fatalf("Expected the mempool to take the rename, got %v", err)
+	}
+
+	mineOnChain(t, chain, pool, b.AddrFromKey(&pkJeff))
+
+	chain.ReadState(func(state *types.State, tip [32]byte) {
+		if !state.KeyNameSet["Treasury"].IsEqual(agg.PublicKey) {
+			t.Errorf("Expected the aggregate key to own the name")
+		}
+	})
+
+	// A signer that lies about its share is named
+	hash := rename.SigningHash(chainID)
+	sessions := make([]*musig.Session, 3)
+	nonces := make([]musig.PublicNonce, 3)
+	partials := make([]musig.PartialSignature, 3)
+
+	for _, sk := range signers {
+		i := agg.Index(sk.PubKey())
+		sessions[i], _ = musig.NewSession(agg, sk, hash)
+		nonces[i] = sessions[i].PublicNonce()
+	}
+
+	for i, session := range sessions {
+		partials[i], _ = session.Sign(nonces)
+	}
+
+	if _, err := sessions[0].Sign(nonces); err != musig.ErrNonceUsed {
+		t.Errorf("Expected a session to refuse signing twice, got %v", err)
+	}
+
+	partials[1][31] ^= 1
+
+	if _, err := musig.Combine(agg, hash, nonces, partials); !errors.Is(err, musig.ErrInvalidPartial) || err.Error() != "partial signature is invalid: signer 1" {
+		t.Errorf("Expected signer 1's partial signature to be refused, got %v", err)
+	}
+
+	outsider, _ := secp256k1.GeneratePrivateKey()
+
+	if _, err := musig.NewSession(agg, outsider, hash); err != musig.ErrNotSigner {
+		t.Errorf("Expected a key outside the aggregate to be refused, got %v", err)
+	}
+}
@@ -1,9 +1,11 @@
 package tests
 
 import (
+	"errors"
 	"gold/blockchain"
 	b "gold/blockchain"
 	"gold/types"
+	"strings"
 	"testing"
 
 	"github.com/decred/dcrd/dcrec/secp256k1/v4"
@@ -22,6 +24,9 @@ func initAccount(state *types.State, name string, key *secp256k1.PublicKey, bala
 	state.KeyNameSet[name] = key
 }
 
+// Every test signs for regtest, the network its chains run on
+var chainID = b.RegTestParams.ChainID
+
 func initState() types.State {
 	return types.State{
 		AccountSet: make(types.AccountSet),
@@ -29,6 +34,7 @@ func initState() types.State {
 		BlockSizes: [100]int{},
 		Timestamps: [720]uint64{},
 		Height:     0,
+		ChainID:    chainID,
 	}
 }
 
@@ -149,6 +155,60 @@ func TestUndoRename(t *testing.T) {
 	}
 }
 
+func TestTxnFeeAndNonceOncePerTxn(t *testing.T) {
+	state := initState()
+	_, pubKeyMonke := newKeypair()
+	_, pubKeyJeff := newKeypair()
+	_, pubKeyBob := newKeypair()
+	initAccount(&state, "GitMonke", &pubKeyMonke, 1000)
+
+	txn := b.Txn{
+		Sender:    b.AddrFromName("GitMonke"),
+		Payments:  []b.Payment{{Reciever: b.AddrFromKey(&pubKeyJeff), Amount: 100}, {Reciever: b.AddrFromKey(&pubKeyBob), Amount: 200}, {Reciever: b.AddrFromKey(&pubKeyJeff), Amount: 50}},
+		Fee:       10,
+		Nonce:     0,
+		Signature: b.MinimalSignature(),
+	}
+
+	undo := txn.PerformOp(&state)
+
+	// However many payments there are, the fee is paid and the nonce used once
+	if monke := state.AccountSet[pubKeyMonke]; monke.Balance != 1000-350-10 || monke.Nonce != 1 {
+		t.Errorf("GitMonke has balance %d and nonce %d, wanted %d and %d", monke.Balance, monke.Nonce, 1000-350-10, 1)
+	}
+
+	if state.AccountSet[pubKeyJeff].Balance != 150 || state.AccountSet[pubKeyBob].Balance != 200 {
+		t.Errorf("Recievers have %d and %d, wanted %d and %d", state.AccountSet[pubKeyJeff].Balance, state.AccountSet[pubKeyBob].Balance, 150, 200)
+	}
+
+	undo.PerformUndo(&state)
+
+	if monke := state.AccountSet[pubKeyMonke]; monke.Balance != 1000 || monke.Nonce != 0 || len(state.AccountSet) != 1 {
+		t.Errorf("Expected the undo to restore the sender and remove the recievers, got %+v and %d accounts", monke, len(state.AccountSet))
+	}
+}
+
+func TestRenameNonce(t *testing.T) {
+	state, rename, monkePrivKey, monkePubKey := createValidRename()
+	rename.Nonce = 1
+	rename.Signature = rename.Sign(&monkePrivKey, chainID)
+
+	if err := rename.Validate(&state); err == nil || err.Error() != "rename uses the wrong nonce" {
+		t.Errorf("Expected a rename ahead of the owner's nonce to be refused, got %v", err)
+	}
+
+	rename.Nonce = 0
+	rename.Signature = rename.Sign(&monkePrivKey, chainID)
+	rename.PerformOp(&state)
+
+	// Monke doesn't own the name anymore, but the same rename can't be replayed after the name comes back
+	state.KeyNameSet["GitMonke"] = &monkePubKey
+
+	if err := rename.Validate(&state); err == nil || err.Error() != "rename uses the wrong nonce" {
+		t.Errorf("Expected a replayed rename to be refused, got %v", err)
+	}
+}
+
 func TestNewName(t *testing.T) {
 	state := initState()
 	_, pubKeyMonke := newKeypair()
@@ -201,7 +261,7 @@ func TestValidateTxn(t *testing.T) {
 func TestInvalidTxns(t *testing.T) {
 	state, txn, sk := createValidTxn()
 	txn.Fee = 100_000_000_001
-	txn.Signature = txn.Sign(&sk)
+	txn.Signature = txn.Sign(&sk, chainID)
 	error := txn.Validate(&state)
 
 	if !(error != nil && error.Error() == "txn sends more than senders balance") {
@@ -226,7 +286,7 @@ func TestInvalidTxns(t *testing.T) {
 
 	state, txn, sk = createValidTxn()
 	txn.Nonce = 1
-	txn.Signature = txn.Sign(&sk)
+	txn.Signature = txn.Sign(&sk, chainID)
 	error = txn.Validate(&state)
 
 	if !(error != nil && error.Error() == "txn uses the wrong nonce") {
@@ -247,7 +307,7 @@ func createValidTxn() (types.State, b.Txn, secp256k1.PrivateKey) {
 		Signature: b.MinimalSignature(),
 	}
 
-	txn.Signature = txn.Sign(&privKeyMonke)
+	txn.Signature = txn.Sign(&privKeyMonke, chainID)
 
 	return state, txn, privKeyMonke
 }
@@ -265,7 +325,7 @@ func createValidRename() (types.State, b.Rename, secp256k1.PrivateKey, secp256k1
 		Nonce:  0,
 	}
 
-	txn.Signature = txn.Sign(&privKeyMonke)
+	txn.Signature = txn.Sign(&privKeyMonke, chainID)
 
 	return state, txn, privKeyMonke, pubKeyMonke
 }
@@ -280,7 +340,7 @@ func TestValidRename(t *testing.T) {
 	state, rename, monkePrivKey, monkePubKey = createValidRename()
 	delete(state.KeyNameSet, "GitMonke")
 	rename.NewKey = &monkePubKey
-	rename.Signature = rename.Sign(&monkePrivKey)
+	rename.Signature = rename.Sign(&monkePrivKey, chainID)
 	error = rename.Validate(&state)
 	if error != nil {
 		t.Errorf("Expected no error, got %v", error)
@@ -291,7 +351,7 @@ func TestInvalidRenames(t *testing.T) {
 	// Check liable parties exist and have the right amount
 	state, rename, monkePrivKey, monkePubKey := createValidRename()
 	delete(state.KeyNameSet, "GitMonke")
-	rename.Signature = rename.Sign(&monkePrivKey)
+	rename.Signature = rename.Sign(&monkePrivKey, chainID)
 	error := rename.Validate(&state)
 
 	if !(error != nil && error.Error() == "The liable key-holder is not in the account set") {
@@ -300,7 +360,7 @@ func TestInvalidRenames(t *testing.T) {
 
 	state, rename, monkePrivKey, monkePubKey = createValidRename()
 	delete(state.AccountSet, monkePubKey)
-	rename.Signature = rename.Sign(&monkePrivKey)
+	rename.Signature = rename.Sign(&monkePrivKey, chainID)
 	error = rename.Validate(&state)
 
 	if !(error != nil && error.Error() == "The liable key-holder is not in the account set") {
@@ -310,7 +370,7 @@ func TestInvalidRenames(t *testing.T) {
 	// Check liable parties exist and have the right amount
 	state, rename, monkePrivKey, monkePubKey = createValidRename()
 	state.AccountSet[monkePubKey].Balance = 50_000_000
-	rename.Signature = rename.Sign(&monkePrivKey)
+	rename.Signature = rename.Sign(&monkePrivKey, chainID)
 	error = rename.Validate(&state)
 
 	if !(error != nil && error.Error() == "The liable key-holder cannot pay the fee") {
@@ -321,7 +381,7 @@ func TestInvalidRenames(t *testing.T) {
 	delete(state.KeyNameSet, "GitMonke")
 	rename.NewKey = &monkePubKey
 	state.AccountSet[monkePubKey].Balance = 50_000_000
-	rename.Signature = rename.Sign(&monkePrivKey)
+	rename.Signature = rename.Sign(&monkePrivKey, chainID)
 	error = rename.Validate(&state)
 
 	if !(error != nil && error.Error() == "The liable key-holder cannot pay the fee") {
@@ -336,6 +396,65 @@ func TestInvalidRenames(t *testing.T) {
 	}
 }
 
+// Names and payment counts are encoded in a single byte, so anything that doesn't fit is invalid
+func TestOpsTooLongToEncode(t *testing.T) {
+	for _, name := range []string{"", strings.Repeat("a", types.MaxNameLength+1)} {
+		state, rename, monkePrivKey, _ := createValidRename()
+		rename.Name = name
+		rename.Signature = rename.Sign(&monkePrivKey, chainID)
+
+		if err := rename.Validate(&state); !errors.Is(err, b.ErrNameLength) {
+			t.Errorf("Expected a rename of %d byte name to be invalid, got %v", len(name), err)
+		}
+
+		state, txn, sk := createValidTxn()
+		txn.Payments[0].Reciever = b.AddrFromName(name)
+		txn.Signature = txn.Sign(&sk, chainID)
+
+		if err := txn.Validate(&state); !errors.Is(err, b.ErrNameLength) {
+			t.Errorf("Expected a payment to a %d byte name to be invalid, got %v", len(name), err)
+		}
+
+		state, txn, sk = createValidTxn()
+		state.KeyNameSet[name] = state.KeyNameSet["GitMonke"]
+		txn.Sender = b.AddrFromName(name)
+		txn.Signature = txn.Sign(&sk, chainID)
+
+		if err := txn.Validate(&state); !errors.Is(err, b.ErrNameLength) {
+			t.Errorf("Expected a txn from a %d byte name to be invalid, got %v", len(name), err)
+		}
+	}
+
+	state, rename, monkePrivKey, monkePubKey := createValidRename()
+	rename.Name = strings.Repeat("a", types.MaxNameLength)
+	rename.NewKey = &monkePubKey
+	rename.Signature = rename.Sign(&monkePrivKey, chainID)
+
+	if err := rename.Validate(&state); err != nil {
+		t.Errorf("Expected a name of the max length to be valid, got %v", err)
+	}
+
+	state, txn, sk := createValidTxn()
+	txn.Payments[0].Amount = 1
+
+	for len(txn.Payments) < b.MaxPayments {
+		txn.Payments = append(txn.Payments, txn.Payments[0])
+	}
+
+	txn.Signature = txn.Sign(&sk, chainID)
+
+	if err := txn.Validate(&state); err != nil {
+		t.Errorf("Expected %d payments to be valid, got %v", b.MaxPayments, err)
+	}
+
+	txn.Payments = append(txn.Payments, txn.Payments[0])
+	txn.Signature = txn.Sign(&sk, chainID)
+
+	if err := txn.Validate(&state); !errors.Is(err, b.ErrTooManyPayments) {
+		t.Errorf("Expected %d payments to be invalid, got %v", len(txn.Payments), err)
+	}
+}
+
 func TestValidation(t *testing.T) {
 	state := types.State{
 		AccountSet: make(types.AccountSet),
@@ -343,6 +462,7 @@ func TestValidation(t *testing.T) {
 		BlockSizes: [100]int{},
 		Timestamps: [720]uint64{},
 		Height:     0,
+		ChainID:    chainID,
 	}
 
 	privKeyMonke, pubKeyMonke := newKeypair()
@@ -354,16 +474,20 @@ func TestValidation(t *testing.T) {
 	monkeAddr := b.AddrFromName("GitMonke")
 	jeffAddr := b.AddrFromName("Jeff")
 
+	// The rename uses up GitMonke's first nonce, and "GitMonke" resolves to Jeff after it, so pay from the key
+	txn := b.NewTxn(b.AddrFromKey(&pubKeyMonke), &privKeyMonke, &jeffAddr, 200_000_000_000, 0, chainID)
+	txn.Nonce = 1
+	txn.Signature = txn.Sign(&privKeyMonke, chainID)
+
 	// Once these operations are performed, GitMonke should have 200_000_000_000 (from the coinbase), Jeff should have 200_000_000_000, and Jeff should own the "GitMonke" name
 	ops := []types.Op{
 		b.TemplateCoinbase(&monkeAddr),
-		b.NewRename("GitMonke", &privKeyMonke, &pubKeyJeff),
-		b.NewTxn(&monkeAddr, privKeyMonke, &jeffAddr, 200_000_000_000),
+		b.NewRename("GitMonke", &privKeyMonke, &pubKeyJeff, chainID),
+		txn,
 	}
 
 	header := types.Header{
 		PrevBlockHash: b.HashBlockHeader(blockchain.GenesisHeader()),
-		MerkleRoot:    b.CalculateMerkleRoot(ops),
 		Timestamp:     1,
 		Nonce:         0,
 	}
@@ -373,9 +497,34 @@ func TestValidation(t *testing.T) {
 		Operations: ops,
 	}
 
-	block.Operations[0] = b.Coinbase(&monkeAddr, &block)
+	block.Operations[0] = b.Coinbase(&monkeAddr, &block, &state)
+	block.Header.MerkleRoot = b.CalculateMerkleRoot(block.Operations)
 
 	if b.ValidateBlock(&block, &state) != true {
 		t.Error("Block did not validate properly")
 	}
+
+	undo, err := b.ConnectBlock(&block, &state)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if state.AccountSet[pubKeyMonke].Balance != 200_000_000_000 || state.AccountSet[pubKeyJeff].Balance != 200_000_000_000 {
+		t.Errorf("Balances were incorrect, GitMonke has %d and Jeff has %d", state.AccountSet[pubKeyMonke].Balance, state.AccountSet[pubKeyJeff].Balance)
+	}
+
+	if *state.KeyNameSet["GitMonke"] != pubKeyJeff {
+		t.Error("Name was not transferred to Jeff's public key")
+	}
+
+	b.DisconnectBlock(&block, undo, &state)
+
+	if state.AccountSet[pubKeyMonke].Balance != 200_000_000_000 || state.AccountSet[pubKeyJeff].Balance != 0 || state.Height != 0 {
+		t.Error("Disconnecting the block did not restore the state")
+	}
+
+	if *state.KeyNameSet["GitMonke"] != pubKeyMonke {
+		t.Error("Name was not moved back to GitMonke's public key")
+	}
 }
@@ -0,0 +1,774 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	b "gold/blockchain"
+	"gold/mempool"
+	"gold/miner"
+	"gold/p2p"
+	"gold/types"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+type testNode struct {
+	chain *b.Chain
+	pool  *mempool.Pool
+	node  *p2p.Node
+}
+
+func newTestNode(t *testing.T, params *b.Params) *testNode {
+	return newConfiguredNode(t, params, nil)
+}
+
+// Same as newTestNode, with configure getting to change the config first if it isn't nil
+func newConfiguredNode(t *testing.T, params *b.Params, configure func(config *p2p.Config)) *testNode {
+	chain := b.NewChain(params)
+	state, _ := chain.Snapshot()
+	pool := mempool.New(&state, mempool.DefaultConfig())
+	chain.AddListener(pool)
+
+	config := p2p.DefaultConfig(params)
+	config.ListenAddr = "127.0.0.1:0"
+	// Tests connect nodes by hand unless they're testing discovery
+	config.TargetOutbound = 0
+
+	if configure != nil {
+		configure(&config)
+	}
+
+	node, err := p2p.NewNode(config, chain, pool)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := node.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(node.Stop)
+
+	return &testNode{chain: chain, pool: pool, node: node}
+}
+
+// Mines a block on top of the node's tip and hands it to the node's chain
+func (n *testNode) mine(t *testing.T, coinbaseAddr types.Address) *types.Block {
+	state, tip := n.chain.Snapshot()
+	m := miner.New(n.chain.Params(), n.pool, coinbaseAddr, 2)
+	block, err := m.MineBlock(context.Background(), &state, tip)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := n.chain.ProcessBlock(block); err != nil {
+		t.Fatal(err)
+	}
+
+	return block
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Connects the nodes in a line, so everything has to be relayed to get from one end to the other
+func connectLine(t *testing.T, nodes []*testNode) {
+	for i := 1; i < len(nodes); i++ {
+		if _, err := nodes[i].node.Connect(nodes[i-1].node.Addr().String()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBlocksAndOpsRelay(t *testing.T) {
+	nodes := make([]*testNode, 5)
+
+	for i := range nodes {
+		nodes[i] = newTestNode(t, &b.RegTestParams)
+	}
+
+	connectLine(t, nodes)
+
+	skMonke, pkMonke := newKeypair()
+	_, pkJeff := newKeypair()
+
+	for i := 0; i < 3; i++ {
+		nodes[0].mine(t, b.AddrFromKey(&pkMonke))
+	}
+
+	tip, _ := nodes[0].chain.Tip()
+
+	for i, n := range nodes {
+		waitFor(t, "the blocks to reach every node", func() bool {
+			nodeTip, _ := n.chain.Tip()
+			return nodeTip == tip
+		})
+
+		if _, height := n.chain.Tip(); height != 3 {
+			t.Errorf("Node %d is at height %d, wanted %d", i, height, 3)
+		}
+	}
+
+	txn := signedTxn(&skMonke, &pkJeff, 100, 10, 0)
+
+	if err := nodes[4].pool.Add(txn); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "the op to reach the first node", func() bool {
+		return nodes[0].pool.Has(b.OpHash(txn))
+	})
+
+	block := nodes[0].mine(t, b.AddrFromKey(&pkMonke))
+
+	if len(block.Operations) != 2 {
+		t.Errorf("Mined block has %d ops, wanted %d", len(block.Operations), 2)
+	}
+
+	waitFor(t, "the op to leave every mempool", func() bool {
+		return nodes[4].pool.Count() == 0
+	})
+}
+
+func TestLateJoinerCatchesUp(t *testing.T) {
+	miner := newTestNode(t, &b.RegTestParams)
+	_, pkMonke := newKeypair()
+
+	for i := 0; i < 5; i++ {
+		miner.mine(t, b.AddrFromKey(&pkMonke))
+	}
+
+	joiner := newTestNode(t, &b.RegTestParams)
+
+	if _, err := joiner.node.Connect(miner.node.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "the joiner to catch up", func() bool {
+		_, height := joiner.chain.Tip()
+		return height == 5
+	})
+}
+
+func TestHandshakeRejectsOtherChains(t *testing.T) {
+	node := newTestNode(t, &b.RegTestParams)
+	otherParams := b.RegTestParams
+	otherParams.ChainID += 1
+	other := newTestNode(t, &otherParams)
+
+	if _, err := other.node.Connect(node.node.Addr().String()); err == nil {
+		t.Error("Expected a node on another chain ID to be refused")
+	}
+
+	if _, err := node.node.Connect(node.node.Addr().String()); err == nil {
+		t.Error("Expected a node connecting to itself to be refused")
+	}
+}
+
+func TestGracefulDisconnect(t *testing.T) {
+	first := newTestNode(t, &b.RegTestParams)
+	second := newTestNode(t, &b.RegTestParams)
+
+	peer, err := second.node.Connect(first.node.Addr().String())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "both sides to see the connection", func() bool {
+		return len(first.node.Peers()) == 1 && len(second.node.Peers()) == 1
+	})
+
+	peer.Disconnect("testing")
+
+	waitFor(t, "both sides to drop the connection", func() bool {
+		return len(first.node.Peers()) == 0 && len(second.node.Peers()) == 0
+	})
+}
+
+func TestPingPong(t *testing.T) {
+	params := b.RegTestParams
+	chain := b.NewChain(&params)
+	state, _ := chain.Snapshot()
+	pool := mempool.New(&state, mempool.DefaultConfig())
+
+	config := p2p.DefaultConfig(&params)
+	config.ListenAddr = "127.0.0.1:0"
+	config.PingInterval = 20 * time.Millisecond
+	config.TargetOutbound = 0
+	pinger, err := p2p.NewNode(config, chain, pool)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pinger.Start()
+	defer pinger.Stop()
+
+	other := newTestNode(t, &params)
+
+	if _, err := other.node.Connect(pinger.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Several pings go by, and each has to be answered before the next or the peer gets dropped
+	time.Sleep(200 * time.Millisecond)
+
+	if len(pinger.Peers()) != 1 {
+		t.Error("Expected the peer to stay connected by answering pings")
+	}
+}
+
+func TestHeadersFirstSyncFromManyPeers(t *testing.T) {
+	_, pkMonke := newKeypair()
+	seeders := make([]*testNode, 3)
+
+	for i := range seeders {
+		seeders[i] = newTestNode(t, &b.RegTestParams)
+	}
+
+	// Every seeder has the same chain, handed over directly so they don't need to sync from each other
+	for i := 0; i < 40; i++ {
+		block := seeders[0].mine(t, b.AddrFromKey(&pkMonke))
+
+		for _, seeder := range seeders[1:] {
+			if err := seeder.chain.ProcessBlock(block); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	tip, _ := seeders[0].chain.Tip()
+
+	joiner := newTestNode(t, &b.RegTestParams)
+
+	for _, seeder := range seeders {
+		if _, err := joiner.node.Connect(seeder.node.Addr().String()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitFor(t, "the joiner to sync", func() bool {
+		joinerTip, _ := joiner.chain.Tip()
+		return joinerTip == tip
+	})
+
+	progress := joiner.node.SyncProgress()
+
+	if !progress.Synced() || progress.HeaderHeight != 40 || progress.Peers != 3 {
+		t.Errorf("Unexpected sync progress after syncing: %+v", progress)
+	}
+}
+
+// Connects to the node over plain TCP and does the handshake by hand, so the test can send whatever it likes
+func dialRawPeer(t *testing.T, n *testNode) net.Conn {
+	conn, err := net.Dial("tcp", n.node.Addr().String())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+
+	params := n.chain.Params()
+	version := &p2p.MsgVersion{Version: p2p.ProtocolVersion, ChainID: params.ChainID, GenesisHash: params.GenesisHash(), Nonce: 1}
+
+	for _, msg := range []p2p.Message{version, &p2p.MsgVerAck{}} {
+		if err := p2p.WriteMessage(conn, msg); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := p2p.ReadMessage(conn, b.FullRewardZone); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return conn
+}
+
+// Pings the node and waits for the pong. Messages are handled in order, so once it's back everything
+// sent before the ping has been handled.
+func pingRawPeer(t *testing.T, conn net.Conn, nonce uint64) {
+	if err := p2p.WriteMessage(conn, &p2p.MsgPing{Nonce: nonce}); err != nil {
+		t.Fatal(err)
+	}
+
+	for {
+		msg, err := p2p.ReadMessage(conn, b.FullRewardZone)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if pong, ok := msg.(*p2p.MsgPong); ok && pong.Nonce == nonce {
+			return
+		}
+	}
+}
+
+func TestBadSignatureGetsPeerBanned(t *testing.T) {
+	banFile := filepath.Join(t.TempDir(), "bans.json")
+	configure := func(config *p2p.Config) { config.BanFile = banFile }
+	node := newConfiguredNode(t, &b.RegTestParams, configure)
+
+	skMonke, pkMonke := newKeypair()
+	skJeff, pkJeff := newKeypair()
+	node.mine(t, b.AddrFromKey(&pkMonke))
+
+	conn := dialRawPeer(t, node)
+
+	waitFor(t, "the raw peer to be added", func() bool {
+		return len(node.node.Peers()) == 1
+	})
+
+	sendForged := func(amount uint64) {
+		txn := signedTxn(&skMonke, &pkJeff, amount, 10, 0)
+		txn.Signature = txn.Sign(&skJeff, chainID)
+
+		if err := p2p.WriteMessage(conn, &p2p.MsgOp{Op: txn}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// It takes a second bad signature to get banned
+	sendForged(100)
+	pingRawPeer(t, conn, 1)
+
+	if node.node.Banned("127.0.0.1") {
+		t.Fatal("Expected one bad signature not to be enough for a ban")
+	}
+
+	sendForged(200)
+
+	waitFor(t, "the peer to be banned", func() bool {
+		return node.node.Banned("127.0.0.1") && len(node.node.Peers()) == 0
+	})
+
+	// Banned hosts are hung up on before the handshake
+	other := newTestNode(t, &b.RegTestParams)
+
+	if _, err := other.node.Connect(node.node.Addr().String()); err == nil {
+		t.Error("Expected a banned host to be refused")
+	}
+
+	// The ban survives a restart
+	restarted := newConfiguredNode(t, &b.RegTestParams, configure)
+
+	if !restarted.node.Banned("127.0.0.1") {
+		t.Error("Expected the ban to be loaded from the ban file")
+	}
+
+	if err := restarted.node.Unban("127.0.0.1"); err != nil || restarted.node.Banned("127.0.0.1") {
+		t.Error("Expected unbanning to lift the ban")
+	}
+}
+
+func TestBadCosignaturesGetPeerBanned(t *testing.T) {
+	skMonke, pkMonke := newKeypair()
+	_, pkJeff := newKeypair()
+
+	var signers []*secp256k1.PrivateKey
+	var keys []*secp256k1.PublicKey
+
+	for range 2 {
+		sk, _ := secp256k1.GeneratePrivateKey()
+		signers = append(signers, sk)
+		keys = append(keys, sk.PubKey())
+	}
+
+	policy, _ := b.NewPolicy(2, keys)
+	policyKey := b.PolicyKey(policy)
+
+	spend := func(cosigners ...*secp256k1.PrivateKey) types.Op {
+		txn := &b.Txn{Sender: b.AddrFromKey(policyKey), Payments: []b.Payment{{Reciever: b.AddrFromKey(&pkJeff), Amount: 100}}, Fee: 10, Signature: b.MinimalSignature()}
+		txn.Cosignatures = cosign(policy, txn.SigningHash(chainID), cosigners...)
+		return txn
+	}
+
+	outsider, _ := secp256k1.GeneratePrivateKey()
+	forged := spend(signers[0]).(*b.Txn)
+	forged.Cosignatures = append(forged.Cosignatures, b.Cosignature{Index: 1, Signature: forged.Sign(outsider, chainID)})
+
+	_, other := newKeypair()
+	badRegister := &b.RegisterPolicy{Policy: types.Policy{Threshold: 1, Keys: []*secp256k1.PublicKey{&other}}, Funder: &pkMonke, Fee: 10, Nonce: 2}
+	badRegister.Signature = badRegister.Sign(outsider, chainID)
+
+	for name, op := range map[string]types.Op{"threshold not met": spend(signers[0]), "invalid cosignature": forged, "bad registration": badRegister} {
+		t.Run(name, func(t *testing.T) {
+			node := newTestNode(t, &b.RegTestParams)
+			node.mine(t, b.AddrFromKey(&pkMonke))
+
+			register := &b.RegisterPolicy{Policy: *policy, Funder: &pkMonke, Fee: 10}
+			register.Signature = register.Sign(&skMonke, chainID)
+
+			for _, op := range []types.Op{register, signedTxn(&skMonke, policyKey, 1000, 10, 1)} {
+				if err := node.pool.Add(op); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			node.mine(t, b.AddrFromKey(&pkMonke))
+			conn := dialRawPeer(t, node)
+
+			waitFor(t, "the raw peer to be added", func() bool {
+				return len(node.node.Peers()) == 1
+			})
+
+			for range 2 {
+				if err := p2p.WriteMessage(conn, &p2p.MsgOp{Op: op}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			waitFor(t, "the peer to be banned", func() bool {
+				return node.node.Banned("127.0.0.1")
+			})
+		})
+	}
+}
+
+func TestOversizedMessageGetsPeerDropped(t *testing.T) {
+	node := newTestNode(t, &b.RegTestParams)
+	conn := dialRawPeer(t, node)
+
+	// A ping claiming a payload far bigger than a nonce
+	frame := []byte{byte(p2p.CmdPing), 0, 0, 1, 0}
+
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "the peer to be dropped", func() bool {
+		return len(node.node.Peers()) == 0
+	})
+
+	if node.node.Banned("127.0.0.1") {
+		t.Error("Expected one malformed message to cost less than a ban")
+	}
+}
+
+func TestMisbehaviorOutlivesTheConnection(t *testing.T) {
+	node := newTestNode(t, &b.RegTestParams)
+
+	// Every oversized message ends the connection, but the score is kept for the host
+	for i := range 4 {
+		if node.node.Banned("127.0.0.1") {
+			t.Fatalf("Expected %d malformed messages not to be enough for a ban", i)
+		}
+
+		conn := dialRawPeer(t, node)
+
+		if _, err := conn.Write([]byte{byte(p2p.CmdPing), 0, 0, 1, 0}); err != nil {
+			t.Fatal(err)
+		}
+
+		waitFor(t, "the peer to be dropped", func() bool {
+			return len(node.node.Peers()) == 0
+		})
+	}
+
+	waitFor(t, "the host to be banned", func() bool {
+		return node.node.Banned("127.0.0.1")
+	})
+}
+
+func TestRepeatedMalformedMessagesGetPeerBanned(t *testing.T) {
+	node := newTestNode(t, &b.RegTestParams)
+	_, pkMonke := newKeypair()
+	block := node.mine(t, b.AddrFromKey(&pkMonke))
+	conn := dialRawPeer(t, node)
+
+	// Asking for an op past the end of a block is malformed, but doesn't end the connection
+	ask := &p2p.MsgGetBlockOps{BlockHash: b.HashBlockHeader(block.Header), Indexes: []uint32{5}}
+
+	for nonce := range uint64(3) {
+		if err := p2p.WriteMessage(conn, ask); err != nil {
+			t.Fatal(err)
+		}
+
+		pingRawPeer(t, conn, nonce)
+	}
+
+	if node.node.Banned("127.0.0.1") {
+		t.Fatal("Expected three malformed messages not to be enough for a ban")
+	}
+
+	if err := p2p.WriteMessage(conn, ask); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "the peer to be banned", func() bool {
+		return node.node.Banned("127.0.0.1")
+	})
+}
+
+func TestOpRelayIsRateLimited(t *testing.T) {
+	node := newConfiguredNode(t, &b.RegTestParams, func(config *p2p.Config) {
+		config.OpRateLimit = 0.001
+		config.OpRateBurst = 2
+	})
+
+	skMonke, pkMonke := newKeypair()
+	_, pkJeff := newKeypair()
+	node.mine(t, b.AddrFromKey(&pkMonke))
+
+	conn := dialRawPeer(t, node)
+
+	for nonce := uint32(0); nonce < 5; nonce++ {
+		if err := p2p.WriteMessage(conn, &p2p.MsgOp{Op: signedTxn(&skMonke, &pkJeff, 100, 10, nonce)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Once the pong is back every op has been seen
+	pingRawPeer(t, conn, 7)
+
+	if count := node.pool.Count(); count != 2 {
+		t.Errorf("Pool has %d ops, wanted %d", count, 2)
+	}
+
+	if node.node.Banned("127.0.0.1") {
+		t.Error("Going over the op rate limit shouldn't get a peer banned")
+	}
+}
+
+func TestAddrBookLimitsOneNetwork(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "addrs.json")
+	book, err := p2p.LoadAddrBook(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A thousand addresses in one /16 from one source all land in the same bucket
+	flood := make([]string, 0, 1000)
+
+	for i := 0; i < 1000; i++ {
+		flood = append(flood, fmt.Sprintf("10.1.%d.%d:8333", i/250, i%250+1))
+	}
+
+	book.Add(flood, "10.1.0.1:8333", time.Now())
+
+	if newCount, _ := book.Size(); newCount > 64 {
+		t.Errorf("One network got %d addresses into the book, wanted at most %d", newCount, 64)
+	}
+
+	// Addresses from other networks still get in
+	diverse := make([]string, 0, 100)
+
+	for i := 0; i < 100; i++ {
+		diverse = append(diverse, fmt.Sprintf("%d.%d.1.1:8333", 20+i, i))
+	}
+
+	book.Add(diverse, "192.168.0.1:8333", time.Now())
+	book.Good(diverse[0])
+
+	newCount, triedCount := book.Size()
+
+	if triedCount != 1 || newCount < 100 {
+		t.Errorf("Book has %d new and %d tried addresses after adding diverse ones", newCount, triedCount)
+	}
+
+	if err := book.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := p2p.LoadAddrBook(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reloadedNew, reloadedTried := reloaded.Size(); reloadedNew != newCount || reloadedTried != triedCount {
+		t.Errorf("Reloaded book has %d new and %d tried, wanted %d and %d", reloadedNew, reloadedTried, newCount, triedCount)
+	}
+}
+
+func TestPeerDiscovery(t *testing.T) {
+	dir := t.TempDir()
+	first := newTestNode(t, &b.RegTestParams)
+	second := newTestNode(t, &b.RegTestParams)
+
+	if _, err := second.node.Connect(first.node.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+
+	seedFile := filepath.Join(dir, "seeds.txt")
+	seeds := "# Seed peers\n\n" + first.node.Addr().String() + "\n"
+
+	if err := os.WriteFile(seedFile, []byte(seeds), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	addrBookFile := filepath.Join(dir, "addrs.json")
+	joiner := newConfiguredNode(t, &b.RegTestParams, func(config *p2p.Config) {
+		config.SeedFile = seedFile
+		config.AddrBookFile = addrBookFile
+		config.TargetOutbound = 8
+	})
+
+	// The joiner only knows the seed, and hears about the second node from it
+	waitFor(t, "the joiner to find both nodes", func() bool {
+		return len(joiner.node.Peers()) == 2
+	})
+
+	joiner.node.Stop()
+
+	restarted := newConfiguredNode(t, &b.RegTestParams, func(config *p2p.Config) {
+		config.AddrBookFile = addrBookFile
+		config.TargetOutbound = 8
+	})
+
+	waitFor(t, "the restarted node to reconnect from its address book", func() bool {
+		return len(restarted.node.Peers()) == 2
+	})
+}
+
+func TestCompactBlockRelay(t *testing.T) {
+	miner := newTestNode(t, &b.RegTestParams)
+	follower := newTestNode(t, &b.RegTestParams)
+	skMonke, pkMonke := newKeypair()
+	_, pkJeff := newKeypair()
+
+	miner.mine(t, b.AddrFromKey(&pkMonke))
+
+	// Only the miner has this op, since the follower isn't connected to hear about it
+	if err := miner.pool.Add(signedTxn(&skMonke, &pkJeff, 100, 10, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := follower.node.Connect(miner.node.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "the follower to sync", func() bool {
+		_, height := follower.chain.Tip()
+		return height == 1
+	})
+
+	miner.mine(t, b.AddrFromKey(&pkMonke))
+
+	waitFor(t, "the block with the unknown op to arrive", func() bool {
+		_, height := follower.chain.Tip()
+		return height == 2
+	})
+
+	if stats := follower.node.CompactBlockStats(); stats.OpsRequested != 1 || stats.Reconstructed != 1 {
+		t.Errorf("Expected one op to be asked for to rebuild the block, got %+v", stats)
+	}
+
+	// This time the follower hears about the op first, so nothing needs asking for
+	txn := signedTxn(&skMonke, &pkJeff, 100, 10, 1)
+
+	if err := miner.pool.Add(txn); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "the op to reach the follower", func() bool {
+		return follower.pool.Has(b.OpHash(txn))
+	})
+
+	miner.mine(t, b.AddrFromKey(&pkMonke))
+
+	waitFor(t, "the block with the known op to arrive", func() bool {
+		_, height := follower.chain.Tip()
+		return height == 3
+	})
+
+	if stats := follower.node.CompactBlockStats(); stats.OpsRequested != 1 || stats.Reconstructed != 2 || stats.Fallbacks != 0 {
+		t.Errorf("Expected the block to be rebuilt from the mempool alone, got %+v", stats)
+	}
+}
+
+func TestPendingCompactBlocksForgetTheOldest(t *testing.T) {
+	node := newTestNode(t, &b.RegTestParams)
+	skMonke, pkMonke := newKeypair()
+	_, pkJeff := newKeypair()
+	node.mine(t, b.AddrFromKey(&pkMonke))
+	conn := dialRawPeer(t, node)
+
+	// Rival blocks with an op the node hasn't seen, so each one waits on it
+	state, tip := node.chain.Snapshot()
+	pool := mempool.New(&state, mempool.DefaultConfig())
+	txn := signedTxn(&skMonke, &pkJeff, 100, 10, 0)
+
+	if err := pool.Add(txn); err != nil {
+		t.Fatal(err)
+	}
+
+	blocks := make([]*types.Block, 17)
+
+	for i := range blocks {
+		_, pk := newKeypair()
+		blocks[i] = mineBlock(t, &state, pool, tip, b.AddrFromKey(&pk))
+
+		if err := p2p.WriteMessage(conn, p2p.NewCompactBlock(blocks[i], uint64(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pingRawPeer(t, conn, 1)
+
+	// One more than fits, so only the first is forgotten
+	for _, block := range blocks[:2] {
+		if err := p2p.WriteMessage(conn, &p2p.MsgBlockOps{BlockHash: b.HashBlockHeader(block.Header), Ops: []types.Op{txn}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pingRawPeer(t, conn, 2)
+
+	if node.chain.HasBlock(b.HashBlockHeader(blocks[0].Header)) || !node.chain.HasBlock(b.HashBlockHeader(blocks[1].Header)) {
+		t.Error("Expected only the oldest pending compact block to be forgotten")
+	}
+}
+
+func TestReconstructBlock(t *testing.T) {
+	skMonke, pkMonke := newKeypair()
+	_, pkJeff := newKeypair()
+	monkeAddr := b.AddrFromKey(&pkMonke)
+
+	first := signedTxn(&skMonke, &pkJeff, 100, 10, 0)
+	second := signedTxn(&skMonke, &pkJeff, 100, 10, 1)
+	block := &types.Block{Operations: []types.Op{b.TemplateCoinbase(&monkeAddr), first, second}}
+	block.Header.MerkleRoot = b.CalculateMerkleRoot(block.Operations)
+
+	compact := p2p.NewCompactBlock(block, 42)
+	rebuilt, missing, err := p2p.ReconstructBlock(compact, []types.Op{second})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(missing) != 1 || missing[0] != 1 {
+		t.Fatalf("Expected only op 1 to be missing, got %v", missing)
+	}
+
+	rebuilt.Operations[1] = first
+
+	if b.CalculateMerkleRoot(rebuilt.Operations) != block.Header.MerkleRoot {
+		t.Error("Rebuilt block doesn't match the original")
+	}
+
+	compact.ShortIDs[1] = compact.ShortIDs[0]
+
+	if _, _, err := p2p.ReconstructBlock(compact, nil); err != p2p.ErrShortIDCollision {
+		t.Errorf("Expected a collision error, got %v", err)
+	}
+}
@@ -0,0 +1,208 @@
+package tests
+
+import (
+	"fmt"
+	b "gold/blockchain"
+	"gold/types"
+	"math/rand"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+// Applies ops[1:] one at a time the way blocks were before validation was batched, returning the
+// position of the first invalid op or 0
+func applySequentially(ops []types.Op, state *types.State) int {
+	for i, op := range ops[1:] {
+		if err := op.Validate(state); err != nil {
+			return i + 1
+		}
+
+		op.PerformOp(state)
+	}
+
+	return 0
+}
+
+// A block of ops between a handful of accounts and names, so most of them depend on each other, with a
+// run of txns from the bystanders somewhere in it that's big enough to be validated in parallel
+func randomBlock(rng *rand.Rand, state *types.State, keys map[secp256k1.PublicKey]*secp256k1.PrivateKey, names []string, bystanders []*secp256k1.PrivateKey) *types.Block {
+	pks := make([]secp256k1.PublicKey, 0, len(keys))
+
+	for pk := range keys {
+		pks = append(pks, pk)
+	}
+
+	sim := b.CopyState(state)
+	signers := []*secp256k1.PrivateKey{nil}
+	addr := b.AddrFromKey(&pks[0])
+	block := &types.Block{Header: types.Header{Timestamp: 1}, Operations: []types.Op{b.TemplateCoinbase(&addr)}}
+
+	randomAddr := func() types.Address {
+		if rng.Intn(3) == 0 {
+			return b.AddrFromName(names[rng.Intn(len(names))])
+		}
+
+		return b.AddrFromKey(&pks[rng.Intn(len(pks))])
+	}
+
+	run := 1 + rng.Intn(80)
+
+	for len(block.Operations) < 120 {
+		if len(block.Operations) == run {
+			for _, sk := range bystanders {
+				txn := signedTxn(sk, &pks[rng.Intn(len(pks))], uint64(rng.Intn(400)), 2, 0)
+				txn.PerformOp(&sim)
+				block.Operations = append(block.Operations, txn)
+				signers = append(signers, sk)
+			}
+		}
+
+		var op types.Op
+
+		if rng.Intn(5) == 0 {
+			op = &b.Rename{Name: names[rng.Intn(len(names))], NewKey: &pks[rng.Intn(len(pks))], Fee: 3}
+		} else {
+			payments := make([]b.Payment, 1+rng.Intn(3))
+
+			for i := range payments {
+				payments[i] = b.Payment{Reciever: randomAddr(), Amount: uint64(rng.Intn(400))}
+			}
+
+			op = &b.Txn{Sender: randomAddr(), Payments: payments, Fee: 2}
+		}
+
+		liable := op.LiableKey(&sim)
+
+		if liable == nil {
+			continue
+		}
+
+		nonce := uint32(0)
+
+		if account, exists := sim.AccountSet[*liable]; exists {
+			nonce = account.Nonce
+		}
+
+		switch op := op.(type) {
+		case *b.Txn:
+			op.Nonce = nonce
+			op.Signature = op.Sign(keys[*liable], chainID)
+		case *b.Rename:
+			op.Nonce = nonce
+			op.Signature = op.Sign(keys[*liable], chainID)
+		}
+
+		// Only keep ops that are valid where they are, so the block is valid until it's broken on purpose
+		if op.Validate(&sim) == nil {
+			op.PerformOp(&sim)
+			block.Operations = append(block.Operations, op)
+			signers = append(signers, keys[*liable])
+		}
+	}
+
+	// Break some blocks, with a bad signature or a bad nonce on a random op
+	target := 1 + rng.Intn(len(block.Operations)-1)
+
+	switch rng.Intn(3) {
+	case 0:
+		other, _ := secp256k1.GeneratePrivateKey()
+
+		switch op := block.Operations[target].(type) {
+		case *b.Txn:
+			op.Signature = op.Sign(other, chainID)
+		case *b.Rename:
+			op.Signature = op.Sign(other, chainID)
+		}
+	case 1:
+		switch op := block.Operations[target].(type) {
+		case *b.Txn:
+			op.Nonce += 1
+			op.Signature = op.Sign(signers[target], chainID)
+		case *b.Rename:
+			op.Nonce += 1
+			op.Signature = op.Sign(signers[target], chainID)
+		}
+	}
+
+	block.Operations[0] = b.Coinbase(&addr, block, state)
+	block.Header.MerkleRoot = b.CalculateMerkleRoot(block.Operations)
+	return block
+}
+
+func TestParallelMatchesSequential(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	position := regexp.MustCompile(`^op (\d+) is invalid`)
+	var valid, invalid int
+
+	for round := range 20 {
+		state := initState()
+
+		for i := range state.BlockSizes {
+			state.BlockSizes[i] = 1_000_000
+		}
+
+		state.Height = len(state.BlockSizes)
+		keys := make(map[secp256k1.PublicKey]*secp256k1.PrivateKey)
+		names := []string{"GitMonke", "Jeff", "Bob"}
+
+		for i := range 6 {
+			sk, pk := newKeypair()
+			keys[pk] = &sk
+			state.AccountSet[pk] = &types.Account{Balance: 2000}
+
+			if i < len(names) {
+				state.KeyNameSet[names[i]] = &pk
+			}
+		}
+
+		bystanders := make([]*secp256k1.PrivateKey, 24)
+
+		for i := range bystanders {
+			bystanders[i], _ = secp256k1.GeneratePrivateKey()
+			state.AccountSet[*bystanders[i].PubKey()] = &types.Account{Balance: 1000}
+		}
+
+		block := randomBlock(rng, &state, keys, names, bystanders)
+
+		sequential := b.CopyState(&state)
+		expected := applySequentially(block.Operations, &sequential)
+
+		connected := b.CopyState(&state)
+		_, err := b.ConnectBlock(block, &connected)
+
+		if expected == 0 {
+			valid++
+
+			if err != nil {
+				t.Fatalf("Round %d: expected the block to connect, got %v", round, err)
+			}
+
+			// The sequential application leaves out the coinbase
+			coinbase := block.Operations[0].(*b.Txn).Payments[0]
+			connected.AccountSet[*coinbase.Reciever.Key].Balance -= coinbase.Amount
+
+			if !reflect.DeepEqual(connected.AccountSet, sequential.AccountSet) || !reflect.DeepEqual(connected.KeyNameSet, sequential.KeyNameSet) {
+				t.Fatalf("Round %d: states differ", round)
+			}
+
+			continue
+		}
+
+		invalid++
+
+		if err == nil || position.FindStringSubmatch(err.Error())[1] != fmt.Sprint(expected) {
+			t.Fatalf("Round %d: expected op %d to be blamed, got %v", round, expected, err)
+		}
+
+		if !reflect.DeepEqual(connected.AccountSet, state.AccountSet) || !reflect.DeepEqual(connected.KeyNameSet, state.KeyNameSet) {
+			t.Fatalf("Round %d: expected the state to be rolled back", round)
+		}
+	}
+
+	if valid == 0 || invalid == 0 {
+		t.Errorf("Expected both valid and invalid blocks, got %d and %d", valid, invalid)
+	}
+}
@@ -0,0 +1,192 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	b "gold/blockchain"
+	"gold/types"
+	"reflect"
+	"testing"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/schnorr"
+)
+
+// Cosigns hash with each of signers, which are keys of the policy
+func cosign(policy *types.Policy, hash [32]byte, signers ...*secp256k1.PrivateKey) []b.Cosignature {
+	var cosigs []b.Cosignature
+
+	for _, sk := range signers {
+		sig, _ := schnorr.Sign(sk, hash[:])
+		cosigs = append(cosigs, b.Cosignature{Index: uint8(b.PolicyIndex(policy, sk.PubKey())), Signature: sig})
+	}
+
+	return cosigs
+}
+
+func TestNewPolicy(t *testing.T) {
+	_, pkA := newKeypair()
+	_, pkB := newKeypair()
+
+	policy, err := b.NewPolicy(2, []*secp256k1.PublicKey{&pkA, &pkB})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	swapped, _ := b.NewPolicy(2, []*secp256k1.PublicKey{&pkB, &pkA})
+
+	if !b.PolicyKey(policy).IsEqual(b.PolicyKey(swapped)) {
+		t.Errorf("Expected the policy key not to depend on the order of the keys")
+	}
+
+	oneOfTwo, _ := b.NewPolicy(1, []*secp256k1.PublicKey{&pkA, &pkB})
+
+	if b.PolicyKey(policy).IsEqual(b.PolicyKey(oneOfTwo)) {
+		t.Errorf("Expected the threshold to change the policy key")
+	}
+
+	for _, bad := range []struct {
+		threshold int
+		keys      []*secp256k1.PublicKey
+	}{
+		{0, []*secp256k1.PublicKey{&pkA}},
+		{3, []*secp256k1.PublicKey{&pkA, &pkB}},
+		{1, nil},
+		{1, []*secp256k1.PublicKey{&pkA, &pkA}},
+	} {
+		if _, err := b.NewPolicy(bad.threshold, bad.keys); !errors.Is(err, b.ErrInvalidPolicy) {
+			t.Errorf("Expected %d of %d keys to be refused, got %v", bad.threshold, len(bad.keys), err)
+		}
+	}
+}
+
+func TestPolicyAccount(t *testing.T) {
+	state := initState()
+	skFunder, pkFunder := newKeypair()
+	initAccount(&state, "Funder", &pkFunder, 1_000_000)
+
+	var signers []*secp256k1.PrivateKey
+	var keys []*secp256k1.PublicKey
+
+	for range 3 {
+		sk, _ := secp256k1.GeneratePrivateKey()
+		signers = append(signers, sk)
+		keys = append(keys, sk.PubKey())
+	}
+
+	policy, _ := b.NewPolicy(2, keys)
+	policyKey := b.PolicyKey(policy)
+	before := b.CopyState(&state)
+
+	register := &b.RegisterPolicy{Policy: *policy, Funder: &pkFunder, Fee: 100, Signature: b.MinimalSignature()}
+	register.Signature = register.Sign(&skFunder, chainID)
+
+	if err := register.Validate(&state); err != nil {
+		t.Fatal(err)
+	}
+
+	registerUndo := register.PerformOp(&state)
+
+	if err := register.Validate(&state); !errors.Is(err, b.ErrPolicyExists) {
+		t.Errorf("Expected a second registration to be refused, got %v", err)
+	}
+
+	fund := &b.Txn{Sender: b.AddrFromKey(&pkFunder), Payments: []b.Payment{{Reciever: b.AddrFromKey(policyKey), Amount: 5000}}, Nonce: 1, Fee: 10}
+	fund.Signature = fund.Sign(&skFunder, chainID)
+
+	if err := fund.Validate(&state); err != nil {
+		t.Fatal(err)
+	}
+
+	fundUndo := fund.PerformOp(&state)
+
+	// Spending takes two of the three keys
+	_, pkJeff := newKeypair()
+	spend := &b.Txn{Sender: b.AddrFromKey(policyKey), Payments: []b.Payment{{Reciever: b.AddrFromKey(&pkJeff), Amount: 1000}}, Fee: 10, Signature: b.MinimalSignature()}
+	hash := spend.SigningHash(chainID)
+
+	spend.Cosignatures = cosign(policy, hash, signers[0])
+
+	if err := spend.Validate(&state); !errors.Is(err, b.ErrThresholdNotMet) {
+		t.Errorf("Expected one cosignature to fall short, got %v", err)
+	}
+
+	spend.Cosignatures = append(cosign(policy, hash, signers[0]), cosign(policy, hash, signers[0])...)
+
+	if err := spend.Validate(&state); !errors.Is(err, b.ErrInvalidCosignature) {
+		t.Errorf("Expected a key signing twice to be refused, got %v", err)
+	}
+
+	outsider, _ := secp256k1.GeneratePrivateKey()
+	spend.Cosignatures = append(cosign(policy, hash, signers[0]), b.Cosignature{Index: 2, Signature: spend.Sign(outsider, chainID)})
+
+	if err := spend.Validate(&state); !errors.Is(err, b.ErrInvalidCosignature) {
+		t.Errorf("Expected a signature from outside the policy to be refused, got %v", err)
+	}
+
+	spend.Cosignatures = nil
+	spend.Signature = spend.Sign(signers[0], chainID)
+
+	if err := spend.Validate(&state); !errors.Is(err, b.ErrThresholdNotMet) {
+		t.Errorf("Expected a single signature to be refused for a policy, got %v", err)
+	}
+
+	spend.Signature = b.MinimalSignature()
+	spend.Cosignatures = cosign(policy, hash, signers[2], signers[0])
+
+	// Survives being sent around
+	decoded, err := b.DecodeOp(spend.Encode())
+
+	if err != nil || !bytes.Equal(decoded.Encode(), spend.Encode()) {
+		t.Fatalf("Expected the multisig txn to round trip, got %v", err)
+	}
+
+	if err := decoded.Validate(&state); err != nil {
+		t.Fatalf("Expected two cosignatures to be enough, got %v", err)
+	}
+
+	spendUndo := decoded.PerformOp(&state)
+
+	if state.AccountSet[*policyKey].Balance != 5000-1000-10 || state.AccountSet[*policyKey].Nonce != 1 {
+		t.Errorf("Unexpected policy account %+v", state.AccountSet[*policyKey])
+	}
+
+	// The policy claims a name, paying for it itself, then gives it away
+	claim := &b.Rename{Name: "Treasury", NewKey: policyKey, Fee: 10, Nonce: 1, Signature: b.MinimalSignature()}
+	claim.Cosignatures = cosign(policy, claim.SigningHash(chainID), signers[1], signers[2])
+
+	if err := claim.Validate(&state); err != nil {
+		t.Fatal(err)
+	}
+
+	claimUndo := claim.PerformOp(&state)
+
+	handOff := &b.Rename{Name: "Treasury", NewKey: &pkJeff, Fee: 10, Nonce: 2, Signature: b.MinimalSignature()}
+	handOff.Signature = handOff.Sign(&skFunder, chainID)
+
+	if err := handOff.Validate(&state); !errors.Is(err, b.ErrThresholdNotMet) {
+		t.Errorf("Expected a rename of the policy's name to need its keys, got %v", err)
+	}
+
+	handOff.Signature = b.MinimalSignature()
+	handOff.Cosignatures = cosign(policy, handOff.SigningHash(chainID), signers[0], signers[1])
+
+	if err := handOff.Validate(&state); err != nil {
+		t.Fatal(err)
+	}
+
+	handOffUndo := handOff.PerformOp(&state)
+
+	if !state.KeyNameSet["Treasury"].IsEqual(&pkJeff) {
+		t.Errorf("Expected jeff to own the name")
+	}
+
+	for _, undo := range []types.UndoOp{handOffUndo, claimUndo, spendUndo, fundUndo, registerUndo} {
+		undo.PerformUndo(&state)
+	}
+
+	if !reflect.DeepEqual(state.AccountSet, before.AccountSet) || !reflect.DeepEqual(state.KeyNameSet, before.KeyNameSet) || len(state.PolicySet) != 0 {
+		t.Errorf("Expected undoing everything to restore the state")
+	}
+}
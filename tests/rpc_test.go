@@ -0,0 +1,499 @@
+package tests
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	b "gold/blockchain"
+	"gold/fees"
+	"gold/mempool"
+	"gold/rpc"
+	"gold/types"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/gorilla/websocket"
+)
+
+func newTestRPC(t *testing.T) (*b.Chain, *mempool.Pool, *rpc.Client) {
+	chain, pool, server, cookieFile := newTestRPCServer(t)
+	client, err := rpc.NewClient(server.Addr().String(), cookieFile)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return chain, pool, client
+}
+
+func newTestRPCServer(t *testing.T) (*b.Chain, *mempool.Pool, *rpc.Server, string) {
+	chain := b.NewChain(&b.RegTestParams)
+	state, _ := chain.Snapshot()
+	pool := mempool.New(&state, mempool.DefaultConfig())
+	chain.AddListener(pool)
+
+	config := rpc.DefaultConfig(t.TempDir())
+	config.ListenAddr = "127.0.0.1:0"
+	server := rpc.NewServer(config, chain, pool)
+
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { server.Stop() })
+
+	return chain, pool, server, config.CookieFile
+}
+
+// Mines a block on the chain's tip with whatever is in the pool
+func mineOnChain(t *testing.T, chain *b.Chain, pool *mempool.Pool, addr types.Address) *types.Block {
+	state, tip := chain.Snapshot()
+	block := mineBlock(t, &state, pool, tip, addr)
+
+	if err := chain.ProcessBlock(block); err != nil {
+		t.Fatal(err)
+	}
+
+	return block
+}
+
+func rpcCode(err error) int {
+	var rpcErr *rpc.Error
+
+	if errors.As(err, &rpcErr) {
+		return rpcErr.Code
+	}
+
+	return 0
+}
+
+func TestRPCMethods(t *testing.T) {
+	chain, pool, client := newTestRPC(t)
+	skMonke, pkMonke := newKeypair()
+	monkeAddr := b.RegTestParams.FormatAddress(b.AddrFromKey(&pkMonke))
+
+	mineOnChain(t, chain, pool, b.AddrFromKey(&pkMonke))
+
+	rename := &b.Rename{Name: "GitMonke", NewKey: &pkMonke, Fee: 10, Nonce: 0}
+	rename.Signature = rename.Sign(&skMonke, chainID)
+
+	var opHash string
+
+	if err := client.Call("submitOp", []any{hex.EncodeToString(rename.Encode())}, &opHash); err != nil {
+		t.Fatal(err)
+	}
+
+	if wanted := b.OpHash(rename); opHash != hex.EncodeToString(wanted[:]) {
+		t.Errorf("submitOp returned hash %s", opHash)
+	}
+
+	var nonce rpc.Nonce
+
+	if err := client.Call("getNonce", map[string]any{"address": monkeAddr}, &nonce); err != nil {
+		t.Fatal(err)
+	}
+
+	if nonce.Confirmed != 0 || nonce.Next != 1 || nonce.Key != monkeAddr {
+		t.Errorf("Expected monke's confirmed nonce 0 and next nonce 1, got %+v", nonce)
+	}
+
+	var pending rpc.Mempool
+
+	if err := client.Call("getMempool", nil, &pending); err != nil {
+		t.Fatal(err)
+	}
+
+	if pending.Count != 1 || pending.Ops[0] != opHash {
+		t.Errorf("Expected the rename in the mempool, got %+v", pending)
+	}
+
+	block := mineOnChain(t, chain, pool, b.AddrFromKey(&pkMonke))
+
+	var owner string
+
+	if err := client.Call("resolveName", []any{"GitMonke"}, &owner); err != nil || owner != monkeAddr {
+		t.Errorf("Expected GitMonke to resolve to monke's key, got %s, %v", owner, err)
+	}
+
+	// Names and keys are interchangeable as addresses
+	var byName, byKey rpc.Balance
+	client.Call("getBalance", []any{"@GitMonke"}, &byName)
+	client.Call("getBalance", []any{monkeAddr}, &byKey)
+
+	if byName.Balance == 0 || byName.Balance != byKey.Balance || byName.Key != monkeAddr {
+		t.Errorf("Expected the same balance by name and key, got %+v and %+v", byName, byKey)
+	}
+
+	var byHeight, byHash rpc.Block
+	blockHash := b.HashBlockHeader(block.Header)
+
+	if err := client.Call("getBlock", []any{2}, &byHeight); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Call("getBlock", []any{hex.EncodeToString(blockHash[:])}, &byHash); err != nil {
+		t.Fatal(err)
+	}
+
+	if byHeight.Hash != byHash.Hash || len(byHeight.Ops) != 2 || byHeight.Ops[1].Type != "rename" || byHeight.Ops[1].Name != "GitMonke" {
+		t.Errorf("Unexpected block from getBlock: %+v", byHeight)
+	}
+
+	var header rpc.Header
+
+	if err := client.Call("getHeader", []any{1}, &header); err != nil || header.Height != 1 || header.Hash != byHeight.PrevBlockHash {
+		t.Errorf("Unexpected header from getHeader: %+v, %v", header, err)
+	}
+
+	var info rpc.ChainInfo
+
+	if err := client.Call("getChainInfo", nil, &info); err != nil || info.Height != 2 || info.Tip != byHash.Hash || info.MempoolOps != 0 || info.SigCache.Size != b.DefaultSigCacheSize {
+		t.Errorf("Unexpected chain info: %+v, %v", info, err)
+	}
+
+	var estimate rpc.FeeEstimate
+
+	if err := client.Call("estimateFee", []any{1000}, &estimate); err != nil || estimate.FeeRate != fees.FallbackFeeRate || estimate.TargetBlocks != fees.MaxTargetBlocks {
+		t.Errorf("Unexpected fee estimate: %+v, %v", estimate, err)
+	}
+}
+
+// Keys are shown as addresses on the server's network, the same as everywhere else in the API
+func TestPolicyJSONKeys(t *testing.T) {
+	_, pkMonke := newKeypair()
+	_, pkJeff := newKeypair()
+	policy := &types.Policy{Threshold: 1, Keys: []*secp256k1.PublicKey{&pkMonke, &pkJeff}}
+	result := rpc.PolicyJSON(&b.RegTestParams, policy)
+
+	for i, key := range policy.Keys {
+		if expected := b.RegTestParams.FormatAddress(b.AddrFromKey(key)); result.Keys[i] != expected {
+			t.Errorf("Expected key %d to be %s, got %s", i, expected, result.Keys[i])
+		}
+	}
+}
+
+func TestRPCErrors(t *testing.T) {
+	_, _, client := newTestRPC(t)
+
+	if err := client.Call("getBlock", []any{99}, nil); rpcCode(err) != rpc.CodeNotFound {
+		t.Errorf("Expected not found for a missing block, got %v", err)
+	}
+
+	if err := client.Call("resolveName", []any{"Nobody"}, nil); rpcCode(err) != rpc.CodeNotFound {
+		t.Errorf("Expected not found for an unregistered name, got %v", err)
+	}
+
+	if err := client.Call("getBalance", nil, nil); rpcCode(err) != rpc.CodeInvalidParams {
+		t.Errorf("Expected invalid params for a missing address, got %v", err)
+	}
+
+	// A bare name or a hex key isn't an address
+	if err := client.Call("getBalance", []any{"GitMonke"}, nil); rpcCode(err) != rpc.CodeInvalidParams {
+		t.Errorf("Expected invalid params for a name without its @, got %v", err)
+	}
+
+	if err := client.Call("submitOp", []any{"zz"}, nil); rpcCode(err) != rpc.CodeInvalidParams {
+		t.Errorf("Expected invalid params for bad hex, got %v", err)
+	}
+
+	if err := client.Call("mine", nil, nil); rpcCode(err) != rpc.CodeMethodNotFound {
+		t.Errorf("Expected method not found, got %v", err)
+	}
+}
+
+func TestRPCCookieAuth(t *testing.T) {
+	chain := b.NewChain(&b.RegTestParams)
+	state, _ := chain.Snapshot()
+	config := rpc.DefaultConfig(t.TempDir())
+	config.ListenAddr = "127.0.0.1:0"
+	server := rpc.NewServer(config, chain, mempool.New(&state, mempool.DefaultConfig()))
+
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	forged := filepath.Join(t.TempDir(), "cookie")
+	os.WriteFile(forged, []byte(rpc.CookieUser+":guess"), 0o600)
+	client, err := rpc.NewClient(server.Addr().String(), forged)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Call("getChainInfo", nil, nil); err == nil {
+		t.Error("Expected a wrong cookie to be refused")
+	}
+
+	server.Stop()
+
+	if _, err := os.Stat(config.CookieFile); !os.IsNotExist(err) {
+		t.Error("Expected the cookie file to be removed on stop")
+	}
+}
+
+// A websocket connection that keeps notifications for each subscription until they're asked for
+type wsTestClient struct {
+	conn    *websocket.Conn
+	pending map[uint64][]json.RawMessage
+	nextID  int
+}
+
+type wsTestMessage struct {
+	ID     json.RawMessage `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpc.Error      `json:"error"`
+	Params struct {
+		Subscription uint64          `json:"subscription"`
+		Result       json.RawMessage `json:"result"`
+	} `json:"params"`
+}
+
+func dialWebsocket(t *testing.T, server *rpc.Server, cookieFile string) *wsTestClient {
+	cookie, err := os.ReadFile(cookieFile)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := http.Header{}
+	header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString(cookie))
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+server.Addr().String()+"/ws", header)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+	return &wsTestClient{conn: conn, pending: make(map[uint64][]json.RawMessage)}
+}
+
+func (c *wsTestClient) read(t *testing.T) *wsTestMessage {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var msg wsTestMessage
+
+	if err := c.conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(msg.ID) == 0 {
+		c.pending[msg.Params.Subscription] = append(c.pending[msg.Params.Subscription], msg.Params.Result)
+	}
+
+	return &msg
+}
+
+func (c *wsTestClient) call(t *testing.T, method string, params any, result any) error {
+	c.nextID += 1
+	c.conn.WriteJSON(map[string]any{"jsonrpc": "2.0", "method": method, "params": params, "id": c.nextID})
+
+	for {
+		msg := c.read(t)
+
+		if len(msg.ID) == 0 {
+			continue
+		}
+
+		if msg.Error != nil {
+			return msg.Error
+		}
+
+		return json.Unmarshal(msg.Result, result)
+	}
+}
+
+func (c *wsTestClient) subscribe(t *testing.T, topic string, filter string) uint64 {
+	var id uint64
+
+	if err := c.call(t, "subscribe", []any{topic, filter}, &id); err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+// Waits for the next notification for sub and decodes it into result
+func (c *wsTestClient) next(t *testing.T, sub uint64, result any) {
+	for len(c.pending[sub]) == 0 {
+		c.read(t)
+	}
+
+	if err := json.Unmarshal(c.pending[sub][0], result); err != nil {
+		t.Fatal(err)
+	}
+
+	c.pending[sub] = c.pending[sub][1:]
+}
+
+func TestWebsocketSubscriptions(t *testing.T) {
+	chain, pool, server, cookieFile := newTestRPCServer(t)
+	ws := dialWebsocket(t, server, cookieFile)
+	skMonke, pkMonke := newKeypair()
+	monkeAddr := b.AddrFromKey(&pkMonke)
+
+	blocksSub := ws.subscribe(t, rpc.TopicBlocks, "")
+	namesSub := ws.subscribe(t, rpc.TopicNames, "GitMonke")
+	opsSub := ws.subscribe(t, rpc.TopicOps, b.RegTestParams.FormatAddress(monkeAddr))
+	mempoolSub := ws.subscribe(t, rpc.TopicMempool, "")
+
+	if err := ws.call(t, "subscribe", []any{"weather", ""}, new(uint64)); rpcCode(err) != rpc.CodeInvalidParams {
+		t.Errorf("Expected invalid params for an unknown topic, got %v", err)
+	}
+
+	// Other calls work over the websocket too
+	var info rpc.ChainInfo
+
+	if err := ws.call(t, "getChainInfo", nil, &info); err != nil || info.Height != 0 {
+		t.Errorf("Unexpected chain info over the websocket: %+v, %v", info, err)
+	}
+
+	first := mineOnChain(t, chain, pool, monkeAddr)
+	firstHash := b.HashBlockHeader(first.Header)
+	forkState, _ := chain.Snapshot()
+
+	var tipEvent rpc.TipEvent
+	ws.next(t, blocksSub, &tipEvent)
+
+	if tipEvent.Type != "connected" || tipEvent.Block.Hash != hex.EncodeToString(firstHash[:]) || tipEvent.TipHeight != 1 {
+		t.Errorf("Unexpected block event: %+v", tipEvent)
+	}
+
+	var opEvent rpc.OpEvent
+	ws.next(t, opsSub, &opEvent)
+
+	if opEvent.Status != "confirmed" || opEvent.Op.Type != "txn" || opEvent.Height != 1 {
+		t.Errorf("Expected monke's coinbase to be confirmed, got %+v", opEvent)
+	}
+
+	rename := &b.Rename{Name: "GitMonke", NewKey: &pkMonke, Fee: 10, Nonce: 0}
+	rename.Signature = rename.Sign(&skMonke, chainID)
+
+	if err := pool.Add(rename); err != nil {
+		t.Fatal(err)
+	}
+
+	renameHash := b.OpHash(rename)
+	var admitted rpc.MempoolEvent
+	ws.next(t, mempoolSub, &admitted)
+
+	if admitted.Op.Hash != hex.EncodeToString(renameHash[:]) {
+		t.Errorf("Expected the rename to be admitted, got %+v", admitted)
+	}
+
+	ws.next(t, opsSub, &opEvent)
+
+	if opEvent.Status != "pending" || opEvent.Op.Name != "GitMonke" {
+		t.Errorf("Expected the rename to be pending, got %+v", opEvent)
+	}
+
+	second := mineOnChain(t, chain, pool, monkeAddr)
+	ws.next(t, blocksSub, &tipEvent)
+
+	if len(tipEvent.NameChanges) != 1 || tipEvent.NameChanges[0].To != b.RegTestParams.FormatAddress(monkeAddr) {
+		t.Errorf("Expected the block event to carry the rename, got %+v", tipEvent)
+	}
+
+	var change rpc.NameChange
+	ws.next(t, namesSub, &change)
+
+	if change.Name != "GitMonke" || change.From != "" || change.To != b.RegTestParams.FormatAddress(monkeAddr) || change.Undone || change.Height != 2 {
+		t.Errorf("Unexpected name change: %+v", change)
+	}
+
+	// A longer fork from the first block takes over, undoing the rename
+	emptyPool := mempool.New(&forkState, mempool.DefaultConfig())
+	_, pkJeff := newKeypair()
+	prevHash := firstHash
+	var fork []*types.Block
+
+	for i := 0; i < 2; i++ {
+		block := mineBlock(t, &forkState, emptyPool, prevHash, b.AddrFromKey(&pkJeff))
+
+		if _, err := b.ConnectBlock(block, &forkState); err != nil {
+			t.Fatal(err)
+		}
+
+		fork = append(fork, block)
+		prevHash = b.HashBlockHeader(block.Header)
+	}
+
+	for _, block := range fork {
+		if err := chain.ProcessBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	secondHash := b.HashBlockHeader(second.Header)
+	ws.next(t, blocksSub, &tipEvent)
+
+	if tipEvent.Type != "disconnected" || tipEvent.Block.Hash != hex.EncodeToString(secondHash[:]) || tipEvent.Tip != hex.EncodeToString(firstHash[:]) {
+		t.Errorf("Expected the second block to be disconnected first, got %+v", tipEvent)
+	}
+
+	ws.next(t, namesSub, &change)
+
+	if !change.Undone || change.From != "" || change.To != b.RegTestParams.FormatAddress(monkeAddr) {
+		t.Errorf("Expected the rename to be undone, got %+v", change)
+	}
+
+	for i := range fork {
+		ws.next(t, blocksSub, &tipEvent)
+
+		if tipEvent.Type != "connected" || tipEvent.TipHeight != i+2 {
+			t.Errorf("Expected fork block %d to be connected, got %+v", i, tipEvent)
+		}
+	}
+}
+
+func TestWebsocketOpsResolveNamesPerOp(t *testing.T) {
+	chain, pool, server, cookieFile := newTestRPCServer(t)
+	ws := dialWebsocket(t, server, cookieFile)
+	skMonke, pkMonke := newKeypair()
+	_, pkJeff := newKeypair()
+	jeffSub := ws.subscribe(t, rpc.TopicOps, b.RegTestParams.FormatAddress(b.AddrFromKey(&pkJeff)))
+	mempoolSub := ws.subscribe(t, rpc.TopicMempool, "")
+
+	// Pending ops are matched against the tip when their event goes out, so each is waited for before mining
+	add := func(op types.Op) {
+		if err := pool.Add(op); err != nil {
+			t.Fatal(err)
+		}
+
+		ws.next(t, mempoolSub, new(rpc.MempoolEvent))
+	}
+
+	mineOnChain(t, chain, pool, b.AddrFromKey(&pkMonke))
+	claim := &b.Rename{Name: "GitMonke", NewKey: &pkMonke, Fee: 10, Nonce: 0}
+	claim.Signature = claim.Sign(&skMonke, chainID)
+	add(claim)
+	mineOnChain(t, chain, pool, b.AddrFromKey(&pkMonke))
+
+	// GitMonke is paid while it's still monke's, then given to jeff in the same block
+	pay := &b.Txn{Sender: b.AddrFromKey(&pkMonke), Payments: []b.Payment{{Reciever: b.AddrFromName("GitMonke"), Amount: 100}}, Fee: 10, Nonce: 1}
+	pay.Signature = pay.Sign(&skMonke, chainID)
+	give := &b.Rename{Name: "GitMonke", NewKey: &pkJeff, Fee: 10, Nonce: 2}
+	give.Signature = give.Sign(&skMonke, chainID)
+
+	add(pay)
+	add(give)
+
+	block := mineOnChain(t, chain, pool, b.AddrFromKey(&pkMonke))
+
+	if len(block.Operations) != 3 {
+		t.Fatalf("Expected the payment and rename to be mined together, got %d ops", len(block.Operations))
+	}
+
+	// Jeff only hears about the rename, since the payment went to monke
+	for _, status := range []string{"pending", "confirmed"} {
+		var opEvent rpc.OpEvent
+		ws.next(t, jeffSub, &opEvent)
+
+		if opEvent.Status != status || opEvent.Op.Type != "rename" {
+			t.Errorf("Expected the %s rename, got %+v", status, opEvent)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package tests
+
+import (
+	b "gold/blockchain"
+	"gold/mempool"
+	"testing"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+func TestSigCache(t *testing.T) {
+	b.SigCache.Reset(b.DefaultSigCacheSize)
+	defer b.SigCache.Reset(b.DefaultSigCacheSize)
+
+	state, block := blockOfTxns(20)
+	pool := mempool.New(&state, mempool.DefaultConfig())
+
+	for _, op := range block.Operations[1:] {
+		if err := pool.Add(op); err != nil {
+			t.Fatalf("Expected the txn to be added, got %v", err)
+		}
+	}
+
+	if stats := b.SigCache.Stats(); stats.Hits != 0 || stats.Misses != 20 || stats.Entries != 20 {
+		t.Fatalf("Expected every signature to be verified once and cached, got %+v", stats)
+	}
+
+	// The block's signatures were all checked by the mempool already
+	if err := b.CheckBlock(block, &state); err != nil {
+		t.Fatalf("Expected the block to connect, got %v", err)
+	}
+
+	if stats := b.SigCache.Stats(); stats.Hits != 20 || stats.Misses != 20 || stats.HitRate() != 0.5 {
+		t.Fatalf("Expected the block to hit the cache for every op, got %+v", stats)
+	}
+
+	// A different signature on a cached op doesn't get to use its entry, and isn't cached when it fails
+	forged := *block.Operations[5].(*b.Txn)
+	other, _ := secp256k1.GeneratePrivateKey()
+	forged.Signature = forged.Sign(other, chainID)
+
+	for range 2 {
+		if err := forged.Validate(&state); err == nil {
+			t.Fatalf("Expected the forged signature to be rejected")
+		}
+	}
+
+	if stats := b.SigCache.Stats(); stats.Hits != 20 || stats.Misses != 22 || stats.Entries != 20 {
+		t.Errorf("Expected the forged signature to miss both times, got %+v", stats)
+	}
+
+	// Signatures verified in a block's batch are cached too, up to the cache's size
+	b.SigCache.Reset(8)
+	state, block = blockOfTxns(20)
+
+	if err := b.CheckBlock(block, &state); err != nil {
+		t.Fatalf("Expected the block to connect, got %v", err)
+	}
+
+	if stats := b.SigCache.Stats(); stats.Entries != 8 || stats.Misses != 20 {
+		t.Errorf("Expected the cache to stay at 8 entries, got %+v", stats)
+	}
+
+	if err := b.CheckBlock(block, &state); err != nil || b.SigCache.Stats().Hits != 8 {
+		t.Errorf("Expected 8 of the block's signatures to hit, got %v and %+v", err, b.SigCache.Stats())
+	}
+
+	b.SigCache.Reset(0)
+
+	if err := b.CheckBlock(block, &state); err != nil || b.SigCache.Stats().Entries != 0 {
+		t.Errorf("Expected a cache of size 0 to stay empty, got %v and %+v", err, b.SigCache.Stats())
+	}
+}
@@ -0,0 +1,56 @@
+package tests
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	b "gold/blockchain"
+	"testing"
+)
+
+func TestSigningHash(t *testing.T) {
+	state := initState()
+	sk, pk := newKeypair()
+	_, pkJeff := newKeypair()
+	initAccount(&state, "GitMonke", &pk, 1000)
+
+	txn := &b.Txn{Sender: b.AddrFromKey(&pk), Payments: []b.Payment{{Reciever: b.AddrFromKey(&pkJeff), Amount: 10}}, Fee: 1, Signature: b.MinimalSignature()}
+
+	// An outside signer only needs the unsigned op's bytes and the tag to get the message
+	tag := sha256.Sum256(fmt.Appendf(nil, "gold/sighash/v1/txn/%d", chainID))
+	wanted := sha256.Sum256(append(append(tag[:], tag[:]...), txn.Encode()...))
+
+	if hash := txn.SigningHash(chainID); hash != wanted {
+		t.Fatalf("Expected the signing hash to be the tagged hash of the unsigned encoding, got %x", hash)
+	}
+
+	if hash, err := b.VersionedSigningHash(1, txn, chainID); err != nil || hash != wanted {
+		t.Errorf("Expected version 1 to be the current signing hash, got %x, %v", hash, err)
+	}
+
+	if _, err := b.VersionedSigningHash(2, txn, chainID); !errors.Is(err, b.ErrSigHashVersion) {
+		t.Errorf("Expected an unknown version to be refused, got %v", err)
+	}
+
+	txn.Signature = txn.Sign(&sk, chainID)
+
+	if err := txn.Validate(&state); err != nil {
+		t.Fatalf("Expected the txn to be valid, got %v", err)
+	}
+
+	// The same txn signed for another network isn't valid here
+	txn.Signature = txn.Sign(&sk, b.MainNetParams.ChainID)
+
+	if err := txn.Validate(&state); !errors.Is(err, b.ErrTxnSig) {
+		t.Errorf("Expected a mainnet signature to be refused on regtest, got %v", err)
+	}
+
+	// Nor is a signature over the same bytes under another op type's tag
+	rename := &b.Rename{Name: "GitMonke", NewKey: &pkJeff, Signature: b.MinimalSignature()}
+	renameHash := rename.SigningHash(chainID)
+	otherTag := b.TaggedHash(b.SigHashTag(1, "txn", chainID), rename.Encode())
+
+	if renameHash == otherTag || renameHash != b.TaggedHash(b.SigHashTag(1, "rename", chainID), rename.Encode()) {
+		t.Errorf("Expected renames to be hashed under their own tag")
+	}
+}
@@ -0,0 +1,534 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	b "gold/blockchain"
+	"gold/mempool"
+	"gold/types"
+	"gold/wallet"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+// Cheap enough to keep the tests quick
+var testKDFParams = wallet.KDFParams{N: 1 << 10, R: 8, P: 1}
+
+func TestWalletKeystore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wallet.json")
+	w, err := wallet.Create(path, "hunter2", testKDFParams)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := wallet.Create(path, "hunter2", testKDFParams); err != wallet.ErrWalletExists {
+		t.Errorf("Expected creating over an existing wallet to fail, got %v", err)
+	}
+
+	pkMonke, err := w.NewKey("monke")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	skJeff, pkJeff := newKeypair()
+
+	if err := w.Import(&skJeff, "jeff"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Import(&skJeff, "jeff again"); err != wallet.ErrKeyExists {
+		t.Errorf("Expected a duplicate import to fail, got %v", err)
+	}
+
+	w.SetNameLabel("GitMonke", "main name")
+	w.Lock()
+
+	if _, err := w.Export(pkMonke); err != wallet.ErrLocked {
+		t.Errorf("Expected export to need an unlocked wallet, got %v", err)
+	}
+
+	// Labels work while locked
+	if err := w.SetLabel(&pkJeff, "jeff's key"); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := wallet.Open(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reopened.Locked() {
+		t.Error("Expected an opened wallet to start locked")
+	}
+
+	keys := reopened.Keys()
+
+	if len(keys) != 2 || !keys[0].Key.IsEqual(pkMonke) || keys[0].Label != "monke" || keys[1].Label != "jeff's key" {
+		t.Errorf("Unexpected keys after reopening: %+v", keys)
+	}
+
+	if err := reopened.Unlock("hunter3", 0); err != wallet.ErrWrongPassphrase {
+		t.Errorf("Expected a wrong passphrase to be refused, got %v", err)
+	}
+
+	if err := reopened.Unlock("hunter2", 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	exported, err := reopened.Export(&pkJeff)
+
+	if err != nil || exported.Key != skJeff.Key {
+		t.Errorf("Expected jeff's key to export as imported, got %v", err)
+	}
+
+	waitFor(t, "the wallet to lock itself", reopened.Locked)
+
+	// Unlocking again without a timeout isn't undone by an earlier unlock's timer firing late
+	for range 5 {
+		reopened.Unlock("hunter2", time.Millisecond)
+		reopened.Unlock("hunter2", 0)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if reopened.Locked() {
+		t.Error("Expected a stale lock timer not to lock the wallet")
+	}
+
+	reopened.Lock()
+
+	state := initState()
+	initAccount(&state, "GitMonke", pkMonke, 0)
+	initAccount(&state, "Jeff", &pkJeff, 0)
+	_, pkOther := newKeypair()
+	initAccount(&state, "Other", &pkOther, 0)
+
+	names := reopened.OwnedNames(&state)
+
+	if len(names) != 2 || names[0].Name != "GitMonke" || names[0].Label != "main name" || names[1].Name != "Jeff" {
+		t.Errorf("Unexpected owned names: %+v", names)
+	}
+}
+
+func TestMnemonic(t *testing.T) {
+	mnemonic, err := wallet.MnemonicFromEntropy(make([]byte, 16))
+
+	if err != nil || mnemonic != strings.Repeat("abandon ", 11)+"about" {
+		t.Fatalf("Unexpected mnemonic for zero entropy: %q, %v", mnemonic, err)
+	}
+
+	// From the BIP39 test vectors
+	seed, err := wallet.MnemonicSeed(mnemonic, "TREZOR")
+
+	if err != nil || hex.EncodeToString(seed) != "c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04" {
+		t.Errorf("Unexpected seed %x, %v", seed, err)
+	}
+
+	mnemonic, err = wallet.NewMnemonic(256)
+
+	if err != nil || len(strings.Fields(mnemonic)) != 24 {
+		t.Fatalf("Expected 24 words, got %q, %v", mnemonic, err)
+	}
+
+	entropy, err := wallet.MnemonicToEntropy(mnemonic)
+
+	if err != nil || len(entropy) != 32 {
+		t.Errorf("Expected the mnemonic to decode to 32 bytes, got %x, %v", entropy, err)
+	}
+
+	if _, err := wallet.MnemonicToEntropy(strings.Repeat("abandon ", 12)); err != wallet.ErrInvalidMnemonic {
+		t.Errorf("Expected a bad checksum to be caught, got %v", err)
+	}
+
+	if _, err := wallet.MnemonicToEntropy(strings.Repeat("abandon ", 11) + "monke"); err != wallet.ErrInvalidMnemonic {
+		t.Errorf("Expected an unknown word to be caught, got %v", err)
+	}
+}
+
+// BIP32 test vector 1
+func TestHDDerivation(t *testing.T) {
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	master, err := wallet.NewMasterKey(seed)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vectors := map[string]string{
+		"m":                      "e8f32e723decf4051aefac8e2c93c9c5b214313817cdb01a1494b917c8436b35",
+		"m/0'":                   "edb2e14f9ee77d26dd93b4ecede8d16ed408ce149b6cd80b0715a2d911a0afea",
+		"m/0'/1":                 "3c6cb8d0f6a264c91ea8b5030fadaa8e538b020f0a387421a12de9319dc93368",
+		"m/0h/1/2h":              "cbce0d719ecf7431d88e6a89fa1483e02e35092af60c042b1df2ff59fa424dca",
+		"m/0'/1/2'/2/1000000000": "471b76e389e528d6de6d816857e012c5455051cad6660850e58372a6c3e6e7c8",
+	}
+
+	for path, wanted := range vectors {
+		key, err := master.Derive(path)
+
+		if err != nil {
+			t.Errorf("Deriving %s: %v", path, err)
+			continue
+		}
+
+		if got := hex.EncodeToString(key.PrivateKey().Serialize()); got != wanted {
+			t.Errorf("Expected %s to derive %s, got %s", path, wanted, got)
+		}
+	}
+
+	if _, err := master.Derive("0/1"); err != wallet.ErrInvalidPath {
+		t.Errorf("Expected a path without m to be refused, got %v", err)
+	}
+}
+
+func TestWalletRecovery(t *testing.T) {
+	mnemonic, _ := wallet.NewMnemonic(128)
+	seed, err := wallet.MnemonicSeed(mnemonic, "")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	original, err := wallet.Create(filepath.Join(t.TempDir(), "wallet.json"), "pass", testKDFParams)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := original.SetSeed(seed); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := make([]*secp256k1.PublicKey, 0)
+
+	for i := 0; i < 4; i++ {
+		key, err := original.NewHDKey(0, "")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	otherAccount, _ := original.NewHDKey(1, "")
+
+	// The first key has a balance, the third owns a name and the other account's key has sent an op.
+	// The second and fourth were never used.
+	state := initState()
+	initAccount(&state, "Unused", keys[0], 500)
+	initAccount(&state, "GitMonke", keys[2], 0)
+	state.AccountSet[*otherAccount] = &types.Account{Nonce: 1}
+
+	restored, err := wallet.Restore(filepath.Join(t.TempDir(), "restored.json"), "pass", testKDFParams, mnemonic, "", &state, 3)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := restored.Keys()
+
+	if len(found) != 4 {
+		t.Fatalf("Expected 4 keys to be found, got %d", len(found))
+	}
+
+	for i, key := range []*secp256k1.PublicKey{keys[0], keys[1], keys[2], otherAccount} {
+		if !found[i].Key.IsEqual(key) {
+			t.Errorf("Expected key %d to be restored", i)
+		}
+	}
+
+	if names := restored.OwnedNames(&state); len(names) != 2 {
+		t.Errorf("Expected the restored wallet to own both names, got %+v", names)
+	}
+
+	// New keys carry on after the used ones
+	next, err := restored.NewHDKey(0, "")
+
+	if err != nil || !next.IsEqual(keys[3]) {
+		t.Errorf("Expected the next key to be the fourth one, got %v", err)
+	}
+}
+
+func TestTxnBuilder(t *testing.T) {
+	chain := b.NewChain(&b.RegTestParams)
+	state, _ := chain.Snapshot()
+	pool := mempool.New(&state, mempool.DefaultConfig())
+	chain.AddListener(pool)
+
+	skMonke, pkMonke := newKeypair()
+	skJeff, pkJeff := newKeypair()
+	_, pkBob := newKeypair()
+	mineOnChain(t, chain, pool, b.AddrFromKey(&pkMonke))
+
+	var balance uint64
+	chain.ReadState(func(state *types.State, tip [32]byte) { balance = state.AccountSet[pkMonke].Balance })
+
+	builder := wallet.NewBuilder(chain, pool, nil)
+	builder.FeeRate = 3
+
+	txn, err := builder.Txn(b.AddrFromKey(&pkMonke), &skMonke, []b.Payment{
+		{Reciever: b.AddrFromKey(&pkJeff), Amount: 100},
+		{Reciever: b.AddrFromKey(&pkBob), Amount: 200},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if txn.Nonce != 0 || txn.Fee != 3*uint64(len(txn.Encode())) || len(txn.Payments) != 2 {
+		t.Errorf("Unexpected txn: %+v", txn)
+	}
+
+	if err := pool.Add(txn); err != nil {
+		t.Fatal(err)
+	}
+
+	// The pending txn's nonce and spending are counted
+	rename, err := builder.Rename("GitMonke", &skMonke, &pkMonke)
+
+	if err != nil || rename.Nonce != 1 {
+		t.Fatalf("Expected the rename to follow the pending txn, got %+v, %v", rename, err)
+	}
+
+	if err := pool.Add(rename); err != nil {
+		t.Fatal(err)
+	}
+
+	left := balance - 300 - txn.Fee - rename.Fee
+	_, err = builder.Txn(b.AddrFromName("GitMonke"), &skMonke, []b.Payment{{Reciever: b.AddrFromKey(&pkJeff), Amount: left}})
+
+	if !errors.Is(err, wallet.ErrUnknownAddress) {
+		t.Errorf("Expected the name to be unknown until the rename is mined, got %v", err)
+	}
+
+	_, err = builder.Txn(b.AddrFromKey(&pkMonke), &skMonke, []b.Payment{{Reciever: b.AddrFromKey(&pkJeff), Amount: left}})
+
+	if !errors.Is(err, wallet.ErrInsufficientBalance) {
+		t.Errorf("Expected the fee to push the txn over the balance, got %v", err)
+	}
+
+	if _, err := builder.Txn(b.AddrFromKey(&pkMonke), &skJeff, []b.Payment{{Reciever: b.AddrFromKey(&pkBob), Amount: 1}}); err != wallet.ErrWrongKey {
+		t.Errorf("Expected jeff's key to be refused for monke's account, got %v", err)
+	}
+
+	if _, err := builder.Txn(b.AddrFromKey(&pkJeff), &skJeff, []b.Payment{{Reciever: b.AddrFromKey(&pkBob), Amount: 1}}); !errors.Is(err, wallet.ErrInsufficientBalance) {
+		t.Errorf("Expected an account that was never paid to have nothing to spend, got %v", err)
+	}
+
+	mineOnChain(t, chain, pool, b.AddrFromKey(&pkJeff))
+
+	last, err := builder.Txn(b.AddrFromName("GitMonke"), &skMonke, []b.Payment{{Reciever: b.AddrFromKey(&pkJeff), Amount: 10}})
+
+	if err != nil || last.Nonce != 2 {
+		t.Fatalf("Expected a txn from the mined name with nonce 2, got %v", err)
+	}
+
+	if err := pool.Add(last); err != nil {
+		t.Errorf("Expected the built txn to be accepted, got %v", err)
+	}
+}
+
+func TestPolicyTxnBuilder(t *testing.T) {
+	chain := b.NewChain(&b.RegTestParams)
+	state, _ := chain.Snapshot()
+	pool := mempool.New(&state, mempool.DefaultConfig())
+	chain.AddListener(pool)
+
+	skMonke, pkMonke := newKeypair()
+	_, pkJeff := newKeypair()
+	mineOnChain(t, chain, pool, b.AddrFromKey(&pkMonke))
+
+	var signers []*secp256k1.PrivateKey
+	var keys []*secp256k1.PublicKey
+
+	for range 3 {
+		sk, _ := secp256k1.GeneratePrivateKey()
+		signers = append(signers, sk)
+		keys = append(keys, sk.PubKey())
+	}
+
+	policy, _ := b.NewPolicy(2, keys)
+	policyAddr := b.AddrFromKey(b.PolicyKey(policy))
+
+	register := &b.RegisterPolicy{Policy: *policy, Funder: &pkMonke, Fee: 100, Signature: b.MinimalSignature()}
+	register.Signature = register.Sign(&skMonke, chainID)
+
+	if err := pool.Add(register); err != nil {
+		t.Fatal(err)
+	}
+
+	builder := wallet.NewBuilder(chain, pool, nil)
+	builder.FeeRate = 3
+	fund, err := builder.Txn(b.AddrFromKey(&pkMonke), &skMonke, []b.Payment{{Reciever: policyAddr, Amount: 10_000}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pool.Add(fund); err != nil {
+		t.Fatal(err)
+	}
+
+	mineOnChain(t, chain, pool, b.AddrFromKey(&pkMonke))
+	payments := []b.Payment{{Reciever: b.AddrFromKey(&pkJeff), Amount: 100}}
+
+	// A single key can't sign for the policy, and cosigners have to meet its threshold
+	if _, err := builder.UnsignedTxn(policyAddr, keys[0], payments); !errors.Is(err, wallet.ErrWrongKey) {
+		t.Errorf("Expected a single signer to be refused for a policy, got %v", err)
+	}
+
+	if _, err := builder.PolicyTxn(policyAddr, signers[:1], payments); !errors.Is(err, b.ErrThresholdNotMet) {
+		t.Errorf("Expected one cosigner to be too few, got %v", err)
+	}
+
+	if _, err := builder.PolicyTxn(policyAddr, []*secp256k1.PrivateKey{signers[0], &skMonke}, payments); !errors.Is(err, wallet.ErrWrongKey) {
+		t.Errorf("Expected a key outside the policy to be refused, got %v", err)
+	}
+
+	if _, err := builder.PolicyTxn(b.AddrFromKey(&pkMonke), signers[:2], payments); !errors.Is(err, wallet.ErrWrongKey) {
+		t.Errorf("Expected cosigners to be refused for an account that isn't a policy, got %v", err)
+	}
+
+	txn, err := builder.PolicyTxn(policyAddr, []*secp256k1.PrivateKey{signers[2], signers[0]}, payments)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The fee covers the cosignatures the txn is broadcast with
+	if len(txn.Cosignatures) != 2 || txn.Fee != 3*uint64(len(txn.Encode())) {
+		t.Errorf("Expected a fee of 3 per byte of the cosigned txn, got %d for %d bytes", txn.Fee, len(txn.Encode()))
+	}
+
+	if err := pool.Add(txn); err != nil {
+		t.Errorf("Expected the cosigned txn to be accepted, got %v", err)
+	}
+}
+
+func TestOfflineSigning(t *testing.T) {
+	chain := b.NewChain(&b.RegTestParams)
+	state, _ := chain.Snapshot()
+	pool := mempool.New(&state, mempool.DefaultConfig())
+	chain.AddListener(pool)
+
+	skMonke, pkMonke := newKeypair()
+	skJeff, pkJeff := newKeypair()
+	mineOnChain(t, chain, pool, b.AddrFromKey(&pkMonke))
+
+	builder := wallet.NewBuilder(chain, pool, nil)
+	rename, err := builder.Rename("GitMonke", &skMonke, &pkMonke)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool.Add(rename)
+	mineOnChain(t, chain, pool, b.AddrFromKey(&pkJeff))
+
+	// Built online from the name, without the private key
+	unsigned, err := builder.UnsignedTxn(b.AddrFromName("GitMonke"), &pkMonke, []b.Payment{{Reciever: b.AddrFromKey(&pkJeff), Amount: 50}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if unsigned.Nonce != 1 || !unsigned.Resolve("GitMonke").IsEqual(&pkMonke) {
+		t.Fatalf("Expected nonce 1 and the name resolved to monke, got %+v", unsigned)
+	}
+
+	// Carried over as text either way
+	for _, text := range []string{unsigned.Hex(), unsigned.Base64()} {
+		parsed, err := wallet.ParseUnsignedOp(text)
+
+		if err != nil || !bytes.Equal(parsed.Encode(), unsigned.Encode()) {
+			t.Fatalf("Expected the unsigned op to survive %q, got %v", text, err)
+		}
+	}
+
+	if _, err := wallet.ParseUnsignedOp("neither hex nor base64!"); err == nil {
+		t.Error("Expected text that's neither hex nor base64 to be refused")
+	}
+
+	// Hex of an even number of bytes reads as base64 too. When neither reading decodes, the hex one's
+	// error is the one reported.
+	badVersion := append([]byte{2}, unsigned.Encode()[1:]...)
+
+	if _, err := wallet.ParseUnsignedOp(hex.EncodeToString(badVersion)); !errors.Is(err, wallet.ErrUnsignedOpInvalid) || !strings.Contains(err.Error(), "unknown version 2") {
+		t.Errorf("Expected the hex reading's error, got %v", err)
+	}
+
+	offline, _ := wallet.ParseUnsignedOp(unsigned.Base64())
+
+	if _, err := offline.Sign(&skJeff); err != wallet.ErrWrongKey {
+		t.Errorf("Expected jeff's key to be refused, got %v", err)
+	}
+
+	signed, err := offline.Sign(&skMonke)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// And back online
+	returned, err := wallet.ParseOp(wallet.FormatOp(signed))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A signature over a different op doesn't pass
+	tampered := *returned.(*b.Txn)
+	tampered.Payments = []b.Payment{{Reciever: b.AddrFromKey(&pkJeff), Amount: 5000}}
+
+	if err := wallet.Broadcast(pool, unsigned, &tampered); !errors.Is(err, wallet.ErrSignedOpMismatch) {
+		t.Errorf("Expected a changed op to be refused, got %v", err)
+	}
+
+	forged := *unsigned.Op.(*b.Txn)
+	forged.Signature = forged.Sign(&skJeff, chainID)
+
+	if err := wallet.Broadcast(pool, unsigned, &forged); !errors.Is(err, wallet.ErrSignedOpMismatch) {
+		t.Errorf("Expected a signature from the wrong key to be refused, got %v", err)
+	}
+
+	if err := wallet.Broadcast(pool, unsigned, returned); err != nil {
+		t.Fatalf("Expected the signed op to be broadcast, got %v", err)
+	}
+
+	if len(pool.Pending(&pkMonke)) != 1 {
+		t.Errorf("Expected the op in the mempool")
+	}
+
+	// Changing the carried context is caught when it's read
+	data := unsigned.Encode()
+	data[len(data)-34-len("GitMonke")-1-12] ^= 1
+
+	if _, err := wallet.DecodeUnsignedOp(data); !errors.Is(err, wallet.ErrUnsignedOpInvalid) {
+		t.Errorf("Expected a changed nonce to be caught, got %v", err)
+	}
+
+	// Cut off anywhere, it doesn't decode
+	for end := range len(unsigned.Encode()) {
+		if _, err := wallet.DecodeUnsignedOp(unsigned.Encode()[:end]); !errors.Is(err, wallet.ErrUnsignedOpInvalid) {
+			t.Fatalf("Expected the first %d bytes to be refused, got %v", end, err)
+		}
+	}
+
+	// Cosignatures count as a signature, so a cosigned op isn't signed again
+	cosigned := *unsigned
+	cosignedTxn := *unsigned.Op.(*b.Txn)
+	cosignedTxn.Cosignatures = []b.Cosignature{{Index: 0, Signature: cosignedTxn.Sign(&skMonke, chainID)}}
+	cosigned.Op = &cosignedTxn
+
+	if err := cosigned.Check(); !errors.Is(err, wallet.ErrUnsignedOpInvalid) {
+		t.Errorf("Expected a cosigned op to count as signed, got %v", err)
+	}
+}
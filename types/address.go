@@ -0,0 +1,222 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+// Key addresses are written bech32m style: the network's prefix, a 1, then the compressed key five bits
+// to a character followed by a six character checksum, like gold1q... Any single typo, and almost any
+// other, breaks the checksum. Name addresses are the name after an @, like @GitMonke.
+
+var ErrInvalidAddress = errors.New("invalid address")
+
+// Prefix of key addresses on mainnet
+const DefaultAddressPrefix = "gold"
+
+const (
+	bech32Charset    = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	bech32mConst     = 0x2bc830a3
+	checksumLength   = 6
+	maxAddressLength = 90
+)
+
+// Names are written with a one byte length
+const MaxNameLength = 255
+
+// The address with key addresses under prefix, which comes from the network's params
+func (addr Address) Format(prefix string) string {
+	if addr.UsesName {
+		return "@" + *addr.Name
+	}
+
+	if addr.Key == nil {
+		return ""
+	}
+
+	return encodeBech32m(prefix, toBase32(addr.Key.SerializeCompressed()))
+}
+
+// Reads an address written by Format. Key addresses have to have the given prefix.
+func ParseAddress(encoded string, prefix string) (Address, error) {
+	if name, isName := strings.CutPrefix(encoded, "@"); isName {
+		if name == "" {
+			return Address{}, fmt.Errorf("%w: name is empty", ErrInvalidAddress)
+		}
+
+		if len(name) > MaxNameLength {
+			return Address{}, fmt.Errorf("%w: names are at most %d bytes", ErrInvalidAddress, MaxNameLength)
+		}
+
+		return Address{UsesName: true, Name: &name}, nil
+	}
+
+	encodedPrefix, data, err := decodeBech32m(encoded)
+
+	if err != nil {
+		return Address{}, err
+	}
+
+	if encodedPrefix != prefix {
+		return Address{}, fmt.Errorf("%w: prefix %s is for another network, this one uses %s", ErrInvalidAddress, encodedPrefix, prefix)
+	}
+
+	keyBytes, err := fromBase32(data)
+
+	if err != nil {
+		return Address{}, err
+	}
+
+	if len(keyBytes) != secp256k1.PubKeyBytesLenCompressed {
+		return Address{}, fmt.Errorf("%w: not a compressed public key", ErrInvalidAddress)
+	}
+
+	key, err := secp256k1.ParsePubKey(keyBytes)
+
+	if err != nil {
+		return Address{}, fmt.Errorf("%w: %v", ErrInvalidAddress, err)
+	}
+
+	return Address{Key: key}, nil
+}
+
+func polymod(values []byte) uint32 {
+	generators := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	checksum := uint32(1)
+
+	for _, value := range values {
+		top := checksum >> 25
+		checksum = (checksum&0x1ffffff)<<5 ^ uint32(value)
+
+		for i, generator := range generators {
+			if (top>>i)&1 == 1 {
+				checksum ^= generator
+			}
+		}
+	}
+
+	return checksum
+}
+
+// The prefix's high bits, a zero, then its low bits, so the checksum covers the prefix too
+func expandPrefix(prefix string) []byte {
+	expanded := make([]byte, 0, 2*len(prefix)+1)
+
+	for i := range len(prefix) {
+		expanded = append(expanded, prefix[i]>>5)
+	}
+
+	expanded = append(expanded, 0)
+
+	for i := range len(prefix) {
+		expanded = append(expanded, prefix[i]&31)
+	}
+
+	return expanded
+}
+
+func encodeBech32m(prefix string, data []byte) string {
+	values := append(expandPrefix(prefix), data...)
+	checksum := polymod(append(values, make([]byte, checksumLength)...)) ^ bech32mConst
+
+	var encoded strings.Builder
+	encoded.WriteString(prefix)
+	encoded.WriteByte('1')
+
+	for _, value := range data {
+		encoded.WriteByte(bech32Charset[value])
+	}
+
+	for i := range checksumLength {
+		encoded.WriteByte(bech32Charset[(checksum>>(5*(checksumLength-1-i)))&31])
+	}
+
+	return encoded.String()
+}
+
+// Splits an address into its prefix and five bit values, checking the checksum
+func decodeBech32m(encoded string) (string, []byte, error) {
+	if len(encoded) > maxAddressLength {
+		return "", nil, fmt.Errorf("%w: too long", ErrInvalidAddress)
+	}
+
+	// Either case is fine, but not both
+	lower := strings.ToLower(encoded)
+
+	if lower != encoded && strings.ToUpper(encoded) != encoded {
+		return "", nil, fmt.Errorf("%w: mixes upper and lower case", ErrInvalidAddress)
+	}
+
+	separator := strings.LastIndexByte(lower, '1')
+
+	if separator < 1 || len(lower)-separator-1 < checksumLength {
+		return "", nil, fmt.Errorf("%w: not a name starting with @ or a key address", ErrInvalidAddress)
+	}
+
+	prefix := lower[:separator]
+	data := make([]byte, 0, len(lower)-separator-1)
+
+	for _, char := range lower[separator+1:] {
+		value := strings.IndexRune(bech32Charset, char)
+
+		if value == -1 {
+			return "", nil, fmt.Errorf("%w: %q isn't an address character", ErrInvalidAddress, char)
+		}
+
+		data = append(data, byte(value))
+	}
+
+	if polymod(append(expandPrefix(prefix), data...)) != bech32mConst {
+		return "", nil, fmt.Errorf("%w: checksum doesn't match, check for typos", ErrInvalidAddress)
+	}
+
+	return prefix, data[:len(data)-checksumLength], nil
+}
+
+func toBase32(data []byte) []byte {
+	values := make([]byte, 0, (len(data)*8+4)/5)
+	var acc uint32
+	var bits uint
+
+	for _, b := range data {
+		acc = acc<<8 | uint32(b)
+		bits += 8
+
+		for bits >= 5 {
+			bits -= 5
+			values = append(values, byte(acc>>bits)&31)
+		}
+	}
+
+	if bits > 0 {
+		values = append(values, byte(acc<<(5-bits))&31)
+	}
+
+	return values
+}
+
+// The reverse of toBase32. Padding has to be under a byte and all zeros, so each key has one encoding.
+func fromBase32(values []byte) ([]byte, error) {
+	data := make([]byte, 0, len(values)*5/8)
+	var acc uint32
+	var bits uint
+
+	for _, value := range values {
+		acc = acc<<5 | uint32(value)
+		bits += 5
+
+		if bits >= 8 {
+			bits -= 8
+			data = append(data, byte(acc>>bits))
+		}
+	}
+
+	if bits >= 5 || acc&(1<<bits-1) != 0 {
+		return nil, fmt.Errorf("%w: bad padding", ErrInvalidAddress)
+	}
+
+	return data, nil
+}
@@ -23,13 +23,17 @@ type Header struct {
 
 type AccountSet = map[secp256k1.PublicKey]*Account
 type KeyNameSet = map[string]*secp256k1.PublicKey
+type PolicySet = map[secp256k1.PublicKey]*Policy
 
 type State struct {
 	AccountSet AccountSet
 	KeyNameSet KeyNameSet
+	PolicySet  PolicySet
 	BlockSizes [100]int
 	Timestamps [720]uint64
 	Height     int
+	// The chain's ID from its params, which every signature commits to
+	ChainID uint32
 }
 
 type Account struct {
@@ -37,14 +41,26 @@ type Account struct {
 	Nonce   uint32
 }
 
+// An m-of-n spending policy. Its account lives at a key derived from it that nobody has the private key
+// for, so only Threshold signatures from Keys can spend from it.
+type Policy struct {
+	Threshold uint8
+	Keys      []*secp256k1.PublicKey
+}
+
 // Blockchain Operations
 
 type Op interface {
 	Encode() []byte
 	PerformOp(state *State) UndoOp
 	Validate(state *State) error
-	Sign(privKey *secp256k1.PrivateKey) *schnorr.Signature
-	CheckSig(sig *schnorr.Signature, pubKey *secp256k1.PublicKey) bool
+	// Signatures are only valid on the chain they were made for
+	Sign(privKey *secp256k1.PrivateKey, chainID uint32) *schnorr.Signature
+	CheckSig(sig *schnorr.Signature, pubKey *secp256k1.PublicKey, chainID uint32) bool
+	GetFee() uint64
+	GetNonce() uint32
+	// The key whose balance pays the fee and whose nonce the op consumes. Nil if it can't be resolved.
+	LiableKey(state *State) *secp256k1.PublicKey
 }
 
 type UndoOp interface {
@@ -0,0 +1,229 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	t "gold/types"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+var (
+	ErrNoSeed  = errors.New("wallet has no HD seed")
+	ErrHasSeed = errors.New("wallet already has an HD seed")
+)
+
+// How many unused keys in a row discovery looks past before deciding an account has no more
+const DefaultGapLimit = 20
+
+// Creates a wallet from a mnemonic and finds every key of it that has been used in state, so a wallet
+// lost with nothing but its mnemonic comes back with its balances and names
+func Restore(path string, passphrase string, params KDFParams, mnemonic string, mnemonicPassphrase string, state *t.State, gapLimit int) (*Wallet, error) {
+	seed, err := MnemonicSeed(mnemonic, mnemonicPassphrase)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer clear(seed)
+	w, err := Create(path, passphrase, params)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := w.SetSeed(seed); err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Discover(state, gapLimit); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Gives the wallet the seed new keys are derived from, usually from MnemonicSeed. A wallet only ever
+// has one seed.
+func (w *Wallet) SetSeed(seed []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.key == nil {
+		return ErrLocked
+	}
+
+	if w.store.HD != nil {
+		return ErrHasSeed
+	}
+
+	if _, err := NewMasterKey(seed); err != nil {
+		return err
+	}
+
+	sealedSeed, err := seal(w.key, seed, nil)
+
+	if err != nil {
+		return err
+	}
+
+	w.store.HD = &hdState{Seed: sealedSeed, NextIndex: make(map[uint32]uint32)}
+	return w.store.save(w.path)
+}
+
+func (w *Wallet) HasSeed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.store.HD != nil
+}
+
+// Derives the account's next key from the seed and adds it to the wallet
+func (w *Wallet) NewHDKey(account uint32, label string) (*secp256k1.PublicKey, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	chain, err := w.accountChain(account)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer chain.Zero()
+
+	for index := w.store.HD.NextIndex[account]; ; index++ {
+		child, err := chain.Child(index)
+
+		if err == ErrInvalidChild {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		sk := child.PrivateKey()
+		child.Zero()
+		key := sk.PubKey()
+		err = w.add(sk, label, KeyPath(account, index))
+		sk.Zero()
+
+		if err != nil && err != ErrKeyExists {
+			return nil, err
+		}
+
+		w.store.HD.NextIndex[account] = index + 1
+		return key, w.store.save(w.path)
+	}
+}
+
+// Scans state for keys derived from the seed that have been used, meaning they have a balance, have
+// sent an op or own a name, and adds them to the wallet along with every key before them in their
+// account. Accounts are scanned in order until one has no used keys, and each account until gapLimit
+// keys in a row are unused. Returns how many keys were added.
+func (w *Wallet) Discover(state *t.State, gapLimit int) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.key == nil {
+		return 0, ErrLocked
+	}
+
+	if w.store.HD == nil {
+		return 0, ErrNoSeed
+	}
+
+	owners := make(map[secp256k1.PublicKey]bool)
+
+	for _, owner := range state.KeyNameSet {
+		owners[*owner] = true
+	}
+
+	used := func(key *secp256k1.PublicKey) bool {
+		account, exists := state.AccountSet[*key]
+		return owners[*key] || exists && (account.Balance > 0 || account.Nonce > 0)
+	}
+
+	added := 0
+
+	for account := uint32(0); account < HardenedOffset; account++ {
+		chain, err := w.accountChain(account)
+
+		if err != nil {
+			return added, err
+		}
+
+		keys := make([]*secp256k1.PrivateKey, 0)
+		lastUsed := -1
+
+		for index := 0; index-lastUsed <= gapLimit; index++ {
+			child, err := chain.Child(uint32(index))
+
+			if err != nil {
+				keys = append(keys, nil)
+				continue
+			}
+
+			sk := child.PrivateKey()
+			child.Zero()
+			keys = append(keys, sk)
+
+			if used(sk.PubKey()) {
+				lastUsed = index
+			}
+		}
+
+		chain.Zero()
+
+		for index, sk := range keys {
+			if sk == nil {
+				continue
+			}
+
+			if index <= lastUsed {
+				if err := w.add(sk, "", KeyPath(account, uint32(index))); err == nil {
+					added += 1
+				} else if err != ErrKeyExists {
+					return added, err
+				}
+			}
+
+			sk.Zero()
+		}
+
+		if lastUsed == -1 {
+			break
+		}
+
+		w.store.HD.NextIndex[account] = max(w.store.HD.NextIndex[account], uint32(lastUsed+1))
+	}
+
+	return added, w.store.save(w.path)
+}
+
+// The parent of an account's keys, m/44'/CoinType'/account'/0. The caller should Zero it when done.
+func (w *Wallet) accountChain(account uint32) (*ExtendedKey, error) {
+	if w.key == nil {
+		return nil, ErrLocked
+	}
+
+	if w.store.HD == nil {
+		return nil, ErrNoSeed
+	}
+
+	seed, err := open(w.key, w.store.HD.Seed, nil)
+
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+
+	defer clear(seed)
+	master, err := NewMasterKey(seed)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer master.Zero()
+	return master.Derive(fmt.Sprintf("m/44'/%d'/%d'/0", CoinType, account))
+}
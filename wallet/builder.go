@@ -0,0 +1,305 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	b "gold/blockchain"
+	"gold/fees"
+	"gold/mempool"
+	t "gold/types"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/schnorr"
+)
+
+const MaxPayments = b.MaxPayments
+
+var (
+	ErrInsufficientBalance = errors.New("balance is too low")
+	ErrNoPayments          = errors.New("txn has no payments")
+	ErrTooManyPayments     = b.ErrTooManyPayments
+	ErrUnknownAddress      = errors.New("address does not exist")
+	ErrWrongKey            = errors.New("private key doesn't belong to the account paying")
+	ErrAmountOverflow      = errors.New("payments add up to more than fits in a uint64")
+)
+
+// Builds signed ops ready to submit. The nonce comes from the tip state plus the sender's ops already in
+// the mempool, so several ops can be built back to back, and the fee is the fee rate times the signed op's
+// encoded size. Unless FeeRate is set, the rate comes from the estimator, or fees.FallbackFeeRate
+// without one. Anything the sender can't afford, counting what their pending ops will spend, is
+// refused before it's signed.
+type Builder struct {
+	chain *b.Chain
+	pool  *mempool.Pool
+	fees  *fees.Estimator
+	// Fee per byte of the encoded op, 0 to estimate it
+	FeeRate uint64
+	// How soon estimated fees aim to get ops mined
+	TargetBlocks int
+}
+
+// estimator can be nil
+func NewBuilder(chain *b.Chain, pool *mempool.Pool, estimator *fees.Estimator) *Builder {
+	return &Builder{chain: chain, pool: pool, fees: estimator, TargetBlocks: fees.DefaultTargetBlocks}
+}
+
+func (bl *Builder) feeRate() uint64 {
+	if bl.FeeRate != 0 {
+		return bl.FeeRate
+	}
+
+	if bl.fees != nil {
+		return bl.fees.EstimateFee(bl.TargetBlocks)
+	}
+
+	return fees.FallbackFeeRate
+}
+
+// A txn from sender making every payment. sk must be the key sender resolves to.
+func (bl *Builder) Txn(sender t.Address, sk *secp256k1.PrivateKey, payments []b.Payment) (*b.Txn, error) {
+	unsigned, err := bl.UnsignedTxn(sender, sk.PubKey(), payments)
+
+	if err != nil {
+		return nil, err
+	}
+
+	signed, err := unsigned.Sign(sk)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return signed.(*b.Txn), nil
+}
+
+// A rename of name to newKey. sk must be the name's current owner, or newKey's if the name is unclaimed,
+// since they're the one paying.
+func (bl *Builder) Rename(name string, sk *secp256k1.PrivateKey, newKey *secp256k1.PublicKey) (*b.Rename, error) {
+	unsigned, err := bl.UnsignedRename(name, sk.PubKey(), newKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	signed, err := unsigned.Sign(sk)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return signed.(*b.Rename), nil
+}
+
+// A txn from a policy's account, signed by enough of cosigners' keys to meet its threshold
+func (bl *Builder) PolicyTxn(sender t.Address, cosigners []*secp256k1.PrivateKey, payments []b.Payment) (*b.Txn, error) {
+	keys := make([]*secp256k1.PublicKey, len(cosigners))
+
+	for i, sk := range cosigners {
+		keys[i] = sk.PubKey()
+	}
+
+	unsigned, policy, err := bl.unsignedTxn(sender, nil, keys, payments)
+
+	if err != nil {
+		return nil, err
+	}
+
+	txn := *unsigned.Op.(*b.Txn)
+
+	for _, sk := range cosigners {
+		txn.Cosignatures = append(txn.Cosignatures, b.Cosignature{Index: uint8(b.PolicyIndex(policy, sk.PubKey())), Signature: txn.Sign(sk, unsigned.ChainID)})
+	}
+
+	return &txn, nil
+}
+
+// Like Txn, but left for signer to sign elsewhere
+func (bl *Builder) UnsignedTxn(sender t.Address, signer *secp256k1.PublicKey, payments []b.Payment) (*UnsignedOp, error) {
+	unsigned, _, err := bl.unsignedTxn(sender, signer, nil, payments)
+	return unsigned, err
+}
+
+// Either signer or cosigners is set. With cosigners, sender has to be a policy's account and the policy
+// is returned.
+func (bl *Builder) unsignedTxn(sender t.Address, signer *secp256k1.PublicKey, cosigners []*secp256k1.PublicKey, payments []b.Payment) (*UnsignedOp, *t.Policy, error) {
+	if len(payments) == 0 {
+		return nil, nil, ErrNoPayments
+	}
+
+	if len(payments) > MaxPayments {
+		return nil, nil, ErrTooManyPayments
+	}
+
+	txn := &b.Txn{Sender: sender, Payments: payments, Signature: b.MinimalSignature()}
+	unsigned := &UnsignedOp{Op: txn, ChainID: bl.chain.Params().ChainID, Signer: signer}
+
+	var total uint64
+
+	for _, payment := range payments {
+		if total+payment.Amount < total {
+			return nil, nil, ErrAmountOverflow
+		}
+
+		total += payment.Amount
+	}
+
+	rate := bl.feeRate()
+
+	var policy *t.Policy
+	var err error
+
+	bl.chain.ReadState(func(state *t.State, tip [32]byte) {
+		for _, addr := range (&b.Txn{Sender: sender, Payments: payments}).Addresses() {
+			key := b.AddressToPk(&addr, &state.KeyNameSet)
+
+			if key == nil {
+				err = fmt.Errorf("%w: %s", ErrUnknownAddress, bl.chain.Params().FormatAddress(addr))
+				return
+			}
+
+			if addr.UsesName {
+				unsigned.resolve(*addr.Name, key)
+			}
+		}
+
+		txn.Fee = fee(state, txn, rate)
+		unsigned.Fee = txn.Fee
+		txn.Nonce, policy, err = bl.prepare(state, txn, signer, cosigners, total)
+		unsigned.Nonce = txn.Nonce
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return unsigned, policy, nil
+}
+
+// Like Rename, but left for signer to sign elsewhere
+func (bl *Builder) UnsignedRename(name string, signer *secp256k1.PublicKey, newKey *secp256k1.PublicKey) (*UnsignedOp, error) {
+	rename := &b.Rename{Name: name, NewKey: newKey, Signature: b.MinimalSignature()}
+	unsigned := &UnsignedOp{Op: rename, ChainID: bl.chain.Params().ChainID, Signer: signer}
+	rate := bl.feeRate()
+
+	var err error
+
+	bl.chain.ReadState(func(state *t.State, tip [32]byte) {
+		if owner, exists := state.KeyNameSet[name]; exists {
+			unsigned.resolve(name, owner)
+		}
+
+		rename.Fee = fee(state, rename, rate)
+		unsigned.Fee = rename.Fee
+		rename.Nonce, _, err = bl.prepare(state, rename, signer, nil, 0)
+		unsigned.Nonce = rename.Nonce
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return unsigned, nil
+}
+
+// rate times the size op will be once signed. An op a policy is liable for carries a count and Threshold
+// cosignatures, each an index and a signature, in place of its one signature.
+func fee(state *t.State, op t.Op, rate uint64) uint64 {
+	size := len(op.Encode())
+
+	if key := op.LiableKey(state); key != nil {
+		if policy, isPolicy := state.PolicySet[*key]; isPolicy {
+			size += 1 + int(policy.Threshold)*(1+schnorr.SignatureSize) - schnorr.SignatureSize
+		}
+	}
+
+	return rate * uint64(size)
+}
+
+// Checks that signer, or cosigners for a policy, are liable for op and can afford its fee plus amount after
+// its pending ops, and returns the nonce op should use and the policy if cosigners signs for one
+func (bl *Builder) prepare(state *t.State, op t.Op, signer *secp256k1.PublicKey, cosigners []*secp256k1.PublicKey, amount uint64) (uint32, *t.Policy, error) {
+	key := op.LiableKey(state)
+
+	if key == nil {
+		return 0, nil, fmt.Errorf("%w: sender", ErrUnknownAddress)
+	}
+
+	policy := state.PolicySet[*key]
+
+	if cosigners == nil {
+		if !key.IsEqual(signer) {
+			return 0, nil, ErrWrongKey
+		}
+	} else if err := checkCosigners(policy, cosigners); err != nil {
+		return 0, nil, err
+	}
+
+	account, exists := state.AccountSet[*key]
+
+	if !exists {
+		return 0, nil, fmt.Errorf("%w: the account has never been paid", ErrInsufficientBalance)
+	}
+
+	pending := bl.pool.Pending(key)
+	available := account.Balance
+
+	for _, pendingOp := range pending {
+		available -= min(available, spends(pendingOp))
+	}
+
+	needed := amount + op.GetFee()
+
+	if needed < amount {
+		return 0, nil, ErrAmountOverflow
+	}
+
+	if needed > available {
+		return 0, nil, fmt.Errorf("%w: needs %d but only %d is available after %d pending ops", ErrInsufficientBalance, needed, available, len(pending))
+	}
+
+	nonce := account.Nonce
+
+	if len(pending) > 0 {
+		nonce = pending[len(pending)-1].GetNonce() + 1
+	}
+
+	return nonce, policy, nil
+}
+
+// Each cosigner has to be a different one of the policy's keys, and there have to be enough of them
+func checkCosigners(policy *t.Policy, cosigners []*secp256k1.PublicKey) error {
+	if policy == nil {
+		return fmt.Errorf("%w: sender isn't a policy", ErrWrongKey)
+	}
+
+	seen := make(map[int]bool)
+
+	for _, cosigner := range cosigners {
+		index := b.PolicyIndex(policy, cosigner)
+
+		if index < 0 || seen[index] {
+			return fmt.Errorf("%w: cosigners must be distinct keys of the policy", ErrWrongKey)
+		}
+
+		seen[index] = true
+	}
+
+	if len(cosigners) < int(policy.Threshold) {
+		return fmt.Errorf("%w: has %d of %d", b.ErrThresholdNotMet, len(cosigners), policy.Threshold)
+	}
+
+	return nil
+}
+
+// How much an op takes from the account liable for it
+func spends(op t.Op) uint64 {
+	spent := op.GetFee()
+
+	if txn, ok := op.(*b.Txn); ok {
+		for _, payment := range txn.Payments {
+			spent += payment.Amount
+		}
+	}
+
+	return spent
+}
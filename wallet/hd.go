@@ -0,0 +1,134 @@
+package wallet
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+const (
+	// Indexes from here up are hardened, their children can't be derived from the parent's public key
+	HardenedOffset uint32 = 1 << 31
+	// Second level of the path keys are derived at. It isn't registered anywhere, it just keeps gold keys
+	// apart from other coins' keys made from the same seed.
+	CoinType uint32 = 7707
+)
+
+var (
+	// Happens for about 1 in 2^127 indexes, BIP32 says to skip to the next one
+	ErrInvalidChild = errors.New("index derives an invalid key")
+	ErrInvalidPath  = errors.New("derivation path is invalid")
+)
+
+// A BIP32 extended private key
+type ExtendedKey struct {
+	key       secp256k1.ModNScalar
+	chainCode [32]byte
+	Depth     uint8
+	Index     uint32
+}
+
+// The root key for a seed. Uses the same HMAC key as BIP32, so its test vectors hold.
+func NewMasterKey(seed []byte) (*ExtendedKey, error) {
+	mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+
+	master := &ExtendedKey{}
+
+	if overflow := master.key.SetByteSlice(sum[:32]); overflow || master.key.IsZero() {
+		return nil, ErrInvalidChild
+	}
+
+	copy(master.chainCode[:], sum[32:])
+	return master, nil
+}
+
+func (k *ExtendedKey) Child(index uint32) (*ExtendedKey, error) {
+	data := make([]byte, 0, 37)
+
+	if index >= HardenedOffset {
+		keyBytes := k.key.Bytes()
+		data = append(append(data, 0), keyBytes[:]...)
+	} else {
+		data = append(data, k.PublicKey().SerializeCompressed()...)
+	}
+
+	data = binary.BigEndian.AppendUint32(data, index)
+
+	mac := hmac.New(sha512.New, k.chainCode[:])
+	mac.Write(data)
+	sum := mac.Sum(nil)
+
+	child := &ExtendedKey{Depth: k.Depth + 1, Index: index}
+
+	if overflow := child.key.SetByteSlice(sum[:32]); overflow {
+		return nil, ErrInvalidChild
+	}
+
+	child.key.Add(&k.key)
+
+	if child.key.IsZero() {
+		return nil, ErrInvalidChild
+	}
+
+	copy(child.chainCode[:], sum[32:])
+	return child, nil
+}
+
+// Derives along a path like m/44'/7707'/0'/0/3, where ' or h marks a hardened index
+func (k *ExtendedKey) Derive(path string) (*ExtendedKey, error) {
+	parts := strings.Split(path, "/")
+
+	if parts[0] != "m" {
+		return nil, ErrInvalidPath
+	}
+
+	key := k
+
+	for _, part := range parts[1:] {
+		offset := uint32(0)
+
+		if strings.HasSuffix(part, "'") || strings.HasSuffix(part, "h") {
+			offset = HardenedOffset
+			part = part[:len(part)-1]
+		}
+
+		index, err := strconv.ParseUint(part, 10, 31)
+
+		if err != nil {
+			return nil, ErrInvalidPath
+		}
+
+		if key, err = key.Child(uint32(index) + offset); err != nil {
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+func (k *ExtendedKey) PrivateKey() *secp256k1.PrivateKey {
+	return secp256k1.NewPrivateKey(&k.key)
+}
+
+func (k *ExtendedKey) PublicKey() *secp256k1.PublicKey {
+	return k.PrivateKey().PubKey()
+}
+
+// Wipes the key from memory
+func (k *ExtendedKey) Zero() {
+	k.key.Zero()
+	clear(k.chainCode[:])
+}
+
+// Where the key at index of an account is derived: m/44'/CoinType'/account'/0/index
+func KeyPath(account uint32, index uint32) string {
+	return fmt.Sprintf("m/44'/%d'/%d'/0/%d", CoinType, account, index)
+}
@@ -0,0 +1,149 @@
+package wallet
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const keystoreVersion = 1
+
+// Encrypted with the passphrase's key on creation, so Unlock can tell a wrong passphrase even when the
+// wallet has no keys yet
+var checkPlaintext = []byte("gold wallet")
+
+// How the passphrase is stretched into the encryption key. Stored in the keystore so the defaults can be
+// raised later without breaking old wallets.
+type KDFParams struct {
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+	Salt []byte `json:"salt"`
+}
+
+// Takes about 100ms and 32MB
+var DefaultKDFParams = KDFParams{N: 1 << 15, R: 8, P: 1}
+
+// The keystore as it's saved. Everything but the private keys is in the clear, so the wallet can list
+// its keys and labels while locked.
+type keystore struct {
+	Version int               `json:"version"`
+	KDF     KDFParams         `json:"kdf"`
+	Check   sealed            `json:"check"`
+	Keys    []*storedKey      `json:"keys"`
+	Names   map[string]string `json:"names"`
+	// Only set once the wallet has an HD seed
+	HD *hdState `json:"hd,omitempty"`
+}
+
+type hdState struct {
+	Seed sealed `json:"seed"`
+	// The index the next new key of each account will get
+	NextIndex map[uint32]uint32 `json:"nextIndex"`
+}
+
+type storedKey struct {
+	// Compressed public key
+	PublicKey []byte `json:"publicKey"`
+	Label     string `json:"label"`
+	Private   sealed `json:"private"`
+	// Set for keys derived from the HD seed
+	Path string `json:"path,omitempty"`
+}
+
+// AES-256-GCM ciphertext with its nonce
+type sealed struct {
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+func deriveKey(passphrase string, params KDFParams) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), params.Salt, params.N, params.R, params.P, 32)
+}
+
+// additional is authenticated but not encrypted. Private keys use their public key, so a key can't be
+// swapped onto another entry without it failing to open.
+func seal(key []byte, plaintext []byte, additional []byte) (sealed, error) {
+	aead, err := newAEAD(key)
+
+	if err != nil {
+		return sealed{}, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	rand.Read(nonce)
+
+	return sealed{Nonce: nonce, Ciphertext: aead.Seal(nil, nonce, plaintext, additional)}, nil
+}
+
+func open(key []byte, box sealed, additional []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(box.Nonce) != aead.NonceSize() {
+		return nil, ErrCorrupt
+	}
+
+	return aead.Open(nil, box.Nonce, box.Ciphertext, additional)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func loadKeystore(path string) (*keystore, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var store keystore
+
+	if err := json.Unmarshal(data, &store); err != nil {
+		return nil, err
+	}
+
+	if store.Version != keystoreVersion {
+		return nil, errors.New("unsupported keystore version")
+	}
+
+	if store.Names == nil {
+		store.Names = make(map[string]string)
+	}
+
+	if store.HD != nil && store.HD.NextIndex == nil {
+		store.HD.NextIndex = make(map[uint32]uint32)
+	}
+
+	return &store, nil
+}
+
+// Writes through a temporary file so a crash never leaves half a keystore behind
+func (s *keystore) save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
@@ -0,0 +1,128 @@
+package wallet
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	_ "embed"
+	"errors"
+	"strings"
+)
+
+// The BIP39 English wordlist
+//
+//go:embed english.txt
+var englishWords string
+
+var (
+	wordList    = strings.Split(strings.TrimSpace(englishWords), "\n")
+	wordIndexes = make(map[string]int, len(wordList))
+)
+
+var (
+	ErrInvalidMnemonic = errors.New("mnemonic is invalid")
+	ErrEntropySize     = errors.New("entropy must be 128 to 256 bits in steps of 32")
+)
+
+func init() {
+	for i, word := range wordList {
+		wordIndexes[word] = i
+	}
+}
+
+// A new random mnemonic with bits of entropy. 128 bits makes 12 words and 256 makes 24.
+func NewMnemonic(bits int) (string, error) {
+	if bits < 128 || bits > 256 || bits%32 != 0 {
+		return "", ErrEntropySize
+	}
+
+	entropy := make([]byte, bits/8)
+	rand.Read(entropy)
+
+	return MnemonicFromEntropy(entropy)
+}
+
+// Encodes entropy as words the way BIP39 does: the entropy followed by the first bits of its sha256,
+// split into 11 bit indexes into the wordlist
+func MnemonicFromEntropy(entropy []byte) (string, error) {
+	bits := len(entropy) * 8
+
+	if bits < 128 || bits > 256 || bits%32 != 0 {
+		return "", ErrEntropySize
+	}
+
+	checksum := sha256.Sum256(entropy)
+	data := append(append([]byte{}, entropy...), checksum[0])
+	words := make([]string, 0, (bits+bits/32)/11)
+
+	for i := 0; i < bits+bits/32; i += 11 {
+		words = append(words, wordList[readBits(data, i, 11)])
+	}
+
+	return strings.Join(words, " "), nil
+}
+
+// Decodes a mnemonic back to its entropy, checking every word and the checksum
+func MnemonicToEntropy(mnemonic string) ([]byte, error) {
+	words := strings.Fields(strings.ToLower(mnemonic))
+
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return nil, ErrInvalidMnemonic
+	}
+
+	totalBits := len(words) * 11
+	data := make([]byte, (totalBits+7)/8)
+
+	for i, word := range words {
+		index, exists := wordIndexes[word]
+
+		if !exists {
+			return nil, ErrInvalidMnemonic
+		}
+
+		writeBits(data, i*11, 11, index)
+	}
+
+	entropyBits := totalBits * 32 / 33
+	entropy := data[:entropyBits/8]
+	checksum := sha256.Sum256(entropy)
+	checksumBits := totalBits - entropyBits
+
+	if readBits(data, entropyBits, checksumBits) != readBits(checksum[:], 0, checksumBits) {
+		return nil, ErrInvalidMnemonic
+	}
+
+	return entropy, nil
+}
+
+// The seed HD keys are derived from. The passphrase is optional, and a different one gives a completely
+// different wallet. Words are normalized by case and spacing only, which covers the English wordlist.
+func MnemonicSeed(mnemonic string, passphrase string) ([]byte, error) {
+	if _, err := MnemonicToEntropy(mnemonic); err != nil {
+		return nil, err
+	}
+
+	normalized := strings.Join(strings.Fields(strings.ToLower(mnemonic)), " ")
+	return pbkdf2.Key(sha512.New, normalized, []byte("mnemonic"+passphrase), 2048, 64)
+}
+
+// Reads count bits starting at bit offset, most significant first
+func readBits(data []byte, offset int, count int) int {
+	value := 0
+
+	for i := offset; i < offset+count; i++ {
+		value = value<<1 | int(data[i/8]>>(7-i%8)&1)
+	}
+
+	return value
+}
+
+func writeBits(data []byte, offset int, count int, value int) {
+	for i := 0; i < count; i++ {
+		if value>>(count-1-i)&1 == 1 {
+			bit := offset + i
+			data[bit/8] |= 1 << (7 - bit%8)
+		}
+	}
+}
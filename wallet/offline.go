@@ -0,0 +1,362 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	b "gold/blockchain"
+	"gold/mempool"
+	t "gold/types"
+	"strings"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/schnorr"
+)
+
+const unsignedOpVersion = 1
+
+var (
+	ErrUnsignedOpInvalid = errors.New("unsigned op is invalid")
+	ErrSignedOpMismatch  = errors.New("signed op is not the op that was sent for signing")
+)
+
+// A name in an unsigned op and the key it resolved to when the op was built
+type ResolvedName struct {
+	Name string
+	Key  *secp256k1.PublicKey
+}
+
+// An op built online and carried to an offline machine to be signed. It has what the offline machine
+// needs to check what it's signing without a copy of the chain: which key has to sign, the nonce and
+// fee, and the keys the op's names resolved to. The op itself has the minimal signature until it's signed.
+//
+// It's encoded as bytes with Encode, and as text with Hex or Base64, both of which ParseUnsignedOp reads.
+type UnsignedOp struct {
+	Op      t.Op
+	ChainID uint32
+	Signer  *secp256k1.PublicKey
+	Nonce   uint32
+	Fee     uint64
+	Names   []ResolvedName
+}
+
+func (u *UnsignedOp) resolve(name string, key *secp256k1.PublicKey) {
+	for _, resolved := range u.Names {
+		if resolved.Name == name {
+			return
+		}
+	}
+
+	u.Names = append(u.Names, ResolvedName{Name: name, Key: key})
+}
+
+// The version, chain ID, length prefixed op, signer, nonce, fee, then each resolved name and key
+func (u *UnsignedOp) Encode() []byte {
+	data := []byte{unsignedOpVersion}
+	data = binary.LittleEndian.AppendUint32(data, u.ChainID)
+
+	op := u.Op.Encode()
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(op)))
+	data = append(data, op...)
+	data = append(data, u.Signer.SerializeCompressed()...)
+	data = binary.LittleEndian.AppendUint32(data, u.Nonce)
+	data = binary.LittleEndian.AppendUint64(data, u.Fee)
+	data = append(data, byte(len(u.Names)))
+
+	for _, resolved := range u.Names {
+		data = append(data, byte(len(resolved.Name)))
+		data = append(data, resolved.Name...)
+		data = append(data, resolved.Key.SerializeCompressed()...)
+	}
+
+	return data
+}
+
+func (u *UnsignedOp) Hex() string {
+	return hex.EncodeToString(u.Encode())
+}
+
+func (u *UnsignedOp) Base64() string {
+	return base64.StdEncoding.EncodeToString(u.Encode())
+}
+
+// Reads an unsigned op from its hex or base64 text and checks it's consistent
+func ParseUnsignedOp(text string) (*UnsignedOp, error) {
+	return decodeText(text, DecodeUnsignedOp)
+}
+
+func DecodeUnsignedOp(data []byte) (*UnsignedOp, error) {
+	r := b.NewReader(data)
+	u := &UnsignedOp{}
+
+	if version := r.Byte(); r.Err() == nil && version != unsignedOpVersion {
+		return nil, fmt.Errorf("%w: unknown version %d", ErrUnsignedOpInvalid, version)
+	}
+
+	u.ChainID = r.Uint32()
+	opData := r.Next(int(r.Uint32()))
+
+	if r.Err() != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnsignedOpInvalid, r.Err())
+	}
+
+	op, err := b.DecodeOp(opData)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnsignedOpInvalid, err)
+	}
+
+	u.Op = op
+	u.Signer = r.PubKey()
+	u.Nonce = r.Uint32()
+	u.Fee = r.Uint64()
+	count := int(r.Byte())
+
+	for i := 0; i < count && r.Err() == nil; i++ {
+		u.Names = append(u.Names, ResolvedName{Name: r.String(), Key: r.PubKey()})
+	}
+
+	if r.Err() != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnsignedOpInvalid, r.Err())
+	}
+
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%w: data continues past the end", ErrUnsignedOpInvalid)
+	}
+
+	return u, u.Check()
+}
+
+// Checks the op agrees with the context sent with it: same nonce and fee, still unsigned, every name it
+// uses resolved, and the signer being the key that pays
+func (u *UnsignedOp) Check() error {
+	if u.Op.GetNonce() != u.Nonce || u.Op.GetFee() != u.Fee {
+		return fmt.Errorf("%w: nonce or fee doesn't match the op", ErrUnsignedOpInvalid)
+	}
+
+	var payer *secp256k1.PublicKey
+
+	switch op := u.Op.(type) {
+	case *b.Txn:
+		if isSigned(op.Signature, op.Cosignatures) {
+			return fmt.Errorf("%w: op is already signed", ErrUnsignedOpInvalid)
+		}
+
+		for _, addr := range op.Addresses() {
+			if addr.UsesName && u.Resolve(*addr.Name) == nil {
+				return fmt.Errorf("%w: %s isn't resolved", ErrUnsignedOpInvalid, *addr.Name)
+			}
+		}
+
+		payer = op.Sender.Key
+
+		if op.Sender.UsesName {
+			payer = u.Resolve(*op.Sender.Name)
+		}
+	case *b.Rename:
+		if isSigned(op.Signature, op.Cosignatures) {
+			return fmt.Errorf("%w: op is already signed", ErrUnsignedOpInvalid)
+		}
+
+		// An unresolved name is unclaimed, so the new key pays
+		payer = u.Resolve(op.Name)
+
+		if payer == nil {
+			payer = op.NewKey
+		}
+	default:
+		return fmt.Errorf("%w: only txns and renames can be signed offline", ErrUnsignedOpInvalid)
+	}
+
+	if !payer.IsEqual(u.Signer) {
+		return fmt.Errorf("%w: signer isn't the key that pays", ErrUnsignedOpInvalid)
+	}
+
+	return nil
+}
+
+// Cosignatures count as signed too, even though Signature is left minimal alongside them
+func isSigned(sig *schnorr.Signature, cosigs []b.Cosignature) bool {
+	return len(cosigs) != 0 || !bytes.Equal(sig.Serialize(), b.MinimalSignature().Serialize())
+}
+
+// The key name resolved to, or nil
+func (u *UnsignedOp) Resolve(name string) *secp256k1.PublicKey {
+	for _, resolved := range u.Names {
+		if resolved.Name == name {
+			return resolved.Key
+		}
+	}
+
+	return nil
+}
+
+// What the signer is agreeing to, one fact per line, for showing before signing
+func (u *UnsignedOp) Describe() string {
+	network := fmt.Sprintf("unknown chain %d", u.ChainID)
+
+	if params := b.NetworkByChainID(u.ChainID); params != nil {
+		network = fmt.Sprintf("%s (chain %d)", params.Name, u.ChainID)
+	}
+
+	lines := []string{fmt.Sprintf("%s, signed by %s", network, u.format(b.AddrFromKey(u.Signer)))}
+
+	switch op := u.Op.(type) {
+	case *b.Txn:
+		lines = append(lines, "send from "+u.describe(op.Sender))
+
+		for _, payment := range op.Payments {
+			lines = append(lines, fmt.Sprintf("pay %d to %s", payment.Amount, u.describe(payment.Reciever)))
+		}
+	case *b.Rename:
+		lines = append(lines, fmt.Sprintf("give %s to %s", u.format(b.AddrFromName(op.Name)), u.format(b.AddrFromKey(op.NewKey))))
+	}
+
+	lines = append(lines, fmt.Sprintf("fee %d, nonce %d", u.Fee, u.Nonce))
+
+	if hash, err := u.SigningHash(); err == nil {
+		lines = append(lines, fmt.Sprintf("signing hash %x", hash))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// What the signer signs. Signers that don't use this package can compute it from the op's bytes in the
+// encoding, see b.VersionedSigningHash, and check it against this.
+func (u *UnsignedOp) SigningHash() ([32]byte, error) {
+	return b.VersionedSigningHash(b.SigHashVersion, u.Op, u.ChainID)
+}
+
+func (u *UnsignedOp) describe(addr t.Address) string {
+	if addr.UsesName {
+		return fmt.Sprintf("%s (%s)", u.format(addr), u.format(b.AddrFromKey(u.Resolve(*addr.Name))))
+	}
+
+	return u.format(addr)
+}
+
+// The address as the op's network writes it. Keys on chains this build doesn't know are shown as hex.
+func (u *UnsignedOp) format(addr t.Address) string {
+	if params := b.NetworkByChainID(u.ChainID); params != nil {
+		return params.FormatAddress(addr)
+	}
+
+	// Names and empty addresses come out the same whatever the prefix
+	if addr.UsesName || addr.Key == nil {
+		return addr.Format("")
+	}
+
+	return fmt.Sprintf("%x", addr.Key.SerializeCompressed())
+}
+
+// Signs the op with sk, which has to be the signer's. Done on the offline machine.
+func (u *UnsignedOp) Sign(sk *secp256k1.PrivateKey) (t.Op, error) {
+	if err := u.Check(); err != nil {
+		return nil, err
+	}
+
+	if !sk.PubKey().IsEqual(u.Signer) {
+		return nil, ErrWrongKey
+	}
+
+	switch op := u.Op.(type) {
+	case *b.Txn:
+		signed := *op
+		signed.Signature = op.Sign(sk, u.ChainID)
+		return &signed, nil
+	case *b.Rename:
+		signed := *op
+		signed.Signature = op.Sign(sk, u.ChainID)
+		return &signed, nil
+	}
+
+	return nil, fmt.Errorf("%w: unknown op type", ErrUnsignedOpInvalid)
+}
+
+// Checks signed is unsigned's op with a valid signature from its signer, then adds it to the mempool,
+// which relays it. Done back on the online node.
+func Broadcast(pool *mempool.Pool, unsigned *UnsignedOp, signed t.Op) error {
+	if !bytes.Equal(withoutSignature(signed), unsigned.Op.Encode()) {
+		return ErrSignedOpMismatch
+	}
+
+	var valid bool
+
+	switch op := signed.(type) {
+	case *b.Txn:
+		valid = op.CheckSig(op.Signature, unsigned.Signer, unsigned.ChainID)
+	case *b.Rename:
+		valid = op.CheckSig(op.Signature, unsigned.Signer, unsigned.ChainID)
+	}
+
+	if !valid {
+		return fmt.Errorf("%w: signature doesn't verify", ErrSignedOpMismatch)
+	}
+
+	return pool.Add(signed)
+}
+
+func withoutSignature(op t.Op) []byte {
+	switch op := op.(type) {
+	case *b.Txn:
+		unsigned := *op
+		unsigned.Signature = b.MinimalSignature()
+		unsigned.Cosignatures = nil
+		return unsigned.Encode()
+	case *b.Rename:
+		unsigned := *op
+		unsigned.Signature = b.MinimalSignature()
+		unsigned.Cosignatures = nil
+		return unsigned.Encode()
+	}
+
+	return nil
+}
+
+// Hex of a signed op's encoding, the same as submitOp takes
+func FormatOp(op t.Op) string {
+	return hex.EncodeToString(op.Encode())
+}
+
+// Reads an op from the hex or base64 of its encoding
+func ParseOp(text string) (t.Op, error) {
+	return decodeText(text, b.DecodeOp)
+}
+
+// Reads text as hex, then as base64, until decode accepts one of them. Hex with an even length is
+// valid base64 as well, so the first reading that decodes isn't necessarily the right one.
+func decodeText[T any](text string, decode func([]byte) (T, error)) (T, error) {
+	text = strings.TrimSpace(text)
+
+	var firstErr error
+
+	for _, read := range []func(string) ([]byte, error){hex.DecodeString, base64.StdEncoding.DecodeString} {
+		data, err := read(text)
+
+		if err != nil {
+			continue
+		}
+
+		result, err := decode(data)
+
+		if err == nil {
+			return result, nil
+		}
+
+		// Text that reads both ways was more likely meant as hex
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	var zero T
+
+	if firstErr == nil {
+		return zero, errors.New("text is neither hex nor base64")
+	}
+
+	return zero, firstErr
+}
@@ -0,0 +1,319 @@
+package wallet
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	t "gold/types"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+var (
+	ErrLocked          = errors.New("wallet is locked")
+	ErrWrongPassphrase = errors.New("passphrase is incorrect")
+	ErrUnknownKey      = errors.New("key is not in the wallet")
+	ErrKeyExists       = errors.New("key is already in the wallet")
+	ErrCorrupt         = errors.New("keystore is corrupt")
+	ErrWalletExists    = errors.New("wallet already exists")
+)
+
+type KeyInfo struct {
+	Key   *secp256k1.PublicKey
+	Label string
+}
+
+type NameInfo struct {
+	Name  string
+	Owner *secp256k1.PublicKey
+	Label string
+}
+
+// Private keys kept in a keystore file, encrypted with a key derived from a passphrase. The wallet opens
+// locked, and has to be unlocked before keys can be added, exported or used to sign. Labels and public
+// keys are stored in the clear.
+type Wallet struct {
+	path string
+
+	mu    sync.Mutex
+	store *keystore
+	// The passphrase's key while unlocked, nil while locked
+	key       []byte
+	lockTimer *time.Timer
+	// Goes up on every unlock and lock, so a timer from an earlier unlock that fires late can tell
+	// it's stale
+	generation uint64
+}
+
+// Creates a new empty wallet at path, which mustn't exist yet. The wallet starts unlocked with no timeout.
+func Create(path string, passphrase string, params KDFParams) (*Wallet, error) {
+	if _, err := os.Stat(path); err == nil {
+		return nil, ErrWalletExists
+	}
+
+	params.Salt = make([]byte, 32)
+	rand.Read(params.Salt)
+
+	key, err := deriveKey(passphrase, params)
+
+	if err != nil {
+		return nil, err
+	}
+
+	check, err := seal(key, checkPlaintext, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Wallet{
+		path:  path,
+		store: &keystore{Version: keystoreVersion, KDF: params, Check: check, Keys: make([]*storedKey, 0), Names: make(map[string]string)},
+		key:   key,
+	}
+
+	return w, w.store.save(path)
+}
+
+// Opens the wallet at path, locked
+func Open(path string) (*Wallet, error) {
+	store, err := loadKeystore(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Wallet{path: path, store: store}, nil
+}
+
+// Unlocks the wallet until timeout passes or Lock is called. A timeout of 0 keeps it unlocked until Lock.
+// Unlocking an unlocked wallet just restarts the timeout.
+func (w *Wallet) Unlock(passphrase string, timeout time.Duration) error {
+	key, err := deriveKey(passphrase, w.store.KDF)
+
+	if err != nil {
+		return err
+	}
+
+	check, err := open(key, w.store.Check, nil)
+
+	if err != nil || subtle.ConstantTimeCompare(check, checkPlaintext) != 1 {
+		return ErrWrongPassphrase
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.lock()
+	w.key = key
+
+	if timeout > 0 {
+		generation := w.generation
+
+		w.lockTimer = time.AfterFunc(timeout, func() {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+
+			// Stop doesn't wait for a timer that's already firing, so it may be from an older unlock
+			if w.generation == generation {
+				w.lock()
+			}
+		})
+	}
+
+	return nil
+}
+
+// Forgets the passphrase's key
+func (w *Wallet) Lock() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.lock()
+}
+
+// Called with mu held
+func (w *Wallet) lock() {
+	clear(w.key)
+	w.key = nil
+	w.generation += 1
+
+	if w.lockTimer != nil {
+		w.lockTimer.Stop()
+		w.lockTimer = nil
+	}
+}
+
+func (w *Wallet) Locked() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.key == nil
+}
+
+// Generates a new key and adds it to the wallet
+func (w *Wallet) NewKey(label string) (*secp256k1.PublicKey, error) {
+	sk, err := secp256k1.GeneratePrivateKey()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer sk.Zero()
+
+	if err := w.Import(sk, label); err != nil {
+		return nil, err
+	}
+
+	return sk.PubKey(), nil
+}
+
+func (w *Wallet) Import(sk *secp256k1.PrivateKey, label string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.add(sk, label, ""); err != nil {
+		return err
+	}
+
+	return w.store.save(w.path)
+}
+
+// Adds a key without saving, for callers adding several at once
+func (w *Wallet) add(sk *secp256k1.PrivateKey, label string, path string) error {
+	if w.key == nil {
+		return ErrLocked
+	}
+
+	pub := sk.PubKey().SerializeCompressed()
+
+	if w.find(pub) != nil {
+		return ErrKeyExists
+	}
+
+	secret := sk.Serialize()
+	defer clear(secret)
+
+	private, err := seal(w.key, secret, pub)
+
+	if err != nil {
+		return err
+	}
+
+	w.store.Keys = append(w.store.Keys, &storedKey{PublicKey: pub, Label: label, Private: private, Path: path})
+	return nil
+}
+
+// Decrypts a key's private key, for signing with or backing up. The caller should Zero it when done.
+func (w *Wallet) Export(key *secp256k1.PublicKey) (*secp256k1.PrivateKey, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.key == nil {
+		return nil, ErrLocked
+	}
+
+	pub := key.SerializeCompressed()
+	stored := w.find(pub)
+
+	if stored == nil {
+		return nil, ErrUnknownKey
+	}
+
+	secret, err := open(w.key, stored.Private, pub)
+
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+
+	defer clear(secret)
+	return secp256k1.PrivKeyFromBytes(secret), nil
+}
+
+func (w *Wallet) HasKey(key *secp256k1.PublicKey) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.find(key.SerializeCompressed()) != nil
+}
+
+// The wallet's keys in the order they were added
+func (w *Wallet) Keys() []KeyInfo {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	keys := make([]KeyInfo, 0, len(w.store.Keys))
+
+	for _, stored := range w.store.Keys {
+		key, err := secp256k1.ParsePubKey(stored.PublicKey)
+
+		if err == nil {
+			keys = append(keys, KeyInfo{Key: key, Label: stored.Label})
+		}
+	}
+
+	return keys
+}
+
+// Labels work while the wallet is locked, since they aren't encrypted
+func (w *Wallet) SetLabel(key *secp256k1.PublicKey, label string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	stored := w.find(key.SerializeCompressed())
+
+	if stored == nil {
+		return ErrUnknownKey
+	}
+
+	stored.Label = label
+	return w.store.save(w.path)
+}
+
+// Labels a name, whether or not one of the wallet's keys owns it yet. An empty label removes it.
+func (w *Wallet) SetNameLabel(name string, label string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if label == "" {
+		delete(w.store.Names, name)
+	} else {
+		w.store.Names[name] = label
+	}
+
+	return w.store.save(w.path)
+}
+
+// The names in state owned by the wallet's keys, with their labels, sorted by name
+func (w *Wallet) OwnedNames(state *t.State) []NameInfo {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	names := make([]NameInfo, 0)
+
+	for name, owner := range state.KeyNameSet {
+		if w.find(owner.SerializeCompressed()) != nil {
+			names = append(names, NameInfo{Name: name, Owner: owner, Label: w.store.Names[name]})
+		}
+	}
+
+	sort.Slice(names, func(i, j int) bool { return names[i].Name < names[j].Name })
+	return names
+}
+
+func (w *Wallet) find(pub []byte) *storedKey {
+	index := slices.IndexFunc(w.store.Keys, func(stored *storedKey) bool {
+		return slices.Equal(stored.PublicKey, pub)
+	})
+
+	if index == -1 {
+		return nil
+	}
+
+	return w.store.Keys[index]
+}